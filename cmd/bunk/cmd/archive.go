@@ -0,0 +1,1045 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/mholt/archiver/v3"
+	"github.com/nwaples/rardecode"
+)
+
+// maxArchiveDepth bounds how deeply archives nested in archives are unpacked
+const maxArchiveDepth = 10
+
+// archivePeekSize is how much of a stream is buffered to detect its format, enough for the
+// first tar header of compressed content to decompress from
+const archivePeekSize = 64 << 10
+
+// maxBufferedArchiveSize bounds the nested archives read into memory to be extracted by a
+// worker; larger ones are extracted as they are read
+const maxBufferedArchiveSize = 64 << 20
+
+// archiveHeaderSize is how much of a file is read to detect its format; tar's magic is at offset 257
+const archiveHeaderSize = 512
+
+// archiveExtensions are stripped from archive file names to name what they unpack to
+var archiveExtensions = []string{".tar", ".tgz", ".tbz2", ".txz", ".tzst", ".tlz4", ".tsz", ".gz", ".bz2", ".xz", ".zst", ".lz4", ".sz", ".zip", ".rar"}
+
+// archiveFormat : An archive format bunk extracts, recognized by its content
+type archiveFormat struct {
+	Name   string
+	Match  func(header []byte) bool
+	Reader func() archiver.Reader
+}
+
+// compressionFormat : A single-stream compression format bunk extracts, recognized by its magic bytes
+type compressionFormat struct {
+	Name         string
+	Magic        []byte
+	Decompressor func() archiver.Decompressor
+	// TarReader reads a tar compressed with this format
+	TarReader func() archiver.Reader
+}
+
+var archiveFormats = []archiveFormat{
+	{"zip", func(header []byte) bool {
+		return bytes.HasPrefix(header, []byte("PK\x03\x04")) || bytes.HasPrefix(header, []byte("PK\x05\x06"))
+	}, func() archiver.Reader { return archiver.NewZip() }},
+	{"rar", func(header []byte) bool {
+		return bytes.HasPrefix(header, []byte("Rar!\x1a\x07"))
+	}, func() archiver.Reader { return archiver.NewRar() }},
+	{"tar", isTarHeader, func() archiver.Reader { return archiver.NewTar() }},
+}
+
+var compressionFormats = []compressionFormat{
+	{"gzip", []byte{0x1f, 0x8b},
+		func() archiver.Decompressor { return archiver.NewGz() },
+		func() archiver.Reader { return archiver.NewTarGz() }},
+	{"bzip2", []byte("BZh"),
+		func() archiver.Decompressor { return archiver.NewBz2() },
+		func() archiver.Reader { return archiver.NewTarBz2() }},
+	{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00},
+		func() archiver.Decompressor { return archiver.NewXz() },
+		func() archiver.Reader { return archiver.NewTarXz() }},
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd},
+		func() archiver.Decompressor { return archiver.NewZstd() },
+		func() archiver.Reader { return archiver.NewTarZstd() }},
+	{"lz4", []byte{0x04, 0x22, 0x4d, 0x18},
+		func() archiver.Decompressor { return archiver.NewLz4() },
+		func() archiver.Reader { return archiver.NewTarLz4() }},
+	{"snappy", []byte("\xff\x06\x00\x00sNaPpY"),
+		func() archiver.Decompressor { return archiver.NewSnappy() },
+		func() archiver.Reader { return archiver.NewTarSz() }},
+}
+
+// bundleArchive : How to unpack a file, as detected from its content
+type bundleArchive struct {
+	Format string
+	// Reader reads archives; Decompressor single compressed files, which may in turn be archives
+	Reader       archiver.Reader
+	Decompressor archiver.Decompressor
+}
+
+func isTarHeader(header []byte) bool {
+	return len(header) >= 262 && string(header[257:262]) == "ustar"
+}
+
+// readHeader returns up to the first archiveHeaderSize bytes of the file at path
+func readHeader(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, archiveHeaderSize)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return header[:n], nil
+}
+
+// decompressedHeader returns the header of the content of the compressed stream r,
+// decompressing no more of it than needed
+func decompressedHeader(r io.Reader, decompressor archiver.Decompressor) ([]byte, error) {
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		pw.CloseWithError(decompressor.Decompress(r, pw))
+		close(done)
+	}()
+
+	header := make([]byte, archiveHeaderSize)
+	n, err := io.ReadFull(pr, header)
+	// Stop decompressing once the header is read
+	pr.Close()
+	<-done
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return header[:n], nil
+}
+
+// detectHeader detects how to unpack content from its header, using decompressed to look into
+// compressed content; it returns nil if the content is not an archive or compressed
+func detectHeader(header []byte, decompressed func(archiver.Decompressor) ([]byte, error)) (*bundleArchive, error) {
+	for _, format := range archiveFormats {
+		if format.Match(header) {
+			return &bundleArchive{Format: format.Name, Reader: format.Reader()}, nil
+		}
+	}
+
+	for _, format := range compressionFormats {
+		if !bytes.HasPrefix(header, format.Magic) {
+			continue
+		}
+		content, err := decompressed(format.Decompressor())
+		if err != nil {
+			return nil, err
+		}
+		if isTarHeader(content) {
+			return &bundleArchive{Format: "tar+" + format.Name, Reader: format.TarReader()}, nil
+		}
+		return &bundleArchive{Format: format.Name, Decompressor: format.Decompressor()}, nil
+	}
+
+	return nil, nil
+}
+
+// detectArchive detects how to unpack the file at path from its content; it returns nil if
+// the file is not an archive or compressed
+func detectArchive(path string) (*bundleArchive, error) {
+	header, err := readHeader(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return detectHeader(header, func(decompressor archiver.Decompressor) ([]byte, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return decompressedHeader(f, decompressor)
+	})
+}
+
+// peekArchive detects how to unpack the stream r from what it buffers of it, without
+// consuming any of it
+func peekArchive(r *bufio.Reader) (*bundleArchive, error) {
+	peeked, err := r.Peek(archivePeekSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+
+	return detectHeader(peeked, func(decompressor archiver.Decompressor) ([]byte, error) {
+		// The peeked bytes may end mid-stream; whatever they do not decompress to is no tar
+		header, err := decompressedHeader(bytes.NewReader(peeked), decompressor)
+		if err != nil {
+			return nil, nil
+		}
+		return header, nil
+	})
+}
+
+// archiveBaseName strips archive extensions from the name of the archive at path
+func archiveBaseName(path string) string {
+	name := filepath.Base(path)
+	for {
+		stripped := name
+		for _, ext := range archiveExtensions {
+			stripped = strings.TrimSuffix(stripped, ext)
+		}
+		if stripped == name || stripped == "" {
+			return name
+		}
+		name = stripped
+	}
+}
+
+// extractLimits : Caps on what extracting a bundle may write, against archive bombs
+type extractLimits struct {
+	MaxBytes int64
+	MaxFiles int
+}
+
+// RejectedEntry : An archive entry that was not extracted, and why
+type RejectedEntry struct {
+	Archive string
+	Name    string
+	Reason  string
+}
+
+// extractor : Extracts a bundle and the archives nested in it into its dir in a single
+// streaming pass, enforcing limits across all of them
+type extractor struct {
+	// bytes and files are updated atomically by concurrent workers
+	bytes int64
+	files int64
+
+	BundleDir string
+	Limits    extractLimits
+	Rejected  []RejectedEntry
+	Progress  *extractProgress
+
+	// workers holds a slot for every nested archive being extracted concurrently
+	workers chan struct{}
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	err     error
+	// nestedDirs are the dirs nested archives were extracted into, and bundlesDirs the bundles
+	// dirs they were found in, removed once extracted
+	nestedDirs  map[string]bool
+	bundlesDirs map[string]bool
+	// extracted are the files extracted so far, by path, for the bundle's manifest
+	extracted map[string]ManifestFile
+	// links are the symlink entries still to be created
+	links []pendingLink
+}
+
+// pendingLink : A symlink entry, created once everything else is extracted and in place, since
+// what its target resolves to depends on where it ends up
+type pendingLink struct {
+	Archive  string
+	Name     string
+	Path     string
+	Linkname string
+	Progress *archiveProgress
+}
+
+// newExtractor returns an extractor for bundleDir that extracts up to workers nested archives
+// concurrently
+func newExtractor(bundleDir string, limits extractLimits, workers int) *extractor {
+	if workers < 1 {
+		workers = 1
+	}
+	// Symlinks are resolved from the root, so the bundle dir must be absolute
+	if abs, err := filepath.Abs(bundleDir); err == nil {
+		bundleDir = abs
+	}
+	return &extractor{
+		BundleDir:   bundleDir,
+		Limits:      limits,
+		Progress:    newExtractProgress(),
+		workers:     make(chan struct{}, workers-1),
+		nestedDirs:  map[string]bool{},
+		bundlesDirs: map[string]bool{},
+		extracted:   map[string]ManifestFile{},
+	}
+}
+
+// errLimitExceeded reports that a bundle exceeds its extract limits
+type errLimitExceeded struct {
+	limit string
+}
+
+func (e errLimitExceeded) Error() string {
+	return "bundle exceeds " + e.limit + "; it may be an archive bomb"
+}
+
+// limitedWriter fails writes beyond the bytes left within the extractor's limit
+type limitedWriter struct {
+	w        io.Writer
+	e        *extractor
+	progress *archiveProgress
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.e.Limits.MaxBytes > 0 && atomic.AddInt64(&w.e.bytes, int64(len(p))) > w.e.Limits.MaxBytes {
+		return 0, errLimitExceeded{fmt.Sprintf("the max size of %d bytes", w.e.Limits.MaxBytes)}
+	}
+	n, err := w.w.Write(p)
+	w.progress.add(int64(n))
+	return n, err
+}
+
+// withinDir reports whether path is dir or inside it
+func withinDir(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolvePath resolves the symlinks in the absolute path the way the OS does when following it,
+// resolving .. after the symlink before it; the components that do not exist are taken as they
+// are
+func resolvePath(path string) (string, error) {
+	separator := string(filepath.Separator)
+	volume := filepath.VolumeName(path)
+	pending := strings.Split(path[len(volume):], separator)
+	resolved := volume + separator
+	for links := 0; len(pending) > 0; {
+		part := pending[0]
+		pending = pending[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		info, err := os.Lstat(next)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if links++; links > 255 {
+			return "", fmt.Errorf("too many levels of symlinks in %s", path)
+		}
+		linkname, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(linkname) {
+			resolved = volume + separator
+		}
+		pending = append(strings.Split(linkname, separator), pending...)
+	}
+	return resolved, nil
+}
+
+// symlinkBeneath returns the first symlink among the parent dirs of path below the bundle dir,
+// or "" if there is none, so that nothing is ever written through one
+func (e *extractor) symlinkBeneath(path string) (string, error) {
+	rel, err := filepath.Rel(e.BundleDir, filepath.Dir(path))
+	if err != nil || rel == "." {
+		return "", err
+	}
+	dir := e.BundleDir
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return dir, nil
+		}
+	}
+	return "", nil
+}
+
+// archiveEntryName returns the path of an entry within its archive
+func archiveEntryName(file archiver.File) string {
+	switch header := file.Header.(type) {
+	case *tar.Header:
+		return header.Name
+	// archiver reads zip archives with klauspost/compress, not archive/zip
+	case zip.FileHeader:
+		return header.Name
+	case *rardecode.FileHeader:
+		return header.Name
+	}
+	return file.Name()
+}
+
+// reject records an entry that is not extracted
+func (e *extractor) reject(archiveName string, name string, reason string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.Rejected = append(e.Rejected, RejectedEntry{Archive: archiveName, Name: name, Reason: reason})
+}
+
+// fail records the first error of a concurrent worker
+func (e *extractor) fail(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+// failed returns the error a worker failed with, if any
+func (e *extractor) failed() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.err
+}
+
+// countFile counts a file against the max files limit
+func (e *extractor) countFile() error {
+	if files := atomic.AddInt64(&e.files, 1); e.Limits.MaxFiles > 0 && files > int64(e.Limits.MaxFiles) {
+		return errLimitExceeded{fmt.Sprintf("the max of %d files", e.Limits.MaxFiles)}
+	}
+	return nil
+}
+
+// writeFile writes r to path, replacing whatever is there, within the extractor's limits
+func (e *extractor) writeFile(path string, r io.Reader, perm os.FileMode, progress *archiveProgress) error {
+	if err := e.countFile(); err != nil {
+		return err
+	}
+	if link, err := e.symlinkBeneath(path); err != nil || link != "" {
+		if err == nil {
+			err = fmt.Errorf("not writing %s through the symlink %s", path, link)
+		}
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Never write through a link that is already there
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	size, err := io.Copy(&limitedWriter{w: io.MultiWriter(out, h), e: e, progress: progress}, r)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	e.recordFile(path, ManifestFile{Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}, progress)
+	return nil
+}
+
+// recordFile records a file extracted from the archive progress tracks, if any
+func (e *extractor) recordFile(path string, file ManifestFile, progress *archiveProgress) {
+	if progress != nil {
+		file.Archive = progress.Name
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.extracted[path] = file
+}
+
+// moveRecordedFiles updates the paths of the files recorded beneath src, which was moved to dest
+func (e *extractor) moveRecordedFiles(src string, dest string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	moved := map[string]ManifestFile{}
+	for path, file := range e.extracted {
+		if rel, err := filepath.Rel(src, path); err == nil && withinDir(src, path) {
+			delete(e.extracted, path)
+			moved[filepath.Join(dest, rel)] = file
+		}
+	}
+	for path, file := range moved {
+		e.extracted[path] = file
+	}
+	for i, link := range e.links {
+		if rel, err := filepath.Rel(src, link.Path); err == nil && withinDir(src, link.Path) {
+			e.links[i].Path = filepath.Join(dest, rel)
+		}
+	}
+	moveDirs(e.nestedDirs, src, dest)
+	moveDirs(e.bundlesDirs, src, dest)
+}
+
+// moveDirs updates the dirs beneath src in dirs, which was moved to dest
+func moveDirs(dirs map[string]bool, src string, dest string) {
+	moved := map[string]bool{}
+	for dir := range dirs {
+		if rel, err := filepath.Rel(src, dir); err == nil && withinDir(src, dir) {
+			delete(dirs, dir)
+			moved[filepath.Join(dest, rel)] = true
+		}
+	}
+	for dir := range moved {
+		dirs[dir] = true
+	}
+}
+
+// Manifest lists the files of the extracted bundle, from source
+func (e *extractor) Manifest(source string) (*BundleManifest, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return buildBundleManifest(e.BundleDir, source, e.extracted)
+}
+
+// nestedDir returns the dir the archive at path is extracted into: next to it, named after it.
+// Archives in a bundles dir, as Konvoy bundles keep theirs in, are extracted next to that dir.
+func (e *extractor) nestedDir(path string) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	dir := filepath.Dir(path)
+	if filepath.Base(dir) == "bundles" {
+		e.bundlesDirs[dir] = true
+		dir = filepath.Dir(dir)
+	}
+	dest := filepath.Join(dir, archiveBaseName(path))
+	e.nestedDirs[dest] = true
+	return dest
+}
+
+// acquireWorker reserves a worker for a nested archive, if one is free
+func (e *extractor) acquireWorker() bool {
+	select {
+	case e.workers <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// goWorker runs extract on a worker reserved with acquireWorker
+func (e *extractor) goWorker(extract func() error) {
+	e.wg.Add(1)
+	go func() {
+		defer func() {
+			<-e.workers
+			e.wg.Done()
+		}()
+		if err := extract(); err != nil {
+			e.fail(err)
+		}
+	}()
+}
+
+// extract streams content named name: archives are extracted into the dir dest, compressed
+// files are decompressed and looked into in turn, and anything else is written to the file
+// target. progress tracks the archive the content is, once it is known to be one, and parent
+// the archive the content was found in, if any.
+func (e *extractor) extract(r io.Reader, name string, target string, dest string, perm os.FileMode, depth int, progress *archiveProgress, parent *archiveProgress) (err error) {
+	in := bufio.NewReaderSize(r, archivePeekSize)
+	archive, err := peekArchive(in)
+	if err != nil {
+		return err
+	}
+	if archive != nil && depth > maxArchiveDepth {
+		log.Printf("Not unpacking %s: nested more than %d levels deep\n", name, maxArchiveDepth)
+		archive = nil
+	}
+	if archive == nil {
+		return e.writeFile(target, in, perm, progress)
+	}
+
+	if progress == nil {
+		progress = e.Progress.start(name, parent)
+		defer func() { e.Progress.finish(progress, err) }()
+	}
+
+	if archive.Reader == nil {
+		pr, pw := io.Pipe()
+		done := make(chan struct{})
+		go func() {
+			pw.CloseWithError(archive.Decompressor.Decompress(in, pw))
+			close(done)
+		}()
+		err := e.extract(pr, name, filepath.Join(filepath.Dir(target), archiveBaseName(target)), dest, perm, depth+1, progress, parent)
+		// Stop decompressing whatever the content did not need
+		pr.Close()
+		<-done
+		return err
+	}
+
+	return e.extractArchive(archive, in, -1, name, dest, depth, progress)
+}
+
+// extractArchive extracts the entries of the archive r of size bytes, or -1 if unknown, into
+// dest
+func (e *extractor) extractArchive(archive *bundleArchive, r io.Reader, size int64, name string, dest string, depth int, progress *archiveProgress) error {
+	if _, ok := archive.Reader.(*archiver.Zip); ok {
+		if _, ok := r.(io.ReaderAt); !ok || size < 0 {
+			// Zip archives are indexed at their end, so they cannot be read as a stream
+			spooled, err := e.spool(r)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				spooled.Close()
+				os.Remove(spooled.Name())
+			}()
+			info, err := spooled.Stat()
+			if err != nil {
+				return err
+			}
+			r, size = spooled, info.Size()
+		}
+	}
+
+	if err := archive.Reader.Open(r, size); err != nil {
+		return err
+	}
+	defer archive.Reader.Close()
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	for {
+		if err := e.failed(); err != nil {
+			return err
+		}
+		file, err := archive.Reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		progress.addEntry()
+		err = e.extractEntry(name, dest, file, depth, progress)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// spool writes r to a temporary file within the bundle dir, for archives that cannot be
+// streamed
+func (e *extractor) spool(r io.Reader) (*os.File, error) {
+	f, err := ioutil.TempFile(e.BundleDir, ".spool-")
+	if err != nil {
+		return nil, err
+	}
+	var limited io.Reader = r
+	if e.Limits.MaxBytes > 0 {
+		limited = io.LimitReader(r, e.Limits.MaxBytes+1)
+	}
+	n, err := io.Copy(f, limited)
+	if err == nil && e.Limits.MaxBytes > 0 && n > e.Limits.MaxBytes {
+		err = errLimitExceeded{fmt.Sprintf("the max size of %d bytes", e.Limits.MaxBytes)}
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// extractEntry extracts a single archive entry into dest, rejecting entries that would end up
+// outside of it and links that point outside of the bundle
+func (e *extractor) extractEntry(archiveName string, dest string, file archiver.File, depth int, progress *archiveProgress) error {
+	name := archiveEntryName(file)
+	cleaned := filepath.FromSlash(name)
+	if filepath.IsAbs(cleaned) || strings.HasPrefix(name, "/") || filepath.VolumeName(cleaned) != "" {
+		e.reject(archiveName, name, "absolute path")
+		return nil
+	}
+	target := filepath.Join(dest, cleaned)
+	if !withinDir(dest, target) {
+		e.reject(archiveName, name, "path escapes the bundle dir")
+		return nil
+	}
+	link, err := e.symlinkBeneath(target)
+	if err != nil {
+		return err
+	}
+	if link != "" {
+		e.reject(archiveName, name, "path goes through the symlink "+link)
+		return nil
+	}
+
+	header, _ := file.Header.(*tar.Header)
+	mode := file.Mode()
+	switch {
+	case file.IsDir():
+		return os.MkdirAll(target, 0755)
+
+	case header != nil && header.Typeflag == tar.TypeLink:
+		// Hard links are extracted as copies of a file extracted before
+		source := filepath.Join(dest, filepath.FromSlash(header.Linkname))
+		if filepath.IsAbs(filepath.FromSlash(header.Linkname)) || !withinDir(dest, source) {
+			e.reject(archiveName, name, "hard link to "+header.Linkname+" outside the bundle")
+			return nil
+		}
+		if link, err := e.symlinkBeneath(source); err != nil || link != "" {
+			e.reject(archiveName, name, "hard link to "+header.Linkname+" through a symlink")
+			return nil
+		}
+		info, err := os.Lstat(source)
+		if err != nil || !info.Mode().IsRegular() {
+			e.reject(archiveName, name, "hard link to "+header.Linkname+", which is not a file extracted before")
+			return nil
+		}
+		in, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		return e.writeFile(target, in, info.Mode().Perm(), progress)
+
+	case mode&os.ModeSymlink != 0:
+		linkname := ""
+		if header != nil {
+			linkname = header.Linkname
+		} else {
+			// Zip archives store the target of a symlink as its content
+			content, err := ioutil.ReadAll(io.LimitReader(file, 4096))
+			if err != nil {
+				return err
+			}
+			linkname = string(content)
+		}
+		if filepath.IsAbs(filepath.FromSlash(linkname)) || strings.HasPrefix(linkname, "/") {
+			e.reject(archiveName, name, "symlink to "+linkname+" outside the bundle")
+			return nil
+		}
+		if err := e.countFile(); err != nil {
+			return err
+		}
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.links = append(e.links, pendingLink{Archive: archiveName, Name: name, Path: target, Linkname: linkname, Progress: progress})
+		return nil
+
+	case mode.IsRegular():
+		return e.extractFile(file, archiveName+"/"+filepath.ToSlash(cleaned), file.Size(), target, mode.Perm()|0600, depth, progress)
+
+	default:
+		e.reject(archiveName, name, "unsupported file type "+mode.Type().String())
+		return nil
+	}
+}
+
+// extractFile extracts the file r named name, of size bytes, that belongs at target. Nested
+// archives small enough are read into memory and handed to a free worker; the rest is streamed
+// in place.
+func (e *extractor) extractFile(r io.Reader, name string, size int64, target string, perm os.FileMode, depth int, progress *archiveProgress) error {
+	in := bufio.NewReaderSize(r, archivePeekSize)
+	archive, err := peekArchive(in)
+	if err != nil {
+		return err
+	}
+	if archive == nil {
+		return e.writeFile(target, in, perm, progress)
+	}
+
+	dest := e.nestedDir(target)
+	if size >= 0 && size <= maxBufferedArchiveSize && e.acquireWorker() {
+		content, err := ioutil.ReadAll(in)
+		if err != nil {
+			<-e.workers
+			return err
+		}
+		e.goWorker(func() error {
+			return e.extract(bytes.NewReader(content), name, target, dest, perm, depth+1, nil, progress)
+		})
+		return nil
+	}
+	return e.extract(in, name, target, dest, perm, depth+1, nil, progress)
+}
+
+// wait waits for the workers, returning err or else the first error a worker failed with
+func (e *extractor) wait(err error) error {
+	if err != nil {
+		e.fail(err)
+	}
+	e.wg.Wait()
+	return e.failed()
+}
+
+// ExtractArchive extracts the archive file at path into the bundle dir
+func (e *extractor) ExtractArchive(archive *bundleArchive, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = func() (err error) {
+		if archive.Reader == nil {
+			return e.extract(f, filepath.Base(path), filepath.Join(e.BundleDir, archiveBaseName(path)), e.BundleDir, 0644, 0, nil, nil)
+		}
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		progress := e.Progress.start(filepath.Base(path), nil)
+		defer func() { e.Progress.finish(progress, err) }()
+		return e.extractArchive(archive, f, info.Size(), filepath.Base(path), e.BundleDir, 0, progress)
+	}()
+	if err := e.wait(err); err != nil {
+		return err
+	}
+	return e.finish()
+}
+
+// CopyDir copies an already extracted bundle from src into the bundle dir, extracting the
+// archives in it
+func (e *extractor) CopyDir(src string) error {
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := e.failed(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(e.BundleDir, rel)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, 0755)
+		case info.Mode().IsRegular():
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return e.extractFile(f, filepath.ToSlash(rel), info.Size(), target, info.Mode().Perm(), 0, nil)
+		default:
+			log.Printf("Skipping %s: not a regular file\n", path)
+			return nil
+		}
+	})
+	if err := e.wait(err); err != nil {
+		return err
+	}
+	return e.finish()
+}
+
+// finish puts the extracted bundle in its final layout, then creates its symlinks
+func (e *extractor) finish() error {
+	if err := e.collapseNestedDirs(); err != nil {
+		return err
+	}
+	if err := e.unwrapNestedDirs(); err != nil {
+		return err
+	}
+	if err := e.removeBundlesDirs(); err != nil {
+		return err
+	}
+	return e.createLinks()
+}
+
+// removeBundlesDirs removes the bundles dirs whose archives were all extracted next to them;
+// those still holding anything else, such as checksums or archives nested too deep to extract,
+// are kept as they are
+func (e *extractor) removeBundlesDirs() error {
+	for dir := range e.bundlesDirs {
+		entries, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 || e.hasPendingLinks(dir) {
+			continue
+		}
+		if err := os.Remove(dir); err != nil {
+			return err
+		}
+	}
+	e.bundlesDirs = map[string]bool{}
+	return nil
+}
+
+// hasPendingLinks reports whether any symlink still to be created is beneath dir
+func (e *extractor) hasPendingLinks(dir string) bool {
+	for _, link := range e.links {
+		if withinDir(dir, link.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// collapseNestedDirs moves the contents of a dir that is all there is in a nested archive and
+// named like it, as node archives wrap their files in, up into the archive's dir, so that
+// node1.tar.gz ends up in node1 and not node1/node1
+func (e *extractor) collapseNestedDirs() error {
+	dirs := make([]string, 0, len(e.nestedDirs))
+	for dir := range e.nestedDirs {
+		dirs = append(dirs, dir)
+	}
+	// Deepest first, as collapsing a dir moves those beneath it
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) > len(dirs[j]) })
+
+	for _, dir := range dirs {
+		// Compressed files decompress to a file rather than a dir
+		if info, err := os.Lstat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		for {
+			hoisted, err := e.hoistSoleDir(dir, func(path string) bool { return filepath.Base(path) == filepath.Base(dir) })
+			if err != nil {
+				return err
+			}
+			if !hoisted {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// createLinks creates the symlink entries in the order they were extracted, rejecting those
+// beneath another symlink and those that resolve to outside the bundle from where they are
+func (e *extractor) createLinks() error {
+	root, err := resolvePath(e.BundleDir)
+	if err != nil {
+		return err
+	}
+	for _, link := range e.links {
+		linkname := filepath.FromSlash(link.Linkname)
+		if beneath, err := e.symlinkBeneath(link.Path); err != nil || beneath != "" {
+			if err != nil {
+				return err
+			}
+			e.reject(link.Archive, link.Name, "path goes through the symlink "+beneath)
+			continue
+		}
+		// Joined without cleaning, so that .. is resolved after the symlinks before it
+		resolved, err := resolvePath(filepath.Dir(link.Path) + string(filepath.Separator) + linkname)
+		if err != nil {
+			return err
+		}
+		if !withinDir(root, resolved) {
+			e.reject(link.Archive, link.Name, "symlink to "+link.Linkname+" outside the bundle")
+			continue
+		}
+		if info, err := os.Lstat(link.Path); err == nil && info.IsDir() {
+			e.reject(link.Archive, link.Name, "symlink over a dir that was extracted")
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(link.Path), 0755); err != nil {
+			return err
+		}
+		if err := os.Remove(link.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Symlink(linkname, link.Path); err != nil {
+			return err
+		}
+		e.recordFile(link.Path, ManifestFile{Link: link.Linkname}, link.Progress)
+	}
+	e.links = nil
+	return nil
+}
+
+// unwrapNestedDirs moves the contents of a nested archive that is all there is in the bundle
+// dir, a wrapper around the actual bundle, into the bundle dir itself
+func (e *extractor) unwrapNestedDirs() error {
+	for {
+		hoisted, err := e.hoistSoleDir(e.BundleDir, func(path string) bool { return e.nestedDirs[path] })
+		if err != nil || !hoisted {
+			return err
+		}
+	}
+}
+
+// hoistSoleDir moves the contents of the dir that is all there is in dir up into dir, if accept
+// accepts its path, and reports whether it did
+func (e *extractor) hoistSoleDir(dir string, accept func(path string) bool) (bool, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	if len(entries) != 1 || !entries[0].IsDir() || !accept(filepath.Join(dir, entries[0].Name())) {
+		return false, nil
+	}
+
+	// Move it aside first, in case it contains an entry of the same name
+	tmp, err := ioutil.TempDir(dir, ".unwrap-")
+	if err != nil {
+		return false, err
+	}
+	sole := filepath.Join(dir, entries[0].Name())
+	unwrapped := filepath.Join(tmp, entries[0].Name())
+	if err := os.Rename(sole, unwrapped); err != nil {
+		return false, err
+	}
+	if err := moveDirContents(unwrapped, dir); err != nil {
+		return false, err
+	}
+	e.moveRecordedFiles(sole, dir)
+	return true, os.Remove(tmp)
+}
+
+// moveDirContents moves everything in src into dest, then removes src
+func moveDirContents(src string, dest string) error {
+	entries, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.Rename(filepath.Join(src, entry.Name()), filepath.Join(dest, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return os.Remove(src)
+}
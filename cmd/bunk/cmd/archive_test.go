@@ -0,0 +1,471 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// tarEntry : An entry of a tar archive written by writeTestTar
+type tarEntry struct {
+	Name     string
+	Type     byte
+	Linkname string
+	Content  string
+}
+
+func writeTestTar(t *testing.T, path string, entries []tarEntry) {
+	t.Helper()
+	buffer := &bytes.Buffer{}
+	tw := tar.NewWriter(buffer)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.Name, Typeflag: entry.Type, Linkname: entry.Linkname, Mode: 0644, Size: int64(len(entry.Content))}
+		if entry.Type == 0 {
+			header.Typeflag = tar.TypeReg
+		} else {
+			header.Size = 0
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.Content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buffer.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tarBytes(t *testing.T, entries []tarEntry) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "bunk-tar-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestTar(t, filepath.Join(dir, "a.tar"), entries)
+	content, err := ioutil.ReadFile(filepath.Join(dir, "a.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+// extractTestTar extracts a tar of entries into the dir bundle of a new temp dir, returning the
+// temp dir and the names of the rejected entries
+func extractTestTar(t *testing.T, entries []tarEntry, setup func(bundleDir string)) (string, []string) {
+	t.Helper()
+	tmp, err := ioutil.TempDir("", "bunk-extract-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	archivePath := filepath.Join(tmp, "evil.tar")
+	writeTestTar(t, archivePath, entries)
+	bundleDir := filepath.Join(tmp, "out", "bundle")
+	if err := os.MkdirAll(bundleDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if setup != nil {
+		setup(bundleDir)
+	}
+
+	archive, err := detectArchive(archivePath)
+	if err != nil || archive == nil {
+		t.Fatalf("detectArchive: %v, %v", archive, err)
+	}
+	e := newExtractor(bundleDir, extractLimits{}, 2)
+	if err := e.ExtractArchive(archive, archivePath); err != nil {
+		t.Fatalf("ExtractArchive: %v", err)
+	}
+
+	var rejected []string
+	for _, entry := range e.Rejected {
+		rejected = append(rejected, entry.Name)
+	}
+	sort.Strings(rejected)
+	return tmp, rejected
+}
+
+// assertOnlyBundle fails unless the dir holding the bundle dir holds nothing else
+func assertOnlyBundle(t *testing.T, tmp string) {
+	t.Helper()
+	entries, err := ioutil.ReadDir(filepath.Join(tmp, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "bundle" {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Errorf("extracted outside the bundle dir: %v", names)
+	}
+}
+
+func TestExtractRejectsSymlinkEscapes(t *testing.T) {
+	tmp, rejected := extractTestTar(t, []tarEntry{
+		// a/.. looks like the bundle dir, but the OS resolves it after a -> ., to its parent
+		{Name: "a", Type: tar.TypeSymlink, Linkname: "."},
+		{Name: "a/g", Type: tar.TypeSymlink, Linkname: ".."},
+		{Name: "g/outside.txt", Content: "outside"},
+		{Name: "b", Type: tar.TypeSymlink, Linkname: "a/.."},
+		{Name: "c", Type: tar.TypeSymlink, Linkname: "/etc/passwd"},
+		{Name: "h", Type: tar.TypeLink, Linkname: "a/../../secret"},
+		{Name: "ok", Type: tar.TypeSymlink, Linkname: "g/outside.txt"},
+	}, nil)
+	defer os.RemoveAll(tmp)
+
+	assertOnlyBundle(t, tmp)
+	if got, want := strings.Join(rejected, " "), "a/g b c h"; got != want {
+		t.Errorf("rejected %q, want %q", got, want)
+	}
+	if linkname, err := os.Readlink(filepath.Join(tmp, "out", "bundle", "ok")); err != nil || linkname != "g/outside.txt" {
+		t.Errorf("symlink within the bundle: %q, %v", linkname, err)
+	}
+}
+
+func TestExtractChecksSymlinksWhereTheyEndUp(t *testing.T) {
+	// The bundle is unwrapped from inner.tar, moving x -> .. up to the bundle dir
+	tmp, rejected := extractTestTar(t, []tarEntry{
+		{Name: "inner.tar", Content: tarBytes(t, []tarEntry{
+			{Name: "file", Content: "inside"},
+			{Name: "x", Type: tar.TypeSymlink, Linkname: ".."},
+			{Name: "y", Type: tar.TypeSymlink, Linkname: "file"},
+		})},
+	}, nil)
+	defer os.RemoveAll(tmp)
+
+	assertOnlyBundle(t, tmp)
+	if got, want := strings.Join(rejected, " "), "x"; got != want {
+		t.Errorf("rejected %q, want %q", got, want)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(tmp, "out", "bundle", "y")); err != nil || string(content) != "inside" {
+		t.Errorf("symlink within the bundle: %q, %v", content, err)
+	}
+}
+
+func TestExtractNeverWritesBeneathSymlinks(t *testing.T) {
+	var outside string
+	tmp, rejected := extractTestTar(t, []tarEntry{
+		{Name: "a/f", Content: "written through a symlink"},
+		{Name: "h", Type: tar.TypeLink, Linkname: "a/secret"},
+	}, func(bundleDir string) {
+		outside = filepath.Join(filepath.Dir(bundleDir), "outside")
+		if err := os.MkdirAll(outside, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(outside, filepath.Join(bundleDir, "a")); err != nil {
+			t.Fatal(err)
+		}
+	})
+	defer os.RemoveAll(tmp)
+
+	if got, want := strings.Join(rejected, " "), "a/f h"; got != want {
+		t.Errorf("rejected %q, want %q", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "f")); !os.IsNotExist(err) {
+		t.Errorf("wrote a/f through the symlink a: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(tmp, "out", "bundle", "h")); !os.IsNotExist(err) {
+		t.Errorf("copied the secret through the symlink a: %v", err)
+	}
+}
+
+func TestExtractNormalizesNestedBundles(t *testing.T) {
+	tmp, rejected := extractTestTar(t, []tarEntry{
+		{Name: "mybundle/kube/api-resources/pods.yaml", Content: "items: []\n"},
+		{Name: "mybundle/bundles/node1.tar", Content: tarBytes(t, []tarEntry{
+			{Name: "node1/logs/kubelet.log", Content: "started\n"},
+		})},
+	}, nil)
+	defer os.RemoveAll(tmp)
+
+	if len(rejected) != 0 {
+		t.Errorf("rejected %v", rejected)
+	}
+	bundleDir := filepath.Join(tmp, "out", "bundle")
+	if content, err := ioutil.ReadFile(filepath.Join(bundleDir, "mybundle", "node1", "logs", "kubelet.log")); err != nil || string(content) != "started\n" {
+		t.Errorf("node archive not extracted into mybundle/node1: %q, %v", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(bundleDir, "mybundle", "bundles")); !os.IsNotExist(err) {
+		t.Errorf("mybundle/bundles was not removed: %v", err)
+	}
+}
+
+func TestExtractKeepsBundlesDirsWithOtherFiles(t *testing.T) {
+	tmp, _ := extractTestTar(t, []tarEntry{
+		{Name: "bundles/node1.tar", Content: tarBytes(t, []tarEntry{
+			{Name: "node1/logs/kubelet.log", Content: "started\n"},
+		})},
+		{Name: "bundles/SHA256SUMS", Content: "0123  node1.tar\n"},
+	}, nil)
+	defer os.RemoveAll(tmp)
+
+	bundleDir := filepath.Join(tmp, "out", "bundle")
+	if _, err := os.Stat(filepath.Join(bundleDir, "node1", "logs", "kubelet.log")); err != nil {
+		t.Errorf("node archive not extracted into node1: %v", err)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(bundleDir, "bundles", "SHA256SUMS")); err != nil || string(content) != "0123  node1.tar\n" {
+		t.Errorf("bundles/SHA256SUMS was not kept: %q, %v", content, err)
+	}
+}
+
+func TestExtractMaxSize(t *testing.T) {
+	defer viper.Set("extract.maxSize", viper.GetString("extract.maxSize"))
+	viper.Set("extract.maxSize", "1Ki")
+	limits, err := extractLimitsFromConfig()
+	if err != nil || limits.MaxBytes != 1024 {
+		t.Fatalf("--max-size 1Ki gives limits %+v, %v; want 1024 bytes", limits, err)
+	}
+
+	for _, test := range []struct {
+		size     int
+		exceeded bool
+	}{
+		{size: 1000},
+		{size: 1024},
+		{size: 1025, exceeded: true},
+	} {
+		tmp, err := ioutil.TempDir("", "bunk-extract-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tmp)
+		archivePath := filepath.Join(tmp, "bundle.tar")
+		writeTestTar(t, archivePath, []tarEntry{{Name: "pods.yaml", Content: strings.Repeat("x", test.size)}})
+		archive, err := detectArchive(archivePath)
+		if err != nil || archive == nil {
+			t.Fatalf("detectArchive: %v, %v", archive, err)
+		}
+
+		err = newExtractor(filepath.Join(tmp, "bundle"), limits, 1).ExtractArchive(archive, archivePath)
+		if _, exceeded := err.(errLimitExceeded); exceeded != test.exceeded {
+			t.Errorf("extracting %d bytes with --max-size 1Ki: %v", test.size, err)
+		}
+	}
+
+	viper.Set("extract.maxSize", "1KiB")
+	if _, err := extractLimitsFromConfig(); err == nil {
+		t.Errorf("--max-size 1KiB was accepted")
+	}
+}
+
+func gzipBytes(t *testing.T, content string) string {
+	t.Helper()
+	buffer := &bytes.Buffer{}
+	w := gzip.NewWriter(buffer)
+	if _, err := w.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.String()
+}
+
+func zipBytes(t *testing.T, entries []tarEntry) string {
+	t.Helper()
+	buffer := &bytes.Buffer{}
+	w := zip.NewWriter(buffer)
+	for _, entry := range entries {
+		f, err := w.Create(entry.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(entry.Content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.String()
+}
+
+func TestExtractKeepsZipEntryDirs(t *testing.T) {
+	tmp, rejected := extractTestTar(t, []tarEntry{
+		{Name: "api-resources/pods.yaml", Content: "items: []\n"},
+		{Name: "bundles/node1.zip", Content: zipBytes(t, []tarEntry{
+			{Name: "node1/logs/kubelet.log", Content: "started\n"},
+			{Name: "../escaped.log", Content: "escaped\n"},
+		})},
+	}, nil)
+	defer os.RemoveAll(tmp)
+
+	bundleDir := filepath.Join(tmp, "out", "bundle")
+	if content, err := ioutil.ReadFile(filepath.Join(bundleDir, "node1", "logs", "kubelet.log")); err != nil || string(content) != "started\n" {
+		t.Errorf("node1/logs/kubelet.log not extracted from the zip archive: %q, %v", content, err)
+	}
+	// Zip entries are checked by their full path, not their base name
+	if fmt.Sprint(rejected) != "[../escaped.log]" {
+		t.Errorf("rejected %v; want ../escaped.log", rejected)
+	}
+	assertOnlyBundle(t, tmp)
+}
+
+// readTestTree returns each file beneath dir as <slash separated path>: <content>, sorted
+func readTestTree(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel)+": "+string(content))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestExtractStreamsNestedArchives(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "bunk-extract-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// A compressed bundle with node archives in each format, one nesting another, and a
+	// compressed log that is no archive
+	archivePath := filepath.Join(tmp, "bundle.tar.gz")
+	node1 := tarBytes(t, []tarEntry{
+		{Name: "node1/logs/kubelet.log", Content: "node1 started\n"},
+		{Name: "node1/containers.tar.gz", Content: gzipBytes(t, tarBytes(t, []tarEntry{
+			{Name: "containers/app.log", Content: "app started\n"},
+		}))},
+	})
+	bundle := tarBytes(t, []tarEntry{
+		{Name: "mybundle/kube/api-resources/pods.yaml", Content: "items: []\n"},
+		{Name: "mybundle/bundles/node1.tar.gz", Content: gzipBytes(t, node1)},
+		{Name: "mybundle/bundles/node2.zip", Content: zipBytes(t, []tarEntry{
+			{Name: "node2/logs/kubelet.log", Content: "node2 started\n"},
+		})},
+		{Name: "mybundle/logs/audit.log.gz", Content: gzipBytes(t, "audit\n")},
+	})
+	if err := ioutil.WriteFile(archivePath, []byte(gzipBytes(t, bundle)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Node archives are extracted next to the bundles dir, without the dir they wrap their files in
+	want := []string{
+		"mybundle/kube/api-resources/pods.yaml: items: []\n",
+		"mybundle/logs/audit.log: audit\n",
+		"mybundle/node1/containers/app.log: app started\n",
+		"mybundle/node1/logs/kubelet.log: node1 started\n",
+		"mybundle/node2/logs/kubelet.log: node2 started\n",
+	}
+
+	// Extracting nested archives concurrently gives the same bundle as one at a time
+	for _, workers := range []int{1, 4} {
+		bundleDir := filepath.Join(tmp, fmt.Sprintf("bundle-%d", workers))
+		archive, err := detectArchive(archivePath)
+		if err != nil || archive == nil {
+			t.Fatalf("detectArchive: %v, %v", archive, err)
+		}
+		e := newExtractor(bundleDir, extractLimits{}, workers)
+		if err := e.ExtractArchive(archive, archivePath); err != nil {
+			t.Fatalf("ExtractArchive with %d workers: %v", workers, err)
+		}
+		if got := readTestTree(t, bundleDir); fmt.Sprintf("%q", got) != fmt.Sprintf("%q", want) {
+			t.Errorf("bundle extracted with %d workers = %q; want %q", workers, got, want)
+		}
+
+		// Each file is recorded with the innermost archive it came from, by its path through the outer ones
+		manifest, err := e.Manifest(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		archives := map[string]string{}
+		for _, file := range manifest.Files {
+			archives[file.Path] = file.Archive
+		}
+		for path, archive := range map[string]string{
+			"mybundle/kube/api-resources/pods.yaml": "bundle.tar.gz",
+			"mybundle/logs/audit.log":               "bundle.tar.gz/mybundle/logs/audit.log.gz",
+			"mybundle/node1/logs/kubelet.log":       "bundle.tar.gz/mybundle/bundles/node1.tar.gz",
+			"mybundle/node1/containers/app.log":     "bundle.tar.gz/mybundle/bundles/node1.tar.gz/node1/containers.tar.gz",
+			"mybundle/node2/logs/kubelet.log":       "bundle.tar.gz/mybundle/bundles/node2.zip",
+		} {
+			if archives[path] != archive {
+				t.Errorf("%s was recorded from %q with %d workers; want %q", path, archives[path], workers, archive)
+			}
+		}
+	}
+}
+
+func TestExtractMaxFilesAcrossNestedArchives(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "bunk-extract-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	archivePath := filepath.Join(tmp, "bundle.tar")
+	writeTestTar(t, archivePath, []tarEntry{
+		{Name: "pods.yaml", Content: "items: []\n"},
+		{Name: "bundles/node1.tar", Content: tarBytes(t, []tarEntry{
+			{Name: "node1/a.log", Content: "a\n"},
+			{Name: "node1/b.log", Content: "b\n"},
+		})},
+	})
+
+	for _, test := range []struct {
+		maxFiles int
+		exceeded bool
+	}{
+		{maxFiles: 3},
+		{maxFiles: 2, exceeded: true},
+	} {
+		archive, err := detectArchive(archivePath)
+		if err != nil || archive == nil {
+			t.Fatalf("detectArchive: %v, %v", archive, err)
+		}
+		bundleDir := filepath.Join(tmp, fmt.Sprintf("bundle-%d", test.maxFiles))
+		err = newExtractor(bundleDir, extractLimits{MaxFiles: test.maxFiles}, 2).ExtractArchive(archive, archivePath)
+		if _, exceeded := err.(errLimitExceeded); exceeded != test.exceeded {
+			t.Errorf("extracting 3 files with --max-files %d: %v", test.maxFiles, err)
+		}
+	}
+}
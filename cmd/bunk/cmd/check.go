@@ -17,35 +17,323 @@ package cmd
 
 import (
 	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
+	"github.com/fatih/color"
+	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 )
 
+// Severity : How serious a check finding is
+type Severity int
+
+// Severity levels, ordered from least to most serious
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+// parseSeverity converts a severity name into a Severity
+func parseSeverity(name string) (Severity, error) {
+	switch strings.ToLower(name) {
+	case "info":
+		return SeverityInfo, nil
+	case "warning", "warn":
+		return SeverityWarning, nil
+	case "error":
+		return SeverityError, nil
+	}
+	return SeverityInfo, fmt.Errorf("unknown severity %q", name)
+}
+
+// MarshalText encodes a severity as its name
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a severity from its name
+func (s *Severity) UnmarshalText(text []byte) error {
+	severity, err := parseSeverity(string(text))
+	if err != nil {
+		return err
+	}
+	*s = severity
+	return nil
+}
+
+// exitCode returns the process exit code for the most severe of findings:
+// 0 for none or info, 2 for warnings and 3 for errors. 1 is left for bunk's own failures.
+func exitCode(findings []Finding) int {
+	highest := SeverityInfo
+	for _, finding := range findings {
+		if finding.Severity > highest {
+			highest = finding.Severity
+		}
+	}
+	switch highest {
+	case SeverityWarning:
+		return 2
+	case SeverityError:
+		return 3
+	}
+	return 0
+}
+
+// Finding : A single problem reported by a check
+type Finding struct {
+	Check       string   `json:"check"`
+	Severity    Severity `json:"severity"`
+	Resource    string   `json:"resource,omitempty"`
+	File        string   `json:"file,omitempty"`
+	LogFile     string   `json:"logFile,omitempty"`
+	Message     string   `json:"message"`
+	Remediation string   `json:"remediation,omitempty"`
+}
+
+// Check : A named diagnostic rule run against the resources of a bundle
+type Check struct {
+	Name        string
+	Description string
+	Run         func(resources *BundleResources) []Finding
+}
+
+// checks is the registry of every known check, in registration order
+var checks []Check
+
+// registerCheck adds a check to the registry; names must be unique
+func registerCheck(check Check) {
+	for _, c := range checks {
+		if c.Name == check.Name {
+			log.Fatalf("Check %q is already registered\n", check.Name)
+		}
+	}
+	checks = append(checks, check)
+}
+
 // checkCmd represents the check command
 var checkCmd = &cobra.Command{
 	Use:   "check",
 	Short: "Run checks against a bundle to find errors, misconfigurations, etc.",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
+	Long: `Load every object from the bundle's api-resources files and run a set of
+diagnostic checks against them. Each finding is reported with its severity, the
+resource it concerns and a hint on how to remediate it.
+
+Use --list to see the available checks and --check to run only some of them.
+Additional rules can be written in yaml and loaded with --rules or from the
+checks.paths list in the bunk config file, for example:
+
+  rules:
+  - name: latest-image-tag
+    resource: pods
+    field: .spec.containers[*].image
+    predicate: matches
+    value: ':latest$'
+    severity: warning
+    message: '{{.Namespace}}/{{.Name}} runs {{.Value}}'
+    remediation: Pin images to a specific tag
+
+Predicates are exists, notExists, equals, notEquals, contains, matches,
+notMatches, greaterThan and lessThan.
+Use --output to print the findings as json, junit, sarif or markdown instead of text.
 
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+The exit code reflects the most severe finding: 0 for none or info, 2 for
+warnings and 3 for errors.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("check called")
+		rulePaths, _ := cmd.Flags().GetStringSlice("rules")
+		if err := registerRuleFiles(rulePaths); err != nil {
+			log.Fatalf("Failed to load check rules: %v\n", err)
+		}
+
+		list, _ := cmd.Flags().GetBool("list")
+		if list {
+			listChecks()
+			return
+		}
+
+		names, _ := cmd.Flags().GetStringSlice("check")
+		selected, err := selectChecks(names)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		output, _ := cmd.Flags().GetString("output")
+		writeReport, ok := reportWriters[output]
+		if !ok && output != "text" {
+			log.Fatalf("Unknown output format %q; expected one of text, json, junit, sarif, markdown\n", output)
+		}
+
+		bundleRootDir := getBundleRootDir()
+		apiResourcesDir := getAPIResourcesDir(bundleRootDir)
+		resources := loadBundleResources(bundleRootDir, apiResourcesDir)
+
+		findings := runChecks(selected, resources)
+		if writeReport == nil {
+			printFindings(findings)
+		} else {
+			report := checkReport{
+				BundleRootDir: bundleRootDir,
+				Checks:        selected,
+				Findings:      findings,
+			}
+			if err := writeReport(os.Stdout, report); err != nil {
+				log.Fatalf("Failed to write %s report: %v\n", output, err)
+			}
+		}
+		os.Exit(exitCode(findings))
 	},
 }
 
+// selectChecks returns the registered checks matching names, or all checks if names is empty
+func selectChecks(names []string) ([]Check, error) {
+	if len(names) == 0 {
+		return checks, nil
+	}
+
+	var selected []Check
+	for _, name := range names {
+		found := false
+		for _, c := range checks {
+			if c.Name == name {
+				selected = append(selected, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown check %q; run `bunk check --list` to see available checks", name)
+		}
+	}
+	return selected, nil
+}
+
+// runChecks runs each check and returns their findings, most severe first
+func runChecks(selected []Check, resources *BundleResources) []Finding {
+	var findings []Finding
+	for _, c := range selected {
+		for _, finding := range c.Run(resources) {
+			finding.Check = c.Name
+			findings = append(findings, finding)
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Severity != findings[j].Severity {
+			return findings[i].Severity > findings[j].Severity
+		}
+		if findings[i].Check != findings[j].Check {
+			return findings[i].Check < findings[j].Check
+		}
+		if findings[i].Resource != findings[j].Resource {
+			return findings[i].Resource < findings[j].Resource
+		}
+		return findings[i].File < findings[j].File
+	})
+
+	return findings
+}
+
+func listChecks() {
+	rows := [][]string{}
+	for _, c := range checks {
+		rows = append(rows, []string{c.Name, c.Description})
+	}
+	table := newPlainTable([]string{"Name", "Description"})
+	table.AppendBulk(rows)
+	table.Render()
+}
+
+func printFindings(findings []Finding) {
+	if len(findings) == 0 {
+		color.New(color.FgGreen).Println("No problems found")
+		return
+	}
+
+	severityColors := map[Severity]*color.Color{
+		SeverityInfo:    color.New(color.FgCyan),
+		SeverityWarning: color.New(color.FgYellow),
+		SeverityError:   color.New(color.FgRed),
+	}
+
+	for _, finding := range findings {
+		severityColors[finding.Severity].Printf("[%s] ", strings.ToUpper(finding.Severity.String()))
+		fmt.Printf("%s: %s\n", finding.Check, finding.Message)
+		if finding.Resource != "" {
+			fmt.Printf("    resource: %s\n", finding.Resource)
+		}
+		if finding.File != "" {
+			fmt.Printf("    file:     %s\n", finding.File)
+		}
+		if finding.LogFile != "" {
+			fmt.Printf("    log:      %s\n", finding.LogFile)
+		}
+		if finding.Remediation != "" {
+			fmt.Printf("    hint:     %s\n", finding.Remediation)
+		}
+	}
+
+	counts := map[Severity]int{}
+	for _, finding := range findings {
+		counts[finding.Severity]++
+	}
+	fmt.Printf("\n%d error(s), %d warning(s), %d info\n", counts[SeverityError], counts[SeverityWarning], counts[SeverityInfo])
+}
+
+// newPlainTable returns a borderless, tab-padded table matching `bunk log ls`
+func newPlainTable(header []string) *tablewriter.Table {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader(header)
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(true)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetCenterSeparator("")
+	table.SetColumnSeparator("")
+	table.SetRowSeparator("")
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetTablePadding("\t") // pad with tabs
+	table.SetNoWhiteSpace(true)
+	return table
+}
+
 func init() {
 	rootCmd.AddCommand(checkCmd)
 
-	// Here you will define your flags and configuration settings.
-
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// checkCmd.PersistentFlags().String("foo", "", "A help for foo")
+	checkCmd.Flags().Bool("list", false, "List the available checks and exit")
+	checkCmd.Flags().StringSliceP("check", "c", nil, "Only run the named checks (may be repeated)")
+	checkCmd.Flags().StringP("output", "o", "text", "Output format: text, json, junit, sarif or markdown")
 
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// checkCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	registerCheck(Check{
+		Name:        "resource-files",
+		Description: "Reports api-resources files that could not be parsed",
+		Run: func(resources *BundleResources) []Finding {
+			var findings []Finding
+			for file, err := range resources.Invalid {
+				findings = append(findings, Finding{
+					Severity:    SeverityWarning,
+					File:        file,
+					Message:     fmt.Sprintf("Could not parse %s: %v", filepath.Base(file), err),
+					Remediation: "Objects in this file were not loaded; inspect it by hand or re-collect the bundle",
+				})
+			}
+			return findings
+		},
+	})
 }
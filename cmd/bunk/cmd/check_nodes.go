@@ -0,0 +1,294 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Node : Subset of a Kubernetes node used by checks
+type Node struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		Unschedulable bool `json:"unschedulable"`
+		Taints        []struct {
+			Key    string `json:"key"`
+			Value  string `json:"value"`
+			Effect string `json:"effect"`
+		} `json:"taints"`
+	} `json:"spec"`
+	Status struct {
+		Allocatable map[string]string `json:"allocatable"`
+		Conditions  []struct {
+			Type    string `json:"type"`
+			Status  string `json:"status"`
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+// quantitySuffixes lists Kubernetes quantity suffixes with their multipliers, binary suffixes
+// first so that e.g. Ki is not read as k followed by i
+var quantitySuffixes = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"Ki", 1 << 10},
+	{"Mi", 1 << 20},
+	{"Gi", 1 << 30},
+	{"Ti", 1 << 40},
+	{"Pi", 1 << 50},
+	{"Ei", 1 << 60},
+	{"n", 1e-9},
+	{"u", 1e-6},
+	{"m", 1e-3},
+	{"k", 1e3},
+	{"M", 1e6},
+	{"G", 1e9},
+	{"T", 1e12},
+	{"P", 1e15},
+	{"E", 1e18},
+}
+
+// parseQuantity converts a Kubernetes resource quantity such as 500m or 2Gi to a float
+func parseQuantity(quantity string) (float64, error) {
+	quantity = strings.TrimSpace(quantity)
+	number := quantity
+	multiplier := 1.0
+	for _, s := range quantitySuffixes {
+		if strings.HasSuffix(quantity, s.suffix) {
+			number = strings.TrimSuffix(quantity, s.suffix)
+			multiplier = s.multiplier
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsInf(value, 0) || math.IsNaN(value) {
+		return 0, fmt.Errorf("invalid quantity %q", quantity)
+	}
+	return value * multiplier, nil
+}
+
+// podRequests returns the effective resource requests of a pod: the larger of
+// the sum of its containers and the largest single init container
+func podRequests(pod Pod) map[string]float64 {
+	requests := map[string]float64{}
+	for _, c := range pod.Spec.Containers {
+		for name, quantity := range c.Resources.Requests {
+			if value, err := parseQuantity(quantity); err == nil {
+				requests[name] += value
+			}
+		}
+	}
+	for _, c := range pod.Spec.InitContainers {
+		for name, quantity := range c.Resources.Requests {
+			if value, err := parseQuantity(quantity); err == nil && value > requests[name] {
+				requests[name] = value
+			}
+		}
+	}
+	requests["pods"] = 1
+	return requests
+}
+
+// checkNodes runs rule against every node in the bundle, filling in the resource and file of each finding
+func checkNodes(resources *BundleResources, rule func(node Node) []Finding) []Finding {
+	var findings []Finding
+	for _, object := range resources.Objects("nodes", "") {
+		var node Node
+		if err := object.Decode(&node); err != nil {
+			continue
+		}
+		for _, finding := range rule(node) {
+			finding.Resource = object.Ref()
+			finding.File = object.File
+			findings = append(findings, finding)
+		}
+	}
+	return findings
+}
+
+func init() {
+	checkCmd.Flags().Float64("allocation-threshold", 90, "Report nodes whose allocatable resources are more than this percent requested")
+	viper.BindPFlag("checks.allocationThreshold", checkCmd.Flags().Lookup("allocation-threshold"))
+
+	registerCheck(Check{
+		Name:        "node-not-ready",
+		Description: "Reports nodes whose Ready condition is not True",
+		Run: func(resources *BundleResources) []Finding {
+			return checkNodes(resources, func(node Node) []Finding {
+				for _, condition := range node.Status.Conditions {
+					if condition.Type == "Ready" && condition.Status != "True" {
+						return []Finding{{
+							Severity:    SeverityError,
+							Message:     strings.TrimSpace(fmt.Sprintf("Node %s is NotReady (%s): %s %s", node.Metadata.Name, condition.Status, condition.Reason, condition.Message)),
+							Remediation: "Check the kubelet and container runtime logs in the node's bundle data",
+						}}
+					}
+				}
+				return nil
+			})
+		},
+	})
+
+	registerCheck(Check{
+		Name:        "node-pressure",
+		Description: "Reports nodes under memory, disk or PID pressure",
+		Run: func(resources *BundleResources) []Finding {
+			return checkNodes(resources, func(node Node) []Finding {
+				var findings []Finding
+				for _, condition := range node.Status.Conditions {
+					switch condition.Type {
+					case "MemoryPressure", "DiskPressure", "PIDPressure":
+					default:
+						continue
+					}
+					if condition.Status != "True" {
+						continue
+					}
+					findings = append(findings, Finding{
+						Severity:    SeverityWarning,
+						Message:     strings.TrimSpace(fmt.Sprintf("Node %s has %s: %s %s", node.Metadata.Name, condition.Type, condition.Reason, condition.Message)),
+						Remediation: "Pods may be evicted or refused; free up or add capacity on the node",
+					})
+				}
+				return findings
+			})
+		},
+	})
+
+	registerCheck(Check{
+		Name:        "node-cordoned",
+		Description: "Reports nodes marked unschedulable",
+		Run: func(resources *BundleResources) []Finding {
+			return checkNodes(resources, func(node Node) []Finding {
+				if !node.Spec.Unschedulable {
+					return nil
+				}
+				return []Finding{{
+					Severity:    SeverityWarning,
+					Message:     fmt.Sprintf("Node %s is cordoned", node.Metadata.Name),
+					Remediation: "Uncordon the node with `kubectl uncordon` once maintenance is complete",
+				}}
+			})
+		},
+	})
+
+	registerCheck(Check{
+		Name:        "node-taints",
+		Description: "Reports taints that block scheduling on worker nodes",
+		Run: func(resources *BundleResources) []Finding {
+			return checkNodes(resources, func(node Node) []Finding {
+				var findings []Finding
+				for _, taint := range node.Spec.Taints {
+					if taint.Effect != "NoSchedule" && taint.Effect != "NoExecute" {
+						continue
+					}
+					switch taint.Key {
+					// Expected taints, or taints already reported by other checks
+					case "node-role.kubernetes.io/master",
+						"node-role.kubernetes.io/control-plane",
+						"node.kubernetes.io/unschedulable":
+						continue
+					}
+					findings = append(findings, Finding{
+						Severity:    SeverityWarning,
+						Message:     fmt.Sprintf("Node %s has taint %s=%s:%s", node.Metadata.Name, taint.Key, taint.Value, taint.Effect),
+						Remediation: "Pods without a matching toleration cannot be scheduled on this node",
+					})
+				}
+				return findings
+			})
+		},
+	})
+
+	registerCheck(Check{
+		Name:        "node-allocation",
+		Description: "Reports nodes whose allocatable cpu, memory or pods are nearly exhausted by pod requests",
+		Run: func(resources *BundleResources) []Finding {
+			threshold := viper.GetFloat64("checks.allocationThreshold")
+
+			requested := map[string]map[string]float64{}
+			for _, object := range resources.Objects("pods", "") {
+				var pod Pod
+				if err := object.Decode(&pod); err != nil {
+					continue
+				}
+				if pod.Spec.NodeName == "" || pod.Status.Phase == "Succeeded" || pod.Status.Phase == "Failed" {
+					continue
+				}
+				if requested[pod.Spec.NodeName] == nil {
+					requested[pod.Spec.NodeName] = map[string]float64{}
+				}
+				for name, value := range podRequests(pod) {
+					requested[pod.Spec.NodeName][name] += value
+				}
+			}
+
+			return checkNodes(resources, func(node Node) []Finding {
+				var names []string
+				for name := range node.Status.Allocatable {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+
+				var findings []Finding
+				for _, name := range names {
+					if name != "cpu" && name != "memory" && name != "pods" {
+						continue
+					}
+					allocatable, err := parseQuantity(node.Status.Allocatable[name])
+					if err != nil {
+						findings = append(findings, Finding{
+							Severity:    SeverityWarning,
+							Message:     fmt.Sprintf("Node %s has allocatable %s %q that bunk cannot parse: %v", node.Metadata.Name, name, node.Status.Allocatable[name], err),
+							Remediation: "Its allocation was not checked; compare the node's allocatable resources with its pods' requests by hand",
+						})
+						continue
+					}
+					if allocatable == 0 {
+						continue
+					}
+					percent := requested[node.Metadata.Name][name] / allocatable * 100
+					if percent < threshold {
+						continue
+					}
+
+					severity := SeverityWarning
+					if percent > 100 {
+						severity = SeverityError
+					}
+					findings = append(findings, Finding{
+						Severity:    severity,
+						Message:     fmt.Sprintf("Node %s has %.0f%% of allocatable %s requested by pods", node.Metadata.Name, percent, name),
+						Remediation: "New pods may fail to schedule; add capacity or reduce pod requests",
+					})
+				}
+				return findings
+			})
+		},
+	})
+}
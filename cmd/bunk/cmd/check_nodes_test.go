@@ -0,0 +1,220 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseQuantity(t *testing.T) {
+	tests := []struct {
+		quantity string
+		want     float64
+		err      bool
+	}{
+		{quantity: "16246588Ki", want: 16246588 * 1024},
+		{quantity: "512Mi", want: 512 << 20},
+		{quantity: "2Gi", want: 2 << 30},
+		{quantity: "1.5Gi", want: 1.5 * (1 << 30)},
+		{quantity: "1Ei", want: 1 << 60},
+		{quantity: "500m", want: 0.5},
+		{quantity: "100k", want: 100000},
+		{quantity: "3M", want: 3e6},
+		{quantity: "250u", want: 250e-6},
+		{quantity: "110", want: 110},
+		{quantity: " 4 ", want: 4},
+		{quantity: "0.25", want: 0.25},
+		{quantity: "1e3", want: 1000},
+		{quantity: "", err: true},
+		{quantity: "Ki", err: true},
+		{quantity: "lots", err: true},
+		{quantity: "5Qi", err: true},
+		{quantity: "5KiB", err: true},
+		{quantity: "Inf", err: true},
+	}
+
+	for _, test := range tests {
+		got, err := parseQuantity(test.quantity)
+		if test.err {
+			if err == nil {
+				t.Errorf("parseQuantity(%q) = %v; want an error", test.quantity, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("parseQuantity(%q) = %v, %v; want %v", test.quantity, got, err, test.want)
+		}
+	}
+}
+
+// testNodes are a NotReady node under memory pressure, a cordoned and tainted node, and a node
+// reporting its memory in Ki like kubelets do
+const testNodes = `items:
+- kind: Node
+  metadata:
+    name: node-1
+  status:
+    conditions:
+    - type: Ready
+      status: "False"
+      reason: KubeletNotReady
+      message: PLEG is not healthy
+    - type: MemoryPressure
+      status: "True"
+      reason: KubeletHasInsufficientMemory
+    - type: DiskPressure
+      status: "False"
+- kind: Node
+  metadata:
+    name: node-2
+  spec:
+    unschedulable: true
+    taints:
+    - key: node.kubernetes.io/unschedulable
+      effect: NoSchedule
+    - key: dedicated
+      value: gpu
+      effect: NoSchedule
+    - key: node-role.kubernetes.io/master
+      effect: NoSchedule
+    - key: spot
+      effect: PreferNoSchedule
+  status:
+    conditions:
+    - type: Ready
+      status: "True"
+- kind: Node
+  metadata:
+    name: node-3
+  status:
+    allocatable:
+      cpu: "4"
+      memory: 16246588Ki
+      pods: "110"
+      ephemeral-storage: 1Ti
+    conditions:
+    - type: Ready
+      status: "True"
+`
+
+// testNodePods over-request the memory of node-3 with requests in Ki and Gi; the completed pod
+// no longer counts
+const testNodePods = `items:
+- kind: Pod
+  metadata:
+    name: db
+    namespace: default
+  spec:
+    nodeName: node-3
+    containers:
+    - name: db
+      resources:
+        requests:
+          cpu: 500m
+          memory: 10485760Ki
+  status:
+    phase: Running
+- kind: Pod
+  metadata:
+    name: cache
+    namespace: default
+  spec:
+    nodeName: node-3
+    initContainers:
+    - name: warmup
+      resources:
+        requests:
+          memory: 1Gi
+    containers:
+    - name: cache
+      resources:
+        requests:
+          cpu: "1"
+          memory: 6Gi
+  status:
+    phase: Running
+- kind: Pod
+  metadata:
+    name: job
+    namespace: default
+  spec:
+    nodeName: node-3
+    containers:
+    - name: job
+      resources:
+        requests:
+          memory: 8Gi
+  status:
+    phase: Succeeded
+`
+
+func TestNodeChecks(t *testing.T) {
+	tests := []struct {
+		check string
+		want  []string
+	}{
+		{
+			check: "node-not-ready",
+			want:  []string{"error node/node-1: Node node-1 is NotReady (False): KubeletNotReady PLEG is not healthy"},
+		},
+		{
+			check: "node-pressure",
+			want:  []string{"warning node/node-1: Node node-1 has MemoryPressure: KubeletHasInsufficientMemory"},
+		},
+		{
+			check: "node-cordoned",
+			want:  []string{"warning node/node-2: Node node-2 is cordoned"},
+		},
+		{
+			check: "node-taints",
+			want:  []string{"warning node/node-2: Node node-2 has taint dedicated=gpu:NoSchedule"},
+		},
+		{
+			// 16Gi of 16246588Ki is 103%
+			check: "node-allocation",
+			want:  []string{"error node/node-3: Node node-3 has 103% of allocatable memory requested by pods"},
+		},
+	}
+
+	for _, test := range tests {
+		got := runTestCheck(t, test.check, map[string]string{
+			"api-resources/nodes.yaml": testNodes,
+			"api-resources/pods.yaml":  testNodePods,
+		})
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s findings = %q; want %q", test.check, got, test.want)
+		}
+	}
+}
+
+func TestNodeAllocationReportsUnparsableQuantities(t *testing.T) {
+	got := runTestCheck(t, "node-allocation", map[string]string{
+		"api-resources/nodes.yaml": `items:
+- kind: Node
+  metadata:
+    name: node-1
+  status:
+    allocatable:
+      cpu: "4"
+      memory: 16GB
+`,
+	})
+	want := []string{`warning node/node-1: Node node-1 has allocatable memory "16GB" that bunk cannot parse: invalid quantity "16GB"`}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("node-allocation findings = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,310 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// checkReport : The checks that ran against a bundle and what they found
+type checkReport struct {
+	BundleRootDir string
+	Checks        []Check
+	Findings      []Finding
+}
+
+// reportWriters maps each --output format to the function that writes it
+var reportWriters = map[string]func(w io.Writer, report checkReport) error{
+	"json":     writeJSONReport,
+	"junit":    writeJUnitReport,
+	"sarif":    writeSARIFReport,
+	"markdown": writeMarkdownReport,
+}
+
+// findingsByCheck groups the report's findings by the name of the check that produced them
+func (r checkReport) findingsByCheck() map[string][]Finding {
+	grouped := map[string][]Finding{}
+	for _, finding := range r.Findings {
+		grouped[finding.Check] = append(grouped[finding.Check], finding)
+	}
+	return grouped
+}
+
+// relativePath returns path relative to the bundle root, falling back to path itself
+func (r checkReport) relativePath(path string) string {
+	rel, err := filepath.Rel(r.BundleRootDir, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// reference returns the finding's resource, or its file if it does not concern a single resource
+func (r checkReport) reference(finding Finding) string {
+	if finding.Resource != "" {
+		return finding.Resource
+	}
+	return r.relativePath(finding.File)
+}
+
+// writeJSONReport writes the bundle's name, a count of findings by severity and the findings
+func writeJSONReport(w io.Writer, report checkReport) error {
+	summary := map[string]int{}
+	for _, severity := range []Severity{SeverityInfo, SeverityWarning, SeverityError} {
+		summary[severity.String()] = 0
+	}
+	for _, finding := range report.Findings {
+		summary[finding.Severity.String()]++
+	}
+
+	// Paths are relative to the bundle root, so that reports of two copies of a bundle compare equal
+	findings := []Finding{}
+	for _, finding := range report.Findings {
+		if finding.File != "" {
+			finding.File = report.relativePath(finding.File)
+		}
+		if finding.LogFile != "" {
+			finding.LogFile = report.relativePath(finding.LogFile)
+		}
+		findings = append(findings, finding)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(struct {
+		Bundle   string         `json:"bundle"`
+		Summary  map[string]int `json:"summary"`
+		Findings []Finding      `json:"findings"`
+	}{filepath.Base(report.BundleRootDir), summary, findings})
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name       `xml:"testsuites"`
+	Name     string         `xml:"name,attr"`
+	Tests    int            `xml:"tests,attr"`
+	Failures int            `xml:"failures,attr"`
+	Suites   []junitTestSet `xml:"testsuite"`
+}
+
+type junitTestSet struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnitReport writes one test case per check; a check fails if it reported any warnings or errors
+func writeJUnitReport(w io.Writer, report checkReport) error {
+	grouped := report.findingsByCheck()
+	suite := junitTestSet{Name: "bunk check"}
+
+	for _, c := range report.Checks {
+		testCase := junitTestCase{Name: c.Name, ClassName: "bunk.check"}
+
+		var failures, details []string
+		for _, finding := range grouped[c.Name] {
+			line := fmt.Sprintf("[%s] %s: %s", finding.Severity, report.reference(finding), finding.Message)
+			if finding.Remediation != "" {
+				line += " (" + finding.Remediation + ")"
+			}
+			details = append(details, line)
+			if finding.Severity >= SeverityWarning {
+				failures = append(failures, line)
+			}
+		}
+
+		if len(failures) > 0 {
+			testCase.Failure = &junitFailure{
+				Message: fmt.Sprintf("%d finding(s)", len(failures)),
+				Type:    c.Name,
+				Text:    strings.Join(details, "\n"),
+			}
+			suite.Failures++
+		} else if len(details) > 0 {
+			testCase.SystemOut = strings.Join(details, "\n")
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+		suite.Tests++
+	}
+
+	suites := junitTestSuites{
+		Name:     "bunk",
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Suites:   []junitTestSet{suite},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// sarifLevels maps severities to SARIF result levels
+var sarifLevels = map[Severity]string{
+	SeverityInfo:    "note",
+	SeverityWarning: "warning",
+	SeverityError:   "error",
+}
+
+// writeSARIFReport writes a SARIF 2.1.0 log with one rule per check
+func writeSARIFReport(w io.Writer, report checkReport) error {
+	type message struct {
+		Text string `json:"text"`
+	}
+	type artifactLocation struct {
+		URI       string `json:"uri"`
+		URIBaseID string `json:"uriBaseId,omitempty"`
+	}
+	type location struct {
+		PhysicalLocation *struct {
+			ArtifactLocation artifactLocation `json:"artifactLocation"`
+		} `json:"physicalLocation,omitempty"`
+		LogicalLocations []struct {
+			FullyQualifiedName string `json:"fullyQualifiedName"`
+		} `json:"logicalLocations,omitempty"`
+	}
+	type result struct {
+		RuleID    string     `json:"ruleId"`
+		Level     string     `json:"level"`
+		Message   message    `json:"message"`
+		Locations []location `json:"locations,omitempty"`
+	}
+	type rule struct {
+		ID               string  `json:"id"`
+		ShortDescription message `json:"shortDescription"`
+	}
+
+	rules := []rule{}
+	for _, c := range report.Checks {
+		rules = append(rules, rule{ID: c.Name, ShortDescription: message{c.Description}})
+	}
+
+	results := []result{}
+	for _, finding := range report.Findings {
+		text := finding.Message
+		if finding.Remediation != "" {
+			text += ". " + finding.Remediation
+		}
+		r := result{
+			RuleID:  finding.Check,
+			Level:   sarifLevels[finding.Severity],
+			Message: message{text},
+		}
+
+		var loc location
+		if finding.File != "" {
+			loc.PhysicalLocation = &struct {
+				ArtifactLocation artifactLocation `json:"artifactLocation"`
+			}{artifactLocation{URI: report.relativePath(finding.File), URIBaseID: "BUNDLEROOT"}}
+		}
+		if finding.Resource != "" {
+			loc.LogicalLocations = []struct {
+				FullyQualifiedName string `json:"fullyQualifiedName"`
+			}{{finding.Resource}}
+		}
+		if loc.PhysicalLocation != nil || loc.LogicalLocations != nil {
+			r.Locations = []location{loc}
+		}
+		results = append(results, r)
+	}
+
+	sarifLog := map[string]interface{}{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []interface{}{
+			map[string]interface{}{
+				"tool": map[string]interface{}{
+					"driver": map[string]interface{}{
+						"name":           "bunk",
+						"informationUri": "https://github.com/some-things/bunk",
+						"rules":          rules,
+					},
+				},
+				"originalUriBaseIds": map[string]interface{}{
+					"BUNDLEROOT": map[string]string{"uri": "file://" + filepath.ToSlash(report.BundleRootDir) + "/"},
+				},
+				"results": results,
+			},
+		},
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(sarifLog)
+}
+
+// markdownEscape escapes text for use in a markdown table cell
+func markdownEscape(text string) string {
+	text = strings.ReplaceAll(text, "|", "\\|")
+	return strings.ReplaceAll(text, "\n", " ")
+}
+
+func writeMarkdownReport(w io.Writer, report checkReport) error {
+	counts := map[Severity]int{}
+	for _, finding := range report.Findings {
+		counts[finding.Severity]++
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# bunk check: %s\n\n", filepath.Base(report.BundleRootDir))
+	fmt.Fprintf(&b, "%d error(s), %d warning(s), %d info from %d check(s)\n\n",
+		counts[SeverityError], counts[SeverityWarning], counts[SeverityInfo], len(report.Checks))
+
+	if len(report.Findings) == 0 {
+		b.WriteString("No problems found.\n")
+	} else {
+		b.WriteString("| Severity | Check | Resource | Message | Remediation |\n")
+		b.WriteString("| --- | --- | --- | --- | --- |\n")
+		for _, finding := range report.Findings {
+			fmt.Fprintf(&b, "| %s | %s | `%s` | %s | %s |\n",
+				finding.Severity,
+				finding.Check,
+				markdownEscape(report.reference(finding)),
+				markdownEscape(finding.Message),
+				markdownEscape(finding.Remediation))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,100 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// updateGolden rewrites the golden files of tests with their current output
+var updateGolden = flag.Bool("update", false, "Rewrite the golden files in testdata")
+
+// testCheckReport is the report of a bundle extracted to bundleRootDir
+func testCheckReport(bundleRootDir string) checkReport {
+	return checkReport{
+		BundleRootDir: bundleRootDir,
+		Checks: []Check{
+			{Name: "pod-crashloop", Description: "Reports containers in CrashLoopBackOff"},
+			{Name: "pod-restarts", Description: "Reports containers restarting more often than --restart-threshold"},
+			{Name: "resource-files", Description: "Reports api-resources files that could not be parsed"},
+			{Name: "node-not-ready", Description: "Reports nodes whose Ready condition is not True"},
+		},
+		Findings: []Finding{
+			{
+				Check:       "pod-crashloop",
+				Severity:    SeverityError,
+				Resource:    "pod/default/web",
+				File:        filepath.Join(bundleRootDir, "kube", "api-resources", "pods.yaml"),
+				LogFile:     filepath.Join(bundleRootDir, "kube", "pods_logs", "default_web_app.log"),
+				Message:     "Container app is in CrashLoopBackOff after 12 restarts",
+				Remediation: "Check the container logs | exit code",
+			},
+			{
+				Check:       "pod-restarts",
+				Severity:    SeverityWarning,
+				Resource:    "pod/default/web",
+				File:        filepath.Join(bundleRootDir, "kube", "api-resources", "pods.yaml"),
+				Message:     "Container app restarted 12 times",
+				Remediation: "Check the container logs and events for the cause of the restarts",
+			},
+			{
+				Check:    "resource-files",
+				Severity: SeverityInfo,
+				File:     filepath.Join(bundleRootDir, "kube", "api-resources", "leases.coordination.k8s.io.yaml"),
+				Message:  "Could not parse leases.coordination.k8s.io.yaml: <bad & broken>",
+			},
+		},
+	}
+}
+
+func TestReportWritersGolden(t *testing.T) {
+	for output, golden := range map[string]string{
+		"json":     "check-report.json",
+		"junit":    "check-report.junit.xml",
+		"sarif":    "check-report.sarif.json",
+		"markdown": "check-report.md",
+	} {
+		var buffers [2]bytes.Buffer
+		// Two extractions of the same bundle give the same report, except for SARIF's base URI
+		for i, bundleRootDir := range []string{"/home/a/tickets/12345/bundle-prod", "/tmp/elsewhere/bundle-prod"} {
+			if err := reportWriters[output](&buffers[i], testCheckReport(bundleRootDir)); err != nil {
+				t.Fatalf("%s: %v", output, err)
+			}
+		}
+		if output != "sarif" && !bytes.Equal(buffers[0].Bytes(), buffers[1].Bytes()) {
+			t.Errorf("%s reports of the same bundle extracted to two dirs differ:\n%s\n%s", output, buffers[0].String(), buffers[1].String())
+		}
+
+		path := filepath.Join("testdata", golden)
+		if *updateGolden {
+			if err := ioutil.WriteFile(path, buffers[0].Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := buffers[0].String(); got != string(want) {
+			t.Errorf("%s report differs from %s; rerun with -update if that is intended:\n%s", output, path, got)
+		}
+	}
+}
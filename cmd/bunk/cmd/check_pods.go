@@ -0,0 +1,200 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// Pod : Subset of a Kubernetes pod used by checks
+type Pod struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Spec struct {
+		NodeName       string      `json:"nodeName"`
+		Containers     []Container `json:"containers"`
+		InitContainers []Container `json:"initContainers"`
+	} `json:"spec"`
+	Status struct {
+		Phase                 string            `json:"phase"`
+		ContainerStatuses     []ContainerStatus `json:"containerStatuses"`
+		InitContainerStatuses []ContainerStatus `json:"initContainerStatuses"`
+	} `json:"status"`
+}
+
+// Container : Subset of a pod's container spec used by checks
+type Container struct {
+	Name      string `json:"name"`
+	Image     string `json:"image"`
+	Resources struct {
+		Requests map[string]string `json:"requests"`
+		Limits   map[string]string `json:"limits"`
+	} `json:"resources"`
+}
+
+// ContainerStatus : Subset of a pod's container status used by checks
+type ContainerStatus struct {
+	Name         string         `json:"name"`
+	Image        string         `json:"image"`
+	RestartCount int            `json:"restartCount"`
+	State        ContainerState `json:"state"`
+	LastState    ContainerState `json:"lastState"`
+}
+
+// ContainerState : The waiting or terminated state of a container
+type ContainerState struct {
+	Waiting *struct {
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	} `json:"waiting"`
+	Terminated *struct {
+		Reason   string `json:"reason"`
+		Message  string `json:"message"`
+		ExitCode int    `json:"exitCode"`
+	} `json:"terminated"`
+}
+
+// podContainerCheck : A rule returning a finding for a single container, or nil
+type podContainerCheck func(pod Pod, status ContainerStatus) *Finding
+
+// checkPodContainers runs rule against every container status of every pod
+// and fills in the resource, file and matching pods_logs file of each finding
+func checkPodContainers(resources *BundleResources, rule podContainerCheck) []Finding {
+	var findings []Finding
+	for _, object := range resources.Objects("pods", "") {
+		var pod Pod
+		if err := object.Decode(&pod); err != nil {
+			continue
+		}
+
+		statuses := append([]ContainerStatus{}, pod.Status.InitContainerStatuses...)
+		statuses = append(statuses, pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			finding := rule(pod, status)
+			if finding == nil {
+				continue
+			}
+			finding.Resource = object.Ref()
+			finding.File = object.File
+			finding.LogFile = resources.PodLogFile(pod.Metadata.Namespace, pod.Metadata.Name, status.Name)
+			if finding.LogFile != "" {
+				finding.Remediation += fmt.Sprintf("; view the logs with `bunk log view %s %s -c %s`", pod.Metadata.Namespace, pod.Metadata.Name, status.Name)
+			}
+			findings = append(findings, *finding)
+		}
+	}
+	return findings
+}
+
+// lastTermination describes why a container last terminated, if known
+func lastTermination(status ContainerStatus) string {
+	if t := status.LastState.Terminated; t != nil {
+		return fmt.Sprintf(" (last terminated: %s, exit code %d)", t.Reason, t.ExitCode)
+	}
+	return ""
+}
+
+func init() {
+	checkCmd.Flags().Int("restart-threshold", 5, "Report containers that restarted more than this many times")
+	viper.BindPFlag("checks.restartThreshold", checkCmd.Flags().Lookup("restart-threshold"))
+
+	registerCheck(Check{
+		Name:        "pod-crashloop",
+		Description: "Reports containers in CrashLoopBackOff",
+		Run: func(resources *BundleResources) []Finding {
+			return checkPodContainers(resources, func(pod Pod, status ContainerStatus) *Finding {
+				if status.State.Waiting == nil || status.State.Waiting.Reason != "CrashLoopBackOff" {
+					return nil
+				}
+				return &Finding{
+					Severity:    SeverityError,
+					Message:     fmt.Sprintf("Container %s is in CrashLoopBackOff after %d restarts%s", status.Name, status.RestartCount, lastTermination(status)),
+					Remediation: "Check the container logs and exit code for the cause of the crash",
+				}
+			})
+		},
+	})
+
+	registerCheck(Check{
+		Name:        "pod-oomkilled",
+		Description: "Reports containers that were killed for exceeding their memory limit",
+		Run: func(resources *BundleResources) []Finding {
+			return checkPodContainers(resources, func(pod Pod, status ContainerStatus) *Finding {
+				for _, state := range []ContainerState{status.State, status.LastState} {
+					if state.Terminated != nil && state.Terminated.Reason == "OOMKilled" {
+						return &Finding{
+							Severity:    SeverityError,
+							Message:     fmt.Sprintf("Container %s was OOMKilled", status.Name),
+							Remediation: "Raise the container's memory limit or investigate its memory usage",
+						}
+					}
+				}
+				return nil
+			})
+		},
+	})
+
+	registerCheck(Check{
+		Name:        "pod-image-pull",
+		Description: "Reports containers stuck in ImagePullBackOff or ErrImagePull",
+		Run: func(resources *BundleResources) []Finding {
+			return checkPodContainers(resources, func(pod Pod, status ContainerStatus) *Finding {
+				if status.State.Waiting == nil {
+					return nil
+				}
+				switch status.State.Waiting.Reason {
+				case "ImagePullBackOff", "ErrImagePull", "InvalidImageName":
+				default:
+					return nil
+				}
+
+				image := status.Image
+				for _, c := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+					if c.Name == status.Name {
+						image = c.Image
+					}
+				}
+				return &Finding{
+					Severity:    SeverityError,
+					Message:     fmt.Sprintf("Container %s cannot pull image %s: %s %s", status.Name, image, status.State.Waiting.Reason, status.State.Waiting.Message),
+					Remediation: "Verify the image name and tag, registry reachability and image pull secrets",
+				}
+			})
+		},
+	})
+
+	registerCheck(Check{
+		Name:        "pod-restarts",
+		Description: "Reports containers restarting more often than --restart-threshold",
+		Run: func(resources *BundleResources) []Finding {
+			threshold := viper.GetInt("checks.restartThreshold")
+			return checkPodContainers(resources, func(pod Pod, status ContainerStatus) *Finding {
+				if status.RestartCount <= threshold {
+					return nil
+				}
+				return &Finding{
+					Severity:    SeverityWarning,
+					Message:     fmt.Sprintf("Container %s restarted %d times%s", status.Name, status.RestartCount, lastTermination(status)),
+					Remediation: "Check the container logs and events for the cause of the restarts",
+				}
+			})
+		},
+	})
+}
@@ -0,0 +1,166 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testPods are pods in each of the states the pod checks report, and one healthy pod
+const testPods = `items:
+- kind: Pod
+  metadata:
+    name: crashing
+    namespace: default
+  spec:
+    containers:
+    - name: app
+      image: app:1.0
+  status:
+    containerStatuses:
+    - name: app
+      restartCount: 12
+      state:
+        waiting:
+          reason: CrashLoopBackOff
+      lastState:
+        terminated:
+          reason: Error
+          exitCode: 137
+- kind: Pod
+  metadata:
+    name: oom
+    namespace: default
+  spec:
+    containers:
+    - name: app
+      image: app:1.0
+  status:
+    containerStatuses:
+    - name: app
+      restartCount: 1
+      state:
+        running: {}
+      lastState:
+        terminated:
+          reason: OOMKilled
+          exitCode: 137
+- kind: Pod
+  metadata:
+    name: pulling
+    namespace: web
+  spec:
+    initContainers:
+    - name: migrate
+      image: registry.local/migrate:v2
+    containers:
+    - name: app
+      image: app:1.0
+  status:
+    initContainerStatuses:
+    - name: migrate
+      image: ""
+      state:
+        waiting:
+          reason: ImagePullBackOff
+          message: Back-off pulling image
+- kind: Pod
+  metadata:
+    name: healthy
+    namespace: default
+  spec:
+    containers:
+    - name: app
+      image: app:1.0
+  status:
+    containerStatuses:
+    - name: app
+      restartCount: 0
+      state:
+        running: {}
+`
+
+func TestPodChecks(t *testing.T) {
+	tests := []struct {
+		check string
+		want  []string
+	}{
+		{
+			check: "pod-crashloop",
+			want:  []string{"error pod/default/crashing: Container app is in CrashLoopBackOff after 12 restarts (last terminated: Error, exit code 137)"},
+		},
+		{
+			check: "pod-oomkilled",
+			want:  []string{"error pod/default/oom: Container app was OOMKilled"},
+		},
+		{
+			check: "pod-image-pull",
+			want:  []string{"error pod/web/pulling: Container migrate cannot pull image registry.local/migrate:v2: ImagePullBackOff Back-off pulling image"},
+		},
+		{
+			check: "pod-restarts",
+			want:  []string{"warning pod/default/crashing: Container app restarted 12 times (last terminated: Error, exit code 137)"},
+		},
+	}
+
+	for _, test := range tests {
+		got := runTestCheck(t, test.check, map[string]string{"api-resources/pods.yaml": testPods})
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s findings = %q; want %q", test.check, got, test.want)
+		}
+	}
+}
+
+func TestPodChecksPointAtLogs(t *testing.T) {
+	bundleRootDir := writeTestBundle(t, map[string]string{
+		"api-resources/pods.yaml":                testPods,
+		"pods_logs/default_crashing_app.log":     "panic: boom\n",
+		"pods_logs/default_crashing_sidecar.log": "ok\n",
+		"pods_logs/kube-system_crashing_app.log": "",
+		"pods_logs/web_pulling.log":              "",
+	})
+	defer os.RemoveAll(bundleRootDir)
+
+	resources := loadBundleResources(bundleRootDir, filepath.Join(bundleRootDir, "api-resources"))
+	selected, err := selectChecks([]string{"pod-crashloop", "pod-image-pull"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings := runChecks(selected, resources)
+	if len(findings) != 2 {
+		t.Fatalf("pod checks found %d problems; want 2", len(findings))
+	}
+	for _, finding := range findings {
+		switch finding.Resource {
+		case "pod/default/crashing":
+			// The container's own log file, not another container's or another namespace's
+			if filepath.Base(finding.LogFile) != "default_crashing_app.log" || !strings.HasSuffix(finding.Remediation, "`bunk log view default crashing -c app`") {
+				t.Errorf("crashing pod finding has log %q and hint %q", finding.LogFile, finding.Remediation)
+			}
+		case "pod/web/pulling":
+			// The per-pod log file when there is no per-container one
+			if filepath.Base(finding.LogFile) != "web_pulling.log" {
+				t.Errorf("pulling pod finding has log %q; want web_pulling.log", finding.LogFile)
+			}
+		default:
+			t.Errorf("unexpected finding for %s", finding.Resource)
+		}
+	}
+}
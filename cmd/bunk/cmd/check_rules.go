@@ -0,0 +1,382 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/viper"
+	"sigs.k8s.io/yaml"
+)
+
+// RuleFile : A yaml file of user-defined check rules
+type RuleFile struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule : A user-defined check comparing a field of every object of a resource against a predicate
+//
+// Field is a JSONPath-style selector such as .spec.containers[*].image or
+// .metadata.labels['app.kubernetes.io/name']. Message and Remediation are
+// templates with access to .Name, .Namespace and .Value of the matching object.
+type Rule struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Resource    string   `json:"resource"`
+	Group       string   `json:"group"`
+	Field       string   `json:"field"`
+	Predicate   string   `json:"predicate"`
+	Value       string   `json:"value"`
+	Severity    Severity `json:"severity"`
+	Message     string   `json:"message"`
+	Remediation string   `json:"remediation"`
+}
+
+// rulePredicate : Compares a field value against the value of a rule
+type rulePredicate func(field interface{}) bool
+
+// rulePredicates maps predicate names to functions that compile the rule's value into a
+// predicate, so that invalid values are reported when the rule is loaded
+var rulePredicates = map[string]func(value string) (rulePredicate, error){
+	"exists": func(value string) (rulePredicate, error) {
+		return func(field interface{}) bool { return field != nil }, nil
+	},
+	"notExists": func(value string) (rulePredicate, error) {
+		return func(field interface{}) bool { return field == nil }, nil
+	},
+	"equals": func(value string) (rulePredicate, error) {
+		return func(field interface{}) bool {
+			return field != nil && fieldString(field) == value
+		}, nil
+	},
+	"notEquals": func(value string) (rulePredicate, error) {
+		return func(field interface{}) bool {
+			return field == nil || fieldString(field) != value
+		}, nil
+	},
+	"contains": func(value string) (rulePredicate, error) {
+		return func(field interface{}) bool {
+			return field != nil && strings.Contains(fieldString(field), value)
+		}, nil
+	},
+	"matches": func(value string) (rulePredicate, error) {
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(field interface{}) bool {
+			return field != nil && re.MatchString(fieldString(field))
+		}, nil
+	},
+	"notMatches": func(value string) (rulePredicate, error) {
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(field interface{}) bool {
+			return field == nil || !re.MatchString(fieldString(field))
+		}, nil
+	},
+	"greaterThan": func(value string) (rulePredicate, error) {
+		b, err := ruleNumber(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(field interface{}) bool {
+			a, ok := fieldNumber(field)
+			return ok && a > b
+		}, nil
+	},
+	"lessThan": func(value string) (rulePredicate, error) {
+		b, err := ruleNumber(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(field interface{}) bool {
+			a, ok := fieldNumber(field)
+			return ok && a < b
+		}, nil
+	},
+}
+
+// fieldString formats a field value for comparison and messages
+func fieldString(field interface{}) string {
+	switch v := field.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	return fmt.Sprint(field)
+}
+
+// ruleNumber parses the value of a rule as a number, accepting Kubernetes quantities such as 2Gi
+func ruleNumber(value string) (float64, error) {
+	n, err := parseQuantity(value)
+	if err != nil {
+		return 0, fmt.Errorf("rule value %q is not a number: %v", value, err)
+	}
+	return n, nil
+}
+
+// fieldNumber parses a field as a number, reporting whether it is one
+func fieldNumber(field interface{}) (float64, bool) {
+	if field == nil {
+		return 0, false
+	}
+	n, err := parseQuantity(fieldString(field))
+	return n, err == nil
+}
+
+// fieldPathPattern matches one step of a field selector: .name, ['name'], [0] or [*]
+var fieldPathPattern = regexp.MustCompile(`^(?:\.([^.\[]+)|\['([^']*)'\]|\["([^"]*)"\]|\[(\d+|\*)\])`)
+
+// parseFieldPath splits a JSONPath-style selector into its steps
+func parseFieldPath(path string) ([]string, error) {
+	path = strings.TrimSpace(path)
+	path = strings.TrimSuffix(strings.TrimPrefix(path, "{"), "}")
+	path = strings.TrimPrefix(path, "$")
+	if path != "" && path[0] != '.' && path[0] != '[' {
+		path = "." + path
+	}
+
+	var steps []string
+	for path != "" {
+		m := fieldPathPattern.FindStringSubmatch(path)
+		if m == nil {
+			return nil, fmt.Errorf("invalid field selector near %q", path)
+		}
+		switch {
+		case m[1] != "":
+			steps = append(steps, m[1])
+		case m[4] != "":
+			steps = append(steps, "["+m[4]+"]")
+		case strings.HasPrefix(m[0], "['"):
+			steps = append(steps, m[2])
+		default:
+			steps = append(steps, m[3])
+		}
+		path = path[len(m[0]):]
+	}
+	return steps, nil
+}
+
+// selectFields returns every value reached by following steps from object.
+// A step that does not exist yields a single nil value so that exists/notExists can be evaluated.
+func selectFields(object interface{}, steps []string) []interface{} {
+	if len(steps) == 0 {
+		return []interface{}{object}
+	}
+	step, rest := steps[0], steps[1:]
+
+	if strings.HasPrefix(step, "[") && strings.HasSuffix(step, "]") {
+		list, ok := object.([]interface{})
+		if !ok {
+			return []interface{}{nil}
+		}
+		if step == "[*]" {
+			var values []interface{}
+			for _, item := range list {
+				values = append(values, selectFields(item, rest)...)
+			}
+			return values
+		}
+		i, _ := strconv.Atoi(step[1 : len(step)-1])
+		if i >= len(list) {
+			return []interface{}{nil}
+		}
+		return selectFields(list[i], rest)
+	}
+
+	m, ok := object.(map[string]interface{})
+	if !ok {
+		return []interface{}{nil}
+	}
+	return selectFields(m[step], rest)
+}
+
+// ruleTemplateData : Values available to a rule's message and remediation templates
+type ruleTemplateData struct {
+	Name      string
+	Namespace string
+	Value     string
+}
+
+// check compiles the rule into a Check, validating it first
+func (rule Rule) check(source string) (Check, error) {
+	if rule.Name == "" {
+		return Check{}, fmt.Errorf("%s: rule is missing a name", source)
+	}
+	if rule.Resource == "" {
+		return Check{}, fmt.Errorf("%s: rule %s is missing a resource", source, rule.Name)
+	}
+	newPredicate, ok := rulePredicates[rule.Predicate]
+	if !ok {
+		return Check{}, fmt.Errorf("%s: rule %s has unknown predicate %q", source, rule.Name, rule.Predicate)
+	}
+	predicate, err := newPredicate(rule.Value)
+	if err != nil {
+		return Check{}, fmt.Errorf("%s: rule %s %s: %v", source, rule.Name, rule.Predicate, err)
+	}
+	steps, err := parseFieldPath(rule.Field)
+	if err != nil {
+		return Check{}, fmt.Errorf("%s: rule %s: %v", source, rule.Name, err)
+	}
+	if rule.Message == "" {
+		rule.Message = strings.TrimSpace(fmt.Sprintf("%s matched %s %s", rule.Field, rule.Predicate, rule.Value)) + " (value: {{.Value}})"
+	}
+	message, err := template.New("message").Parse(rule.Message)
+	if err != nil {
+		return Check{}, fmt.Errorf("%s: rule %s message: %v", source, rule.Name, err)
+	}
+	remediation, err := template.New("remediation").Parse(rule.Remediation)
+	if err != nil {
+		return Check{}, fmt.Errorf("%s: rule %s remediation: %v", source, rule.Name, err)
+	}
+	// Executing the templates once catches fields that ruleTemplateData does not have
+	if err := message.Execute(ioutil.Discard, ruleTemplateData{}); err != nil {
+		return Check{}, fmt.Errorf("%s: rule %s message: %v", source, rule.Name, err)
+	}
+	if err := remediation.Execute(ioutil.Discard, ruleTemplateData{}); err != nil {
+		return Check{}, fmt.Errorf("%s: rule %s remediation: %v", source, rule.Name, err)
+	}
+
+	description := rule.Description
+	if description == "" {
+		description = fmt.Sprintf("User-defined rule from %s", source)
+	}
+
+	return Check{
+		Name:        rule.Name,
+		Description: description,
+		Run: func(resources *BundleResources) []Finding {
+			failed := func(err error) []Finding {
+				return []Finding{{
+					Severity: SeverityWarning,
+					File:     source,
+					Message:  fmt.Sprintf("Rule %s could not be evaluated: %v", rule.Name, err),
+				}}
+			}
+
+			var findings []Finding
+			for _, object := range resources.Objects(rule.Resource, rule.Group) {
+				var content interface{}
+				if err := object.Decode(&content); err != nil {
+					continue
+				}
+
+				for _, field := range selectFields(content, steps) {
+					if !predicate(field) {
+						continue
+					}
+
+					data := ruleTemplateData{
+						Name:      object.Metadata.Name,
+						Namespace: object.Metadata.Namespace,
+						Value:     fieldString(field),
+					}
+					var m, r bytes.Buffer
+					if err := message.Execute(&m, data); err != nil {
+						return failed(fmt.Errorf("message: %v", err))
+					}
+					if err := remediation.Execute(&r, data); err != nil {
+						return failed(fmt.Errorf("remediation: %v", err))
+					}
+					findings = append(findings, Finding{
+						Severity:    rule.Severity,
+						Resource:    object.Ref(),
+						File:        object.File,
+						Message:     m.String(),
+						Remediation: r.String(),
+					})
+				}
+			}
+			return findings
+		},
+	}, nil
+}
+
+// findRuleFiles expands rule paths into yaml files; directories contribute their *.yaml and *.yml files
+func findRuleFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		path, err := homedir.Expand(path)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		for _, pattern := range []string{"*.yaml", "*.yml"} {
+			matches, err := filepath.Glob(filepath.Join(path, pattern))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, matches...)
+		}
+	}
+	return files, nil
+}
+
+// registerRuleFiles loads user-defined rules from the config's checks.paths and
+// the given paths, registering each as a check
+func registerRuleFiles(paths []string) error {
+	files, err := findRuleFiles(append(viper.GetStringSlice("checks.paths"), paths...))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var ruleFile RuleFile
+		if err := yaml.Unmarshal(content, &ruleFile); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		for _, rule := range ruleFile.Rules {
+			c, err := rule.check(file)
+			if err != nil {
+				return err
+			}
+			registerCheck(c)
+		}
+	}
+	return nil
+}
+
+func init() {
+	checkCmd.Flags().StringSlice("rules", nil, "Load user-defined rules from these yaml files or directories (may be repeated)")
+}
@@ -0,0 +1,187 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestParseFieldPath(t *testing.T) {
+	tests := []struct {
+		path  string
+		steps []string
+		err   bool
+	}{
+		{path: ".spec.replicas", steps: []string{"spec", "replicas"}},
+		{path: "spec.replicas", steps: []string{"spec", "replicas"}},
+		{path: "{$.spec.replicas}", steps: []string{"spec", "replicas"}},
+		{path: "  .status  ", steps: []string{"status"}},
+		{path: ".metadata.labels['app.kubernetes.io/name']", steps: []string{"metadata", "labels", "app.kubernetes.io/name"}},
+		{path: `.metadata.annotations["a.b/c"]`, steps: []string{"metadata", "annotations", "a.b/c"}},
+		{path: ".spec.containers[0].image", steps: []string{"spec", "containers", "[0]", "image"}},
+		{path: ".spec.containers[*].ports[*].containerPort", steps: []string{"spec", "containers", "[*]", "ports", "[*]", "containerPort"}},
+		{path: "", steps: nil},
+		{path: ".spec..replicas", err: true},
+		{path: ".spec.containers[-1]", err: true},
+		{path: ".metadata.labels['unterminated", err: true},
+		{path: ".spec[x]", err: true},
+	}
+
+	for _, test := range tests {
+		steps, err := parseFieldPath(test.path)
+		if test.err {
+			if err == nil {
+				t.Errorf("parseFieldPath(%q) = %q; want an error", test.path, steps)
+			}
+			continue
+		}
+		if err != nil || fmt.Sprint(steps) != fmt.Sprint(test.steps) {
+			t.Errorf("parseFieldPath(%q) = %q, %v; want %q", test.path, steps, err, test.steps)
+		}
+	}
+}
+
+func TestSelectFields(t *testing.T) {
+	var object interface{}
+	err := json.Unmarshal([]byte(`{
+		"metadata": {"labels": {"app.kubernetes.io/name": "web"}},
+		"spec": {"containers": [
+			{"image": "nginx:1.19", "ports": [{"containerPort": 80}, {"containerPort": 443}]},
+			{"image": "envoy:latest"}
+		]}
+	}`), &object)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: ".metadata.labels['app.kubernetes.io/name']", want: "[web]"},
+		{path: ".spec.containers[1].image", want: "[envoy:latest]"},
+		{path: ".spec.containers[*].image", want: "[nginx:1.19 envoy:latest]"},
+		{path: ".spec.containers[*].ports[*].containerPort", want: "[80 443 <nil>]"},
+		{path: ".spec.containers[2].image", want: "[<nil>]"},
+		{path: ".spec.missing.field", want: "[<nil>]"},
+		{path: ".metadata.labels[0]", want: "[<nil>]"},
+	}
+	for _, test := range tests {
+		steps, err := parseFieldPath(test.path)
+		if err != nil {
+			t.Fatalf("parseFieldPath(%q): %v", test.path, err)
+		}
+		if got := fmt.Sprint(selectFields(object, steps)); got != test.want {
+			t.Errorf("selectFields(%q) = %s; want %s", test.path, got, test.want)
+		}
+	}
+}
+
+func TestRulePredicates(t *testing.T) {
+	tests := []struct {
+		predicate string
+		value     string
+		field     interface{}
+		want      bool
+	}{
+		{predicate: "exists", field: "x", want: true},
+		{predicate: "exists", field: nil, want: false},
+		{predicate: "notExists", field: nil, want: true},
+		{predicate: "notExists", field: false, want: false},
+		{predicate: "equals", value: "Always", field: "Always", want: true},
+		{predicate: "equals", value: "3", field: float64(3), want: true},
+		{predicate: "equals", value: "", field: nil, want: false},
+		{predicate: "notEquals", value: "Always", field: "IfNotPresent", want: true},
+		{predicate: "notEquals", value: "Always", field: nil, want: true},
+		{predicate: "notEquals", value: "Always", field: "Always", want: false},
+		{predicate: "contains", value: "latest", field: "envoy:latest", want: true},
+		{predicate: "contains", value: `"a":"b"`, field: map[string]interface{}{"a": "b"}, want: true},
+		{predicate: "contains", value: "latest", field: nil, want: false},
+		{predicate: "matches", value: ":latest$|^[^:]+$", field: "nginx", want: true},
+		{predicate: "matches", value: ":latest$", field: "nginx:1.19", want: false},
+		{predicate: "notMatches", value: "^registry\\.local/", field: "docker.io/nginx", want: true},
+		{predicate: "notMatches", value: "^registry\\.local/", field: nil, want: true},
+		{predicate: "notMatches", value: "^registry\\.local/", field: "registry.local/nginx", want: false},
+		{predicate: "greaterThan", value: "5", field: float64(6), want: true},
+		{predicate: "greaterThan", value: "1Gi", field: "2Gi", want: true},
+		{predicate: "greaterThan", value: "1Gi", field: "512Mi", want: false},
+		{predicate: "greaterThan", value: "5", field: "lots", want: false},
+		{predicate: "greaterThan", value: "5", field: nil, want: false},
+		{predicate: "lessThan", value: "1", field: "500m", want: true},
+		{predicate: "lessThan", value: "1", field: "2", want: false},
+		// Binary suffixes on the rule value, the field or both, as kubelets report memory in Ki
+		{predicate: "greaterThan", value: "2Ki", field: "4Ki", want: true},
+		{predicate: "greaterThan", value: "2Ki", field: "2048", want: false},
+		{predicate: "greaterThan", value: "1Gi", field: "16246588Ki", want: true},
+		{predicate: "greaterThan", value: "16Gi", field: "16246588Ki", want: false},
+		{predicate: "lessThan", value: "1Mi", field: "512Ki", want: true},
+		{predicate: "lessThan", value: "1024Ki", field: "1Mi", want: false},
+		{predicate: "lessThan", value: "1Ki", field: "1000", want: true},
+		{predicate: "equals", value: "4Ki", field: "4Ki", want: true},
+	}
+
+	for _, test := range tests {
+		predicate, err := rulePredicates[test.predicate](test.value)
+		if err != nil {
+			t.Errorf("%s %q: %v", test.predicate, test.value, err)
+			continue
+		}
+		if got := predicate(test.field); got != test.want {
+			t.Errorf("%s %q of %#v = %v; want %v", test.predicate, test.value, test.field, got, test.want)
+		}
+	}
+}
+
+func TestRulePredicatesRejectInvalidValues(t *testing.T) {
+	for _, test := range []struct{ predicate, value string }{
+		{"matches", "("},
+		{"notMatches", "[a-"},
+		{"greaterThan", "many"},
+		{"greaterThan", "5Qi"},
+		{"greaterThan", "5KiB"},
+		{"lessThan", "Ki"},
+		{"lessThan", ""},
+	} {
+		if _, err := rulePredicates[test.predicate](test.value); err == nil {
+			t.Errorf("%s %q compiled; want an error", test.predicate, test.value)
+		}
+	}
+}
+
+func TestRuleCheckValidates(t *testing.T) {
+	valid := Rule{Name: "latest", Resource: "pods", Field: ".spec.containers[*].image", Predicate: "contains", Value: ":latest"}
+	if _, err := valid.check("rules.yaml"); err != nil {
+		t.Errorf("check of a valid rule: %v", err)
+	}
+
+	for name, change := range map[string]func(*Rule){
+		"no name":               func(r *Rule) { r.Name = "" },
+		"no resource":           func(r *Rule) { r.Resource = "" },
+		"unknown predicate":     func(r *Rule) { r.Predicate = "startsWith" },
+		"non-numeric operand":   func(r *Rule) { r.Predicate, r.Value = "greaterThan", "many" },
+		"invalid field":         func(r *Rule) { r.Field = ".spec[x]" },
+		"unparsable message":    func(r *Rule) { r.Message = "{{.Name" },
+		"unknown template data": func(r *Rule) { r.Remediation = "{{.Image}}" },
+	} {
+		rule := valid
+		change(&rule)
+		if _, err := rule.check("rules.yaml"); err == nil {
+			t.Errorf("check of a rule with %s succeeded; want an error", name)
+		}
+	}
+}
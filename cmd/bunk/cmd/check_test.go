@@ -0,0 +1,150 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestBundle writes files, keyed by their path below the bundle root, into a new temp
+// bundle dir and returns it
+func writeTestBundle(t *testing.T, files map[string]string) string {
+	t.Helper()
+	bundleRootDir, err := ioutil.TempDir("", "bunk-bundle-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(bundleRootDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bundleRootDir
+}
+
+// runTestCheck runs the registered check called name against the resources of a bundle made of
+// files, returning its findings as <severity> <resource>: <message>
+func runTestCheck(t *testing.T, name string, files map[string]string) []string {
+	t.Helper()
+	bundleRootDir := writeTestBundle(t, files)
+	defer os.RemoveAll(bundleRootDir)
+
+	selected, err := selectChecks([]string{name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources := loadBundleResources(bundleRootDir, filepath.Join(bundleRootDir, "api-resources"))
+	var findings []string
+	for _, finding := range runChecks(selected, resources) {
+		findings = append(findings, fmt.Sprintf("%s %s: %s", finding.Severity, finding.Resource, finding.Message))
+	}
+	return findings
+}
+
+func TestSeverityText(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want Severity
+	}{
+		{"info", SeverityInfo},
+		{"warning", SeverityWarning},
+		{"WARN", SeverityWarning},
+		{"Error", SeverityError},
+	} {
+		var severity Severity
+		if err := severity.UnmarshalText([]byte(test.name)); err != nil || severity != test.want {
+			t.Errorf("UnmarshalText(%q) = %v, %v; want %v", test.name, severity, err, test.want)
+		}
+	}
+	var severity Severity
+	if err := severity.UnmarshalText([]byte("fatal")); err == nil {
+		t.Errorf("UnmarshalText(fatal) = %v; want an error", severity)
+	}
+	if text, _ := SeverityWarning.MarshalText(); string(text) != "warning" {
+		t.Errorf("MarshalText(SeverityWarning) = %q; want warning", text)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	for _, test := range []struct {
+		severities []Severity
+		want       int
+	}{
+		{nil, 0},
+		{[]Severity{SeverityInfo}, 0},
+		{[]Severity{SeverityInfo, SeverityWarning}, 2},
+		{[]Severity{SeverityError, SeverityWarning}, 3},
+	} {
+		var findings []Finding
+		for _, severity := range test.severities {
+			findings = append(findings, Finding{Severity: severity})
+		}
+		if got := exitCode(findings); got != test.want {
+			t.Errorf("exitCode(%v) = %d; want %d", test.severities, got, test.want)
+		}
+	}
+}
+
+func TestSelectChecks(t *testing.T) {
+	if selected, err := selectChecks(nil); err != nil || len(selected) != len(checks) {
+		t.Errorf("selectChecks(nil) selected %d of %d checks, %v", len(selected), len(checks), err)
+	}
+	selected, err := selectChecks([]string{"pod-restarts", "resource-files"})
+	if err != nil || len(selected) != 2 || selected[0].Name != "pod-restarts" || selected[1].Name != "resource-files" {
+		t.Errorf("selectChecks(pod-restarts, resource-files) = %v, %v", selected, err)
+	}
+	if _, err := selectChecks([]string{"no-such-check"}); err == nil {
+		t.Errorf("selectChecks(no-such-check) succeeded; want an error")
+	}
+}
+
+func TestRunChecksOrdersFindings(t *testing.T) {
+	selected := []Check{
+		{Name: "b", Run: func(*BundleResources) []Finding {
+			return []Finding{{Severity: SeverityWarning, Resource: "pod/x"}, {Severity: SeverityError, Resource: "pod/z"}}
+		}},
+		{Name: "a", Run: func(*BundleResources) []Finding {
+			return []Finding{{Severity: SeverityWarning, Resource: "pod/y"}, {Severity: SeverityInfo}}
+		}},
+	}
+	var got []string
+	for _, finding := range runChecks(selected, &BundleResources{}) {
+		got = append(got, fmt.Sprintf("%s %s %s", finding.Severity, finding.Check, finding.Resource))
+	}
+	want := []string{"error b pod/z", "warning a pod/y", "warning b pod/x", "info a "}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("runChecks = %q; want %q", got, want)
+	}
+}
+
+func TestResourceFilesCheck(t *testing.T) {
+	findings := runTestCheck(t, "resource-files", map[string]string{
+		"api-resources/pods.yaml":             "items:\n- kind: Pod\n  metadata:\n    name: ok\n",
+		"api-resources/deployments.apps.yaml": "items: [unterminated\n",
+	})
+	if len(findings) != 1 || !strings.HasPrefix(findings[0], "warning : Could not parse deployments.apps.yaml") {
+		t.Errorf("resource-files findings = %q; want one for deployments.apps.yaml", findings)
+	}
+}
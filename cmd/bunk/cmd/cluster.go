@@ -0,0 +1,245 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// disabledControllers turns off every controller that would modify the replayed resources
+const disabledControllers = "controllers=-attachdetach,-clusterrole-aggregation,-cronjob,-csrapproving,-csrcleaner,-csrsigning,-daemonset,-deployment,-disruption,-endpoint,-garbagecollector,-horizontalpodautoscaling,-job,-namespace,-nodeipam,-nodelifecycle,-persistentvolume-binder,-persistentvolume-expander,-podgc,-pv-protection,-pvc-protection,-replicaset,-replicationcontroller,-resourcequota,-root-ca-cert-publisher,-serviceaccount,-serviceaccount-token,-statefulset,-ttl"
+
+// k3sDBDir is where k3s keeps its kine database inside the server container
+const k3sDBDir = "/var/lib/rancher/k3s/server/db/"
+
+// ClusterProvider : Creates and manages the cluster a bundle is replayed in
+type ClusterProvider interface {
+	// Name returns the name of the cluster
+	Name() string
+	// Create creates and starts the cluster with its database in resourceDir/db
+	Create(resourceDir string) error
+	Start() error
+	Stop() error
+	Delete() error
+	// Running reports whether the cluster's server container is running
+	Running() (bool, error)
+	// KubeconfigPath returns the path of the cluster's admin kubeconfig
+	KubeconfigPath() (string, error)
+}
+
+// k3dVersionPattern matches the k3d version in `k3d version` output
+var k3dVersionPattern = regexp.MustCompile(`k3d version v(\d+)\.(\d+)\.(\d+)`)
+
+// k3sVersionPattern matches the version of the k3s image k3d creates clusters with
+var k3sVersionPattern = regexp.MustCompile(`k3s version (v\d+\.\d+\.\d+\S*)`)
+
+// k3dMajorVersion, k3dVersion and k3dK3sVersion cache the result of detectK3dVersion
+var (
+	k3dMajorVersion int
+	k3dVersion      string
+	k3dK3sVersion   string
+)
+
+// detectK3dVersion returns the major version of the installed k3d binary
+func detectK3dVersion() (int, string, error) {
+	if k3dVersion != "" {
+		return k3dMajorVersion, k3dVersion, nil
+	}
+
+	out, err := exec.Command("k3d", "version").CombinedOutput()
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to run `k3d version`; is k3d installed and in your $PATH? %v", err)
+	}
+	m := k3dVersionPattern.FindStringSubmatch(string(out))
+	if m == nil {
+		return 0, "", fmt.Errorf("failed to parse k3d version from %q", strings.TrimSpace(string(out)))
+	}
+	k3dMajorVersion, _ = strconv.Atoi(m[1])
+	k3dVersion = strings.TrimPrefix(m[0], "k3d version ")
+	if m := k3sVersionPattern.FindStringSubmatch(string(out)); m != nil {
+		k3dK3sVersion = m[1]
+	}
+	return k3dMajorVersion, k3dVersion, nil
+}
+
+// detectK3sVersion returns the version of the k3s image the installed k3d creates clusters
+// with, or "" if k3d is not installed or does not report it
+func detectK3sVersion() string {
+	if _, _, err := detectK3dVersion(); err != nil {
+		return ""
+	}
+	return k3dK3sVersion
+}
+
+// newClusterProvider returns a provider for the installed k3d version
+func newClusterProvider(name string) (ClusterProvider, error) {
+	major, version, err := detectK3dVersion()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 1:
+		return &k3dV1{name: name}, nil
+	case 5:
+		return &k3dV5{name: name}, nil
+	}
+	return nil, fmt.Errorf("k3d %s is not supported; install k3d v1.7.x or v5.x", version)
+}
+
+// runK3d runs k3d with args, returning its output in the error if it fails
+func runK3d(args ...string) (string, error) {
+	out, err := exec.Command("k3d", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("`k3d %s` failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// containerRunning reports whether a docker container exists and is running
+func containerRunning(container string) (bool, error) {
+	out, err := exec.Command("docker", "inspect", "--format", "{{.State.Running}}", container).CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(out), "No such") {
+			return false, nil
+		}
+		return false, fmt.Errorf("`docker inspect %s` failed: %v: %s", container, err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)) == "true", nil
+}
+
+// k3dV1 : Cluster provider for the k3d 1.x CLI
+type k3dV1 struct {
+	name string
+}
+
+func (k *k3dV1) Name() string {
+	return k.name
+}
+
+func (k *k3dV1) Create(resourceDir string) error {
+	_, err := runK3d("create",
+		"--name", k.name,
+		"--workers", "0",
+		"--volume", resourceDir+"/db:"+k3sDBDir,
+		"--server-arg", "--disable-agent",
+		"--server-arg", "--no-deploy=coredns",
+		"--server-arg", "--no-deploy=servicelb",
+		"--server-arg", "--no-deploy=traefik",
+		"--server-arg", "--no-deploy=local-storage",
+		"--server-arg", "--no-deploy=metrics-server",
+		"--server-arg", "--kube-apiserver-arg=event-ttl=168h0m0s",
+		"--server-arg", readOnlyAPIServerArg,
+		"--server-arg", "--kube-controller-arg=disable-attach-detach-reconcile-sync",
+		"--server-arg", "--kube-controller-arg="+disabledControllers,
+		"--server-arg", "--disable-scheduler",
+		"--server-arg", "--disable-cloud-controller",
+		"--server-arg", "--disable-network-policy",
+		"--server-arg", "--no-flannel",
+		"--wait", "60",
+	)
+	return err
+}
+
+func (k *k3dV1) Start() error {
+	_, err := runK3d("start", "--name", k.name)
+	return err
+}
+
+func (k *k3dV1) Stop() error {
+	_, err := runK3d("stop", "--name", k.name)
+	return err
+}
+
+func (k *k3dV1) Delete() error {
+	_, err := runK3d("delete", "--name", k.name)
+	return err
+}
+
+func (k *k3dV1) Running() (bool, error) {
+	return containerRunning("k3d-" + k.name + "-server")
+}
+
+func (k *k3dV1) KubeconfigPath() (string, error) {
+	return runK3d("get-kubeconfig", "--name", k.name)
+}
+
+// k3dV5 : Cluster provider for the k3d 5.x CLI
+type k3dV5 struct {
+	name string
+}
+
+func (k *k3dV5) Name() string {
+	return k.name
+}
+
+func (k *k3dV5) Create(resourceDir string) error {
+	args := []string{"cluster", "create", k.name,
+		"--agents", "0",
+		"--no-lb",
+		"--volume", resourceDir + "/db:" + k3sDBDir + "@server:0",
+		"--kubeconfig-update-default=false",
+		"--kubeconfig-switch-context=false",
+		"--wait",
+		"--timeout", "60s",
+	}
+	for _, arg := range []string{
+		"--disable=coredns",
+		"--disable=servicelb",
+		"--disable=traefik",
+		"--disable=local-storage",
+		"--disable=metrics-server",
+		"--kube-apiserver-arg=event-ttl=168h0m0s",
+		readOnlyAPIServerArg,
+		"--kube-controller-manager-arg=disable-attach-detach-reconcile-sync",
+		"--kube-controller-manager-arg=" + disabledControllers,
+		"--disable-scheduler",
+		"--disable-cloud-controller",
+		"--disable-network-policy",
+		"--flannel-backend=none",
+	} {
+		args = append(args, "--k3s-arg", arg+"@server:0")
+	}
+	_, err := runK3d(args...)
+	return err
+}
+
+func (k *k3dV5) Start() error {
+	_, err := runK3d("cluster", "start", k.name, "--wait")
+	return err
+}
+
+func (k *k3dV5) Stop() error {
+	_, err := runK3d("cluster", "stop", k.name)
+	return err
+}
+
+func (k *k3dV5) Delete() error {
+	_, err := runK3d("cluster", "delete", k.name)
+	return err
+}
+
+func (k *k3dV5) Running() (bool, error) {
+	return containerRunning("k3d-" + k.name + "-server-0")
+}
+
+func (k *k3dV5) KubeconfigPath() (string, error) {
+	return runK3d("kubeconfig", "write", k.name)
+}
+
@@ -0,0 +1,238 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeCommands puts shell scripts, keyed by command name, first in $PATH and returns the dir
+// they are in; each script logs its arguments to <dir>/<name>.log. Call restore when done.
+func fakeCommands(t *testing.T, scripts map[string]string) (dir string, restore func()) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake commands are shell scripts")
+	}
+	dir, err := ioutil.TempDir("", "bunk-bin-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, script := range scripts {
+		log := filepath.Join(dir, name+".log")
+		content := fmt.Sprintf("#!/bin/sh\necho \"$@\" >> '%s'\n%s\n", log, script)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0755); err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	return dir, func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+// fakeCommandArgs returns the arguments of each run of a fake command, one run per line
+func fakeCommandArgs(t *testing.T, dir string, name string) []string {
+	t.Helper()
+	out, err := ioutil.ReadFile(filepath.Join(dir, name+".log"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+}
+
+// resetK3dVersion forgets the k3d version detectK3dVersion cached
+func resetK3dVersion() {
+	k3dMajorVersion, k3dVersion, k3dK3sVersion = 0, "", ""
+}
+
+func TestNewClusterProvider(t *testing.T) {
+	tests := []struct {
+		output  string
+		want    string
+		version string
+		k3s     string
+		err     bool
+	}{
+		{output: "k3d version v1.7.0", want: "*cmd.k3dV1", version: "v1.7.0"},
+		{output: "k3d version v5.4.6\nk3s version v1.24.4-k3s1 (default)", want: "*cmd.k3dV5", version: "v5.4.6", k3s: "v1.24.4-k3s1"},
+		{output: "k3d version v3.4.0\nk3s version v1.19.4-k3s1 (default)", version: "v3.4.0", k3s: "v1.19.4-k3s1", err: true},
+		{output: "k3d: command not found", err: true},
+	}
+
+	for _, test := range tests {
+		resetK3dVersion()
+		_, restore := fakeCommands(t, map[string]string{"k3d": fmt.Sprintf("printf '%%s\\n' '%s'", strings.Replace(test.output, "\n", "' '", -1))})
+		provider, err := newClusterProvider("bunk-12345-a")
+		_, version, _ := detectK3dVersion()
+		k3s := detectK3sVersion()
+		restore()
+
+		if test.err {
+			if err == nil {
+				t.Errorf("newClusterProvider with %q = %T; want an error", test.output, provider)
+			}
+		} else if err != nil || fmt.Sprintf("%T", provider) != test.want || provider.Name() != "bunk-12345-a" {
+			t.Errorf("newClusterProvider with %q = %T, %v; want %s", test.output, provider, err, test.want)
+		}
+		if version != test.version || k3s != test.k3s {
+			t.Errorf("k3d %q reported versions %q and %q; want %q and %q", test.output, version, k3s, test.version, test.k3s)
+		}
+	}
+	resetK3dVersion()
+
+	// The version is detected once
+	dir, restore := fakeCommands(t, map[string]string{"k3d": "echo k3d version v5.0.0"})
+	defer restore()
+	for i := 0; i < 2; i++ {
+		if _, err := newClusterProvider("bunk"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if runs := fakeCommandArgs(t, dir, "k3d"); len(runs) != 1 {
+		t.Errorf("k3d ran %d times; want once", len(runs))
+	}
+	resetK3dVersion()
+
+	// Without k3d in $PATH
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", "")
+	_, err := newClusterProvider("bunk")
+	os.Setenv("PATH", path)
+	if err == nil || !strings.Contains(err.Error(), "is k3d installed") {
+		t.Errorf("newClusterProvider without k3d = %v; want an error", err)
+	}
+}
+
+func TestK3dProviders(t *testing.T) {
+	dir, restore := fakeCommands(t, map[string]string{
+		"k3d": "[ \"$1\" = get-kubeconfig ] || [ \"$1\" = kubeconfig ] && echo /home/me/.config/k3d/kubeconfig.yaml\ntrue",
+		// Only the k3d v5 server container exists
+		"docker": "case \"$4\" in k3d-bunk-server-0) echo true ;; *) echo 'Error: No such object' >&2; exit 1 ;; esac",
+	})
+	defer restore()
+
+	tests := []struct {
+		provider ClusterProvider
+		create   string
+		commands []string
+		running  bool
+	}{
+		{
+			provider: &k3dV1{name: "bunk"},
+			create:   "create --name bunk --workers 0 --volume /bundle/.kbk/db:/var/lib/rancher/k3s/server/db/ --server-arg --disable-agent",
+			commands: []string{"start --name bunk", "stop --name bunk", "delete --name bunk", "get-kubeconfig --name bunk"},
+		},
+		{
+			provider: &k3dV5{name: "bunk"},
+			create:   "cluster create bunk --agents 0 --no-lb --volume /bundle/.kbk/db:/var/lib/rancher/k3s/server/db/@server:0",
+			commands: []string{"cluster start bunk --wait", "cluster stop bunk", "cluster delete bunk", "kubeconfig write bunk"},
+			running:  true,
+		},
+	}
+
+	for _, test := range tests {
+		os.Remove(filepath.Join(dir, "k3d.log"))
+		p := test.provider
+		if err := p.Create("/bundle/.kbk"); err != nil {
+			t.Fatalf("%T.Create: %v", p, err)
+		}
+		for _, run := range []func() error{p.Start, p.Stop, p.Delete} {
+			if err := run(); err != nil {
+				t.Errorf("%T: %v", p, err)
+			}
+		}
+		if path, err := p.KubeconfigPath(); err != nil || path != "/home/me/.config/k3d/kubeconfig.yaml" {
+			t.Errorf("%T.KubeconfigPath() = %q, %v", p, path, err)
+		}
+		if running, err := p.Running(); err != nil || running != test.running {
+			t.Errorf("%T.Running() = %v, %v; want %v", p, running, err, test.running)
+		}
+
+		runs := fakeCommandArgs(t, dir, "k3d")
+		if len(runs) != 5 || !strings.HasPrefix(runs[0], test.create) || fmt.Sprint(runs[1:]) != fmt.Sprint(test.commands) {
+			t.Errorf("%T ran k3d with %q; want %q, then %q", p, runs, test.create, test.commands)
+			continue
+		}
+		// Every controller that would modify the replayed resources is disabled
+		if !strings.Contains(runs[0], disabledControllers) || !strings.Contains(runs[0], readOnlyAPIServerArg) {
+			t.Errorf("%T created a cluster with %q", p, runs[0])
+		}
+	}
+}
+
+func TestRunK3dReportsFailures(t *testing.T) {
+	_, restore := fakeCommands(t, map[string]string{"k3d": "echo 'cluster bunk already exists' >&2; exit 1"})
+	defer restore()
+	_, err := runK3d("cluster", "create", "bunk")
+	if err == nil || !strings.Contains(err.Error(), "`k3d cluster create bunk` failed") || !strings.Contains(err.Error(), "cluster bunk already exists") {
+		t.Errorf("runK3d error = %v; want the command and its output", err)
+	}
+}
+
+// fakeClusterProvider : A ClusterProvider that records the calls made to it
+type fakeClusterProvider struct {
+	name    string
+	running bool
+	calls   []string
+}
+
+func (p *fakeClusterProvider) Name() string {
+	return p.name
+}
+
+func (p *fakeClusterProvider) Create(resourceDir string) error {
+	p.calls = append(p.calls, "create "+filepath.Base(resourceDir))
+	p.running = true
+	return nil
+}
+
+func (p *fakeClusterProvider) Start() error {
+	p.calls = append(p.calls, "start")
+	p.running = true
+	return nil
+}
+
+func (p *fakeClusterProvider) Stop() error {
+	p.calls = append(p.calls, "stop")
+	p.running = false
+	return nil
+}
+
+func (p *fakeClusterProvider) Delete() error {
+	p.calls = append(p.calls, "delete")
+	p.running = false
+	return nil
+}
+
+func (p *fakeClusterProvider) Running() (bool, error) {
+	return p.running, nil
+}
+
+func (p *fakeClusterProvider) KubeconfigPath() (string, error) {
+	return "/dev/null", nil
+}
@@ -19,9 +19,9 @@ import (
 	"log"
 	"os"
 	"os/exec"
-	"os/user"
-	"runtime"
+	"strings"
 
+	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 )
 
@@ -40,41 +40,50 @@ to quickly create a Cobra application.`,
 	},
 }
 
-func deleteKubernetesCluster() {
-	cmd := exec.Command("k3d",
-		"delete",
-		"--all",
-	)
-	err := cmd.Run()
-	if err != nil {
-		log.Printf("Failed to remove k3d cluster: %s\n", err)
+func deleteKubernetesCluster(provider ClusterProvider) {
+	if err := provider.Delete(); err != nil {
+		log.Printf("Failed to remove k3d cluster %s: %s\n", provider.Name(), err)
 	} else {
-		log.Printf("Successfully removed k3d cluster!\n")
+		log.Printf("Successfully removed k3d cluster %s!\n", provider.Name())
 	}
 }
 
+// cleanupImage is the image of the helper container that removes files the cluster left
+// owned by root in .kbk, so that bunk never needs root itself
+const cleanupImage = "busybox"
+
 func deleteResourceDir(resourceDir string) {
 	if _, err := os.Stat(resourceDir); os.IsNotExist(err) {
 		log.Printf("Failed to remove resource directory: %s\n", err)
 	} else {
-		whoami, err := user.Current()
-		if err != nil {
-			log.Fatalf("Failed to get current user: %s", err)
-		}
-
-		// Fix directory permissions on linux
-		hostOS := runtime.GOOS
-		if _, err := os.Stat(resourceDir + "/db"); !os.IsNotExist(err) && hostOS == "linux" {
-			cmd := exec.Command("/bin/sh", "-c", "sudo chown -R "+whoami.Username+" "+resourceDir+"/db")
-			if err := cmd.Run(); err != nil {
-				log.Fatalf("Failed to chown directory to user %s: %s", whoami.Username, err)
+		if err := os.RemoveAll(resourceDir); err != nil {
+			log.Printf("Removing files owned by the cluster with a %s container\n", cleanupImage)
+			cmd := exec.Command("docker", "run", "--rm", "--volume", resourceDir+":/kbk", cleanupImage, "sh", "-c", "rm -rf /kbk/* /kbk/.[!.]*")
+			if out, err := cmd.CombinedOutput(); err != nil {
+				log.Fatalf("Failed to remove resource directory %s: %s: %s", resourceDir, err, strings.TrimSpace(string(out)))
+			}
+			if err := os.RemoveAll(resourceDir); err != nil {
+				log.Fatal(err)
 			}
 		}
+		log.Printf("Successfully removed resource directory!\n")
+	}
+}
 
-		if err := os.RemoveAll(resourceDir); err != nil {
-			log.Fatal(err)
+// removeKubeconfigFromDefault removes the contexts bunk kubeconfig --merge added for the cluster, if any
+func removeKubeconfigFromDefault(name string) {
+	kubeconfigPath, err := homedir.Expand(defaultKubeconfig)
+	if err != nil {
+		log.Printf("Failed to find kubeconfig: %s\n", err)
+		return
+	}
+	for _, context := range []string{name, adminContextName(name)} {
+		removed, err := removeKubeconfigContext(kubeconfigPath, context)
+		if err != nil {
+			log.Printf("Failed to remove context %s from %s: %s\n", context, kubeconfigPath, err)
+		} else if removed {
+			log.Printf("Removed context %s from %s\n", context, kubeconfigPath)
 		}
-		log.Printf("Successfully removed resource directory!\n")
 	}
 }
 
@@ -82,8 +91,24 @@ func down() {
 	bundleRootDir := getBundleRootDir()
 	resourceDir := bundleRootDir + "/.kbk"
 
-	deleteKubernetesCluster()
+	name, state, err := bundleClusterState(bundleRootDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Without recorded state the bundle may only have been served by bunk up --mode=local,
+	// which needs no k3d to clean up after
+	provider, err := newClusterProvider(name)
+	switch {
+	case err == nil:
+		deleteKubernetesCluster(provider)
+	case state != nil:
+		log.Fatal(err)
+	default:
+		log.Printf("No k3d cluster recorded for the bundle and k3d is unavailable (%v); not removing a cluster\n", err)
+	}
 	deleteResourceDir(resourceDir)
+	removeKubeconfigFromDefault(name)
 }
 
 func init() {
@@ -18,53 +18,89 @@ package cmd
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"strings"
 
-	"github.com/mholt/archiver"
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // extractCmd represents the extract command
 var extractCmd = &cobra.Command{
 	Use:   "extract",
 	Short: "Extract a compressed bundle",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+	Long: `Extract a diagnostic bundle into <tickets dir>/<ticket>/bundle-<name>.
+
+The bundle may be a zip, rar or tar archive, optionally compressed with gzip, bzip2, xz, zstd,
+lz4 or snappy, or an already extracted directory. Its format is detected from its content, not
+its name. Archives nested inside it, including a bundle wrapped in another archive, are
+unpacked in place as well, in the same pass: nothing is decompressed to disk to be unpacked
+again. Up to --workers nested archives are unpacked concurrently, with their progress shown as
+they go.
+
+Entries that would be written outside the bundle dir, links pointing outside of it and special
+files are skipped and reported. Extraction is aborted if the bundle unpacks to more than
+--max-size bytes or --max-files files.
+
+The ticket is taken from --ticket, parsed from the file name with --ticket-from-name or the
+extract.ticketPattern config key, or else prompted for on a terminal. The tickets dir is
+~/Documents/logs/tickets unless set with the ticketsDir config key or BUNK_TICKETS_DIR.`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		// fmt.Println("extract called")
-		extractBundle(args)
+		ticket, _ := cmd.Flags().GetString("ticket")
+		dest, _ := cmd.Flags().GetString("dest")
+		name, _ := cmd.Flags().GetString("name")
+		ticketFromName, _ := cmd.Flags().GetBool("ticket-from-name")
+		extractBundle(args, extractOptions{Ticket: ticket, Dest: dest, Name: name, TicketFromName: ticketFromName})
 	},
 }
 
-func extractBundle(filename []string) {
-	homeDir, err := os.UserHomeDir()
+// defaultTicketPattern matches a ticket such as 12345 or SUP-12345 at the start of a bundle file name
+const defaultTicketPattern = `^(?:[A-Za-z]+-)?[0-9]+`
+
+// extractOptions : Flags controlling where `bunk extract` puts a bundle
+type extractOptions struct {
+	Ticket string
+	// Dest is the bundle dir to extract to, instead of <tickets dir>/<ticket>/bundle-<name>
+	Dest           string
+	Name           string
+	TicketFromName bool
+}
+
+// ticketFromName parses the ticket from a bundle file name with pattern, returning its first
+// capture group if it has one, or else the whole match
+func ticketFromName(filename string, pattern string) (string, error) {
+	re, err := regexp.Compile(pattern)
 	if err != nil {
-		log.Fatalf("Could not locate user's home dir: %s\n", err)
+		return "", fmt.Errorf("invalid ticket pattern %q: %v", pattern, err)
 	}
-	ticketsDir := os.Getenv("BUNK_TICKETS_DIR")
-	if ticketsDir == "" {
-		ticketsDir = homeDir + "/Documents/logs/tickets"
+	m := re.FindStringSubmatch(filename)
+	if m == nil {
+		return "", nil
 	}
-	// workDir, err := os.Getwd()
-	// if err != nil {
-	// 	log.Fatalf("Could not get work dir: %s\n", err)
-	// }
-	bundleFilename := strings.Join(filename, " ")
+	if re.NumSubexp() > 0 && m[1] != "" {
+		return m[1], nil
+	}
+	return m[0], nil
+}
 
-	// fmt.Println(bundleFilename)
-	// fmt.Println(homeDir)
-	// fmt.Println(workDir)
-	// fmt.Println(ticketsDir)
+// stdinIsTerminal reports whether someone could answer a prompt
+func stdinIsTerminal() bool {
+	return isatty.IsTerminal(os.Stdin.Fd()) || isatty.IsCygwinTerminal(os.Stdin.Fd())
+}
 
+// promptTicket asks for the ticket on stdin
+func promptTicket() string {
 	reader := bufio.NewReader(os.Stdin)
 	var ticket string
 	for {
@@ -83,127 +119,210 @@ func extractBundle(filename []string) {
 		ticket = text
 		break
 	}
+	return ticket
+}
 
-	bundleFilePath, bundleFilename := filepath.Split(bundleFilename)
-
-	f, err := os.Open(bundleFilePath + bundleFilename)
-	// f, err := os.OpenFile(bundleFilePath+bundleFilename, os.O_RDONLY, 0777)
-	if err != nil {
-		log.Fatalf("Could not open file %v: %v\n", bundleFilePath+bundleFilename, err)
+// bundleTicket returns the ticket to file a bundle under: from --ticket, parsed from its file
+// name, or, on a terminal only, as entered at a prompt
+func bundleTicket(bundleFilename string, options extractOptions) string {
+	if options.Ticket != "" {
+		return options.Ticket
 	}
-	defer f.Close()
 
-	contentType, err := GetFileContentType(f)
-	if err != nil {
-		log.Fatalf("Could not get content type for file %v: %v\n", bundleFilename, err)
+	pattern := viper.GetString("extract.ticketPattern")
+	if pattern == "" && options.TicketFromName {
+		pattern = defaultTicketPattern
+	}
+	if pattern != "" {
+		ticket, err := ticketFromName(bundleFilename, pattern)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if ticket != "" {
+			log.Printf("Using ticket %s from file name %s\n", ticket, bundleFilename)
+			return ticket
+		}
+		log.Printf("No ticket in file name %s matching %q\n", bundleFilename, pattern)
 	}
 
-	if contentType != "application/x-gzip" {
-		// fmt.Printf("File content type: %s\n", contentType)
-		// fmt.Println("Extracting bundle!")
-		log.Fatalf("File content type is %v; expected application/x-gzip\n", contentType)
+	if !stdinIsTerminal() {
+		log.Fatalf("No ticket for %s; pass --ticket, --ticket-from-name or --dest\n", bundleFilename)
 	}
+	return promptTicket()
+}
 
-	var bundleDir string
-	d := strings.Split(bundleFilename, ".")
-	if len(d) > 0 {
-		bundleDir = ticketsDir + "/" + ticket + "/bundle-" + d[0]
+// defaultBundleName names a bundle after its file name up to its first dot, without the ticket
+// it starts with, as that is already the parent dir, or a bundle- prefix, e.g. bundle-foo for
+// 12345_bundle-foo.tar.gz
+func defaultBundleName(bundleFilename string, ticket string) string {
+	name := strings.Split(bundleFilename, ".")[0]
+	if ticket != "" && len(name) > len(ticket) && strings.HasPrefix(name, ticket) && strings.ContainsRune("-_ ", rune(name[len(ticket)])) {
+		if trimmed := strings.TrimLeft(name[len(ticket):], "-_ "); trimmed != "" {
+			name = trimmed
+		}
 	}
+	if trimmed := strings.TrimPrefix(name, "bundle-"); trimmed != "" {
+		name = trimmed
+	}
+	return name
+}
 
-	// Check if bundle dir exists
-	// TODO: Revisit this... os.IsExist()
-	if _, err := os.Stat(bundleDir); err == nil {
-		log.Fatalf("Failed to create bundle dir %v: able to stat dir", bundleDir)
+// defaultBundleDir returns <tickets dir>/<ticket>/bundle-<name> for a bundle file. If the bundle
+// was already extracted by an earlier bunk, which named the dir bundle-<file name up to its
+// first dot>, that dir is returned instead, so that it is not extracted a second time.
+func defaultBundleDir(ticketsDir string, ticket string, bundleFilename string) string {
+	ticketDir := ticketsDir + "/" + ticket
+	legacyDir := ticketDir + "/bundle-" + strings.Split(bundleFilename, ".")[0]
+	if _, err := os.Stat(legacyDir); err == nil {
+		return legacyDir
 	}
+	return ticketDir + "/bundle-" + defaultBundleName(bundleFilename, ticket)
+}
 
-	if err := os.MkdirAll(bundleDir, 0755); err != nil {
-		log.Fatalf("Failed to create directory %v: %v\n", bundleDir, err)
+// extractLimitsFromConfig returns the limits set with --max-size, a Kubernetes quantity such as
+// 500Mi, and --max-files
+func extractLimitsFromConfig() (extractLimits, error) {
+	maxSize, err := parseQuantity(viper.GetString("extract.maxSize"))
+	if err != nil {
+		return extractLimits{}, err
 	}
+	return extractLimits{MaxBytes: int64(maxSize), MaxFiles: viper.GetInt("extract.maxFiles")}, nil
+}
 
-	fmt.Printf("Extracting %v to %v\n", bundleFilePath+bundleFilename, bundleDir)
+func extractBundle(filename []string, options extractOptions) {
+	bundleFilename := strings.Join(filename, " ")
+
+	bundleFilePath, bundleFilename := filepath.Split(bundleFilename)
 
-	if err := archiver.Unarchive(bundleFilePath+bundleFilename, bundleDir); err != nil {
-		log.Fatalf("Failed to unarchive %v: %v\n", f, err)
+	info, err := os.Stat(bundleFilePath + bundleFilename)
+	if err != nil {
+		log.Fatalf("Could not open file %v: %v\n", bundleFilePath+bundleFilename, err)
 	}
 
-	var files []string
-	if err := filepath.Walk(bundleDir, func(path string, info os.FileInfo, err error) error {
-		if !info.IsDir() {
-			f, err := os.Open(path)
+	var archive *bundleArchive
+	if !info.IsDir() {
+		archive, err = detectArchive(bundleFilePath + bundleFilename)
+		if err != nil {
+			log.Fatalf("Could not detect archive format of %v: %v\n", bundleFilename, err)
+		}
+		if archive == nil {
+			f, err := os.Open(bundleFilePath + bundleFilename)
 			if err != nil {
 				log.Fatalf("Could not open file %v: %v\n", bundleFilePath+bundleFilename, err)
 			}
 			defer f.Close()
 
-			fct, err := GetFileContentType(f)
+			contentType, err := GetFileContentType(f)
 			if err != nil {
-				log.Fatalf("Could not get %v content type: %v\n", path, err)
-			}
-
-			if fct == "application/x-gzip" {
-				files = append(files, path)
+				log.Fatalf("Could not get content type for file %v: %v\n", bundleFilename, err)
 			}
+			log.Fatalf("File content type is %v; expected a directory, or a zip, rar or tar archive, optionally compressed with gzip, bzip2, xz, zstd, lz4 or snappy\n", contentType)
 		}
-		return nil
-	}); err != nil {
-		panic(err)
 	}
 
-	for _, file := range files {
-		s := strings.Split(strings.TrimSuffix(strings.TrimSuffix(file, ".gz"), ".tar"), "/")
-		dirName := s[len(s)-1]
-
-		gzFile, err := os.Open(file)
-		if err != nil {
-			log.Fatalf("Failed to open file %v: %v\n", file, err)
+	bundleDir := options.Dest
+	if bundleDir == "" {
+		ticket := bundleTicket(bundleFilename, options)
+		if options.Name != "" {
+			bundleDir = getTicketsDir() + "/" + ticket + "/bundle-" + options.Name
+		} else {
+			bundleDir = defaultBundleDir(getTicketsDir(), ticket, bundleFilename)
 		}
-		defer gzFile.Close()
+	}
 
-		tarFile, err := os.OpenFile(strings.TrimSuffix(file, ".gz"), os.O_CREATE|os.O_RDWR, 0755)
-		if err != nil {
-			log.Fatalf("failed to open %v: %v\n", strings.TrimSuffix(file, ".gz"), err)
-		}
-		defer tarFile.Close()
+	// Check if bundle dir exists
+	// TODO: Revisit this... os.IsExist()
+	if _, err := os.Stat(bundleDir); err == nil {
+		log.Fatalf("Failed to create bundle dir %v: able to stat dir", bundleDir)
+	}
 
-		err = archiver.NewGz().Decompress(gzFile, tarFile)
-		if err != nil {
-			log.Fatalf("failed to decommpress archive: %v\n", err)
-		}
+	if err := os.MkdirAll(bundleDir, 0755); err != nil {
+		log.Fatalf("Failed to create directory %v: %v\n", bundleDir, err)
+	}
 
-		tar := archiver.Tar{
-			OverwriteExisting: true,
-			MkdirAll:          true,
-		}
+	fmt.Printf("Extracting %v to %v\n", bundleFilePath+bundleFilename, bundleDir)
 
-		if err := tar.Unarchive(strings.TrimSuffix(file, ".gz"), bundleDir+"/"+dirName); err != nil {
-			log.Fatalf("Failed to unarchive %v: %v\n", strings.TrimSuffix(file, ".gz"), err)
-		}
+	limits, err := extractLimitsFromConfig()
+	if err != nil {
+		log.Fatalf("Invalid max size: %v\n", err)
+	}
+	e := newExtractor(bundleDir, limits, viper.GetInt("extract.workers"))
 
-		if err := tar.Close(); err != nil {
-			log.Fatalf("Failed to close tar: %v", err)
-		}
+	// failExtract removes what was extracted of a bundle that failed to extract
+	failExtract := func(format string, v ...interface{}) {
+		printRejectedEntries(e.Rejected)
+		os.RemoveAll(bundleDir)
+		log.Fatalf(format, v...)
+	}
+
+	stopProgress := e.Progress.Run()
+	if archive == nil {
+		err = e.CopyDir(bundleFilePath + bundleFilename)
+	} else {
+		err = e.ExtractArchive(archive, bundleFilePath+bundleFilename)
+	}
+	stopProgress()
+	if err != nil {
+		failExtract("Failed to extract %v: %v\n", bundleFilePath+bundleFilename, err)
 	}
+	printRejectedEntries(e.Rejected)
 
-	if err := os.RemoveAll(bundleDir + "/bundles"); err != nil {
-		log.Fatalf("Failed to remove %v/bundles: %v\n", bundleDir, err)
+	manifest, err := e.Manifest(bundleFilePath + bundleFilename)
+	if err != nil {
+		log.Fatalf("Failed to list the files of bundle %v: %v\n", bundleDir, err)
+	}
+	if err := writeBundleManifest(bundleDir, manifest); err != nil {
+		log.Fatalf("Failed to write manifest: %v\n", err)
 	}
+	fmt.Printf("Recorded %d files in %v\n", len(manifest.Files), filepath.Join(bundleDir, manifestFile))
 
 	fmt.Printf("Extracted bundle to %v\n", bundleDir)
 }
 
+// printRejectedEntries reports the archive entries that were not extracted
+func printRejectedEntries(rejected []RejectedEntry) {
+	if len(rejected) == 0 {
+		return
+	}
+
+	yellow := color.New(color.FgYellow).SprintfFunc()
+	fmt.Println(yellow("Rejected %d archive entries:", len(rejected)))
+	rows := [][]string{}
+	for _, entry := range rejected {
+		rows = append(rows, []string{entry.Archive, entry.Name, entry.Reason})
+	}
+	table := newPlainTable([]string{"Archive", "Entry", "Reason"})
+	table.AppendBulk(rows)
+	table.Render()
+}
+
+// defaultTicketsDir is where bundles are extracted, one subdir per ticket, unless configured otherwise
+const defaultTicketsDir = "~/Documents/logs/tickets"
+
+// getTicketsDir returns the dir bundles are extracted into, one subdir per ticket, from the
+// ticketsDir config key or the BUNK_TICKETS_DIR env var
+func getTicketsDir() string {
+	ticketsDir, err := homedir.Expand(viper.GetString("ticketsDir"))
+	if err != nil {
+		log.Fatalf("Could not locate user's home dir: %s\n", err)
+	}
+	return ticketsDir
+}
+
 // GetFileContentType : Gets file content type
 func GetFileContentType(out *os.File) (string, error) {
 	// Only the first 512 bytes are used to sniff the content type.
 	buffer := make([]byte, 512)
 
-	_, err := out.Read(buffer)
-	if err != nil {
+	n, err := out.Read(buffer)
+	if err != nil && err != io.EOF {
 		return "", err
 	}
 
 	// Use the net/http package's handy DectectContentType function. Always returns a valid
 	// content-type by returning "application/octet-stream" if no others seemed to match.
-	contentType := http.DetectContentType(buffer)
+	// Only sniff what was read, as the zeroes after a short file would make it binary.
+	contentType := http.DetectContentType(buffer[:n])
 
 	return contentType, nil
 }
@@ -220,4 +339,19 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// extractCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	extractCmd.Flags().StringP("ticket", "t", "", "Ticket to file the bundle under, instead of prompting for it")
+	extractCmd.Flags().String("dest", "", "Dir to extract the bundle to, instead of <tickets dir>/<ticket>/bundle-<name>")
+	extractCmd.Flags().String("name", "", "Name of the bundle dir, bundle-<name>; defaults to the file name up to its first dot, without a leading ticket or bundle-")
+	extractCmd.Flags().Bool("ticket-from-name", false, "Parse the ticket from the start of the file name, e.g. 12345 or SUP-12345")
+	extractCmd.Flags().String("ticket-pattern", "", "Regex to parse the ticket from the file name with; its first group, if any, is the ticket")
+	viper.BindPFlag("extract.ticketPattern", extractCmd.Flags().Lookup("ticket-pattern"))
+	extractCmd.Flags().String("max-size", "20Gi", "Abort if the bundle unpacks to more than this many bytes, e.g. 500Mi or 20Gi")
+	viper.BindPFlag("extract.maxSize", extractCmd.Flags().Lookup("max-size"))
+	extractCmd.Flags().Int("max-files", 200000, "Abort if the bundle unpacks to more than this many files")
+	viper.BindPFlag("extract.maxFiles", extractCmd.Flags().Lookup("max-files"))
+	extractCmd.Flags().Int("workers", runtime.NumCPU(), "Number of nested archives to unpack concurrently")
+	viper.BindPFlag("extract.workers", extractCmd.Flags().Lookup("workers"))
+
+	viper.SetDefault("ticketsDir", defaultTicketsDir)
+	viper.BindEnv("ticketsDir", "BUNK_TICKETS_DIR")
 }
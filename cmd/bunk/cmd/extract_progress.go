@@ -0,0 +1,183 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattn/go-isatty"
+)
+
+// progressInterval is how often the progress of the archives being extracted is redrawn
+const progressInterval = 500 * time.Millisecond
+
+// progressWidth bounds the progress line so that it never wraps on a common terminal
+const progressWidth = 100
+
+// archiveProgress : How much of an archive has been extracted so far
+type archiveProgress struct {
+	// bytes and entries are updated atomically while the archive is extracted
+	bytes   int64
+	entries int64
+
+	Name    string
+	Started time.Time
+	// Parent is the archive this one is nested in, which its bytes count towards as well
+	Parent *archiveProgress
+}
+
+// extractProgress : Reports the progress of the archives being extracted, with a line that is
+// redrawn in place when stderr is a terminal and a summary once each archive is done
+type extractProgress struct {
+	mu       sync.Mutex
+	active   []*archiveProgress
+	terminal bool
+	drawn    bool
+}
+
+func newExtractProgress() *extractProgress {
+	return &extractProgress{
+		terminal: isatty.IsTerminal(os.Stderr.Fd()) || isatty.IsCygwinTerminal(os.Stderr.Fd()),
+	}
+}
+
+// formatBytes formats a byte count with a binary unit
+func formatBytes(n int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(n)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d %s", n, units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+// add counts n bytes extracted from the archive and the archives it is nested in
+func (a *archiveProgress) add(n int64) {
+	for ; a != nil; a = a.Parent {
+		atomic.AddInt64(&a.bytes, n)
+	}
+}
+
+// addEntry counts an entry read from the archive
+func (a *archiveProgress) addEntry() {
+	atomic.AddInt64(&a.entries, 1)
+}
+
+func (a *archiveProgress) String() string {
+	bytes := formatBytes(atomic.LoadInt64(&a.bytes))
+	// Compressed files that are no archives have no entries
+	if entries := atomic.LoadInt64(&a.entries); entries > 0 {
+		return fmt.Sprintf("%s: %d entries, %s", a.Name, entries, bytes)
+	}
+	return fmt.Sprintf("%s: %s", a.Name, bytes)
+}
+
+// start starts tracking the archive called name, nested in parent if that is not nil
+func (p *extractProgress) start(name string, parent *archiveProgress) *archiveProgress {
+	a := &archiveProgress{Name: name, Started: time.Now(), Parent: parent}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active = append(p.active, a)
+	return a
+}
+
+// finish stops tracking an archive and reports what was extracted of it, unless extracting it
+// failed with err
+func (p *extractProgress) finish(a *archiveProgress, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, active := range p.active {
+		if active == a {
+			p.active = append(p.active[:i], p.active[i+1:]...)
+			break
+		}
+	}
+	p.clear()
+	if err != nil {
+		return
+	}
+	log.Printf("Unpacked %s in %s\n", a, time.Since(a.Started).Round(time.Millisecond))
+}
+
+// clear erases the progress line; p.mu must be held
+func (p *extractProgress) clear() {
+	if p.drawn {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		p.drawn = false
+	}
+}
+
+// draw redraws the progress line with the archives being extracted
+func (p *extractProgress) draw() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	if len(p.active) == 0 {
+		return
+	}
+
+	parts := []string{}
+	for _, a := range p.active {
+		parts = append(parts, a.String())
+	}
+	line := "Unpacking " + strings.Join(parts, " | ")
+	if len(line) > progressWidth {
+		line = line[:progressWidth-3] + "..."
+	}
+	fmt.Fprint(os.Stderr, line)
+	p.drawn = true
+}
+
+// Run redraws the progress line until the returned stop func is called
+func (p *extractProgress) Run() func() {
+	if !p.terminal {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				p.mu.Lock()
+				p.clear()
+				p.mu.Unlock()
+				return
+			case <-ticker.C:
+				p.draw()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFormatBytes(t *testing.T) {
+	for _, test := range []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+		{2 << 50, "2048.0 TiB"},
+	} {
+		if got := formatBytes(test.n); got != test.want {
+			t.Errorf("formatBytes(%d) = %q; want %q", test.n, got, test.want)
+		}
+	}
+}
+
+func TestExtractProgress(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	p := &extractProgress{}
+	bundle := p.start("bundle.tar.gz", nil)
+	node := p.start("bundle.tar.gz/bundles/node1.tar.gz", bundle)
+	logs := p.start("bundle.tar.gz/bundles/node1.tar.gz/logs.gz", node)
+
+	// Bytes count towards every archive they are nested in; entries only to their own
+	bundle.addEntry()
+	bundle.add(100)
+	node.addEntry()
+	node.addEntry()
+	node.add(2048)
+	logs.add(10)
+	for _, test := range []struct {
+		archive *archiveProgress
+		want    string
+	}{
+		{bundle, "bundle.tar.gz: 1 entries, 2.1 KiB"},
+		{node, "bundle.tar.gz/bundles/node1.tar.gz: 2 entries, 2.0 KiB"},
+		// Compressed files that are no archives have no entries
+		{logs, "bundle.tar.gz/bundles/node1.tar.gz/logs.gz: 10 B"},
+	} {
+		if got := test.archive.String(); got != test.want {
+			t.Errorf("progress = %q; want %q", got, test.want)
+		}
+	}
+
+	// Finished archives are reported, unless extracting them failed
+	p.finish(logs, nil)
+	p.finish(node, errors.New("unexpected EOF"))
+	if len(p.active) != 1 || p.active[0] != bundle {
+		t.Errorf("active archives = %v; want only bundle.tar.gz", p.active)
+	}
+	if out := logged.String(); !strings.Contains(out, "Unpacked bundle.tar.gz/bundles/node1.tar.gz/logs.gz: 10 B in ") || strings.Contains(out, "node1.tar.gz:") {
+		t.Errorf("logged %q; want only logs.gz reported", out)
+	}
+
+	// Nothing is drawn when stderr is not a terminal
+	stop := p.Run()
+	stop()
+	if p.drawn {
+		t.Errorf("progress was drawn without a terminal")
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestTicketFromName(t *testing.T) {
+	tests := []struct {
+		filename string
+		pattern  string
+		want     string
+	}{
+		{filename: "12345_bundle.tar.gz", pattern: defaultTicketPattern, want: "12345"},
+		{filename: "SUP-12345-bundle-prod.tar.gz", pattern: defaultTicketPattern, want: "SUP-12345"},
+		{filename: "prod-bundle-12345.tar.gz", pattern: defaultTicketPattern, want: ""},
+		{filename: "bundle.tar.gz", pattern: defaultTicketPattern, want: ""},
+		// The first group, if any, is the ticket
+		{filename: "case-00789-diag.zip", pattern: `^case-(\d+)`, want: "00789"},
+		{filename: "diag-for-00789.zip", pattern: `(\d+)\.zip$`, want: "00789"},
+		{filename: "diag.zip", pattern: `^case-(\d+)`, want: ""},
+	}
+	for _, test := range tests {
+		got, err := ticketFromName(test.filename, test.pattern)
+		if err != nil || got != test.want {
+			t.Errorf("ticketFromName(%q, %q) = %q, %v; want %q", test.filename, test.pattern, got, err, test.want)
+		}
+	}
+	if _, err := ticketFromName("12345.tar", "("); err == nil {
+		t.Errorf("ticketFromName accepted the pattern (")
+	}
+}
+
+func TestBundleTicket(t *testing.T) {
+	defer viper.Set("extract.ticketPattern", "")
+
+	tests := []struct {
+		filename string
+		options  extractOptions
+		pattern  string
+		want     string
+	}{
+		// --ticket wins over any pattern
+		{filename: "12345_bundle.tar.gz", options: extractOptions{Ticket: "999", TicketFromName: true}, want: "999"},
+		{filename: "12345_bundle.tar.gz", options: extractOptions{TicketFromName: true}, want: "12345"},
+		// A configured pattern applies without --ticket-from-name, and replaces the default
+		{filename: "case-42_bundle.tar.gz", pattern: `^case-(\d+)`, want: "42"},
+		{filename: "case-42_bundle.tar.gz", options: extractOptions{TicketFromName: true}, pattern: `^case-(\d+)`, want: "42"},
+	}
+	for _, test := range tests {
+		viper.Set("extract.ticketPattern", test.pattern)
+		if got := bundleTicket(test.filename, test.options); got != test.want {
+			t.Errorf("bundleTicket(%q, %+v) with pattern %q = %q; want %q", test.filename, test.options, test.pattern, got, test.want)
+		}
+	}
+}
+
+func TestDefaultBundleName(t *testing.T) {
+	tests := []struct {
+		filename string
+		ticket   string
+		want     string
+	}{
+		{filename: "12345_bundle-prod.tar.gz", ticket: "12345", want: "prod"},
+		{filename: "12345-diag.tar.gz", ticket: "12345", want: "diag"},
+		{filename: "12345 diag.zip", ticket: "12345", want: "diag"},
+		{filename: "bundle-prod.tar.gz", ticket: "12345", want: "prod"},
+		{filename: "diag.tar.gz", ticket: "12345", want: "diag"},
+		// Only a whole ticket followed by a separator is dropped
+		{filename: "12345-bundle.tar.gz", ticket: "123", want: "12345-bundle"},
+		{filename: "12345.tar.gz", ticket: "12345", want: "12345"},
+		{filename: "12345_.tar.gz", ticket: "12345", want: "12345_"},
+		{filename: "bundle-.tar", ticket: "1", want: "bundle-"},
+	}
+	for _, test := range tests {
+		if got := defaultBundleName(test.filename, test.ticket); got != test.want {
+			t.Errorf("defaultBundleName(%q, %q) = %q; want %q", test.filename, test.ticket, got, test.want)
+		}
+	}
+}
+
+func TestDefaultBundleDirKeepsEarlierName(t *testing.T) {
+	ticketsDir, err := ioutil.TempDir("", "bunk-tickets-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(ticketsDir)
+
+	want := ticketsDir + "/12345/bundle-prod"
+	if got := defaultBundleDir(ticketsDir, "12345", "12345_bundle-prod.tar.gz"); got != want {
+		t.Errorf("defaultBundleDir = %s; want %s", got, want)
+	}
+
+	// Extracted before bundles were named without their ticket
+	legacyDir := filepath.Join(ticketsDir, "12345", "bundle-12345_bundle-prod")
+	if err := os.MkdirAll(legacyDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if got := defaultBundleDir(ticketsDir, "12345", "12345_bundle-prod.tar.gz"); got != filepath.ToSlash(legacyDir) {
+		t.Errorf("defaultBundleDir with an existing %s = %s", legacyDir, got)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// kineBaseID is the first row id used for bundle resources; rows k3s writes itself are numbered after them
+const kineBaseID = 5000
+
+// kineInsertStatement inserts a single live object into the kine table
+const kineInsertStatement = "INSERT INTO kine(id, name, created, deleted, create_revision, prev_revision, lease, value, old_value) " +
+	"VALUES(?, ?, 1, 0, ?, ?, 0, ?, ?)"
+
+// kineSchema creates the kine table and indexes as k3s's sqlite backend does, so that the
+// database can be loaded before k3s first opens it
+var kineSchema = []string{
+	`CREATE TABLE IF NOT EXISTS kine (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name INTEGER,
+		created INTEGER,
+		deleted INTEGER,
+		create_revision INTEGER,
+		prev_revision INTEGER,
+		lease INTEGER,
+		value BLOB,
+		old_value BLOB
+	)`,
+	"CREATE INDEX IF NOT EXISTS kine_name_index ON kine (name)",
+	"CREATE INDEX IF NOT EXISTS kine_name_id_index ON kine (name,id)",
+	"CREATE INDEX IF NOT EXISTS kine_id_deleted_index ON kine (id,deleted)",
+	"CREATE INDEX IF NOT EXISTS kine_prev_revision_index ON kine (prev_revision)",
+	"CREATE UNIQUE INDEX IF NOT EXISTS kine_name_prev_revision_uindex ON kine (name, prev_revision)",
+}
+
+// kineDatabaseFiles are the files of the kine database in a db dir, relative to it
+var kineDatabaseFiles = []string{"state.db", "state.db-shm", "state.db-wal"}
+
+// KineRow : A Kubernetes object to be stored in the kine database under its registry key
+type KineRow struct {
+	Key   string
+	Value []byte
+	// File and Object identify where the row came from when reporting failures
+	File   string
+	Object string
+}
+
+// KineRowError : A row that could not be inserted and why
+type KineRowError struct {
+	Row KineRow
+	Err error
+}
+
+// kineRowID returns the id of the i-th bundle row; each row reserves ids for its revisions
+func kineRowID(i int) int {
+	// TODO: I think 3 is sufficient -- need to test this
+	return kineBaseID + i*4
+}
+
+// insertKineRows inserts rows through a prepared statement in a single transaction, replacing
+// any rows loaded before so that an interrupted load can be retried.
+// Rows that fail are returned alongside the error so that the rest are still loaded.
+func insertKineRows(database *sql.DB, rows []KineRow) (int, []KineRowError, error) {
+	tx, err := database.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM kine WHERE id >= ?", kineBaseID); err != nil {
+		tx.Rollback()
+		return 0, nil, err
+	}
+
+	stmt, err := tx.Prepare(kineInsertStatement)
+	if err != nil {
+		tx.Rollback()
+		return 0, nil, err
+	}
+	defer stmt.Close()
+
+	inserted := 0
+	var failures []KineRowError
+	for i, row := range rows {
+		id := kineRowID(i)
+		if _, err := stmt.Exec(id, row.Key, id+1, id+2, row.Value, row.Value); err != nil {
+			failures = append(failures, KineRowError{Row: row, Err: err})
+			continue
+		}
+		inserted++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, nil, err
+	}
+	return inserted, failures, nil
+}
+
+// seedKineDatabase creates the kine database at path if needed and inserts rows into it
+func seedKineDatabase(path string, rows []KineRow) (int, []KineRowError, error) {
+	database, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer database.Close()
+
+	for _, statement := range kineSchema {
+		if _, err := database.Exec(statement); err != nil {
+			return 0, nil, err
+		}
+	}
+	return insertKineRows(database, rows)
+}
+
+// sqlQuote quotes s as a SQL string literal
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// writeKineSQL writes rows as the SQL statements insertKineRows would execute, for debugging.
+// Values are written as blob literals so any object content survives intact.
+func writeKineSQL(path string, rows []KineRow) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0664)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for i, row := range rows {
+		id := kineRowID(i)
+		value := "X'" + hex.EncodeToString(row.Value) + "'"
+		fmt.Fprintf(w, "-- %s from %s\n", row.Object, row.File)
+		fmt.Fprintf(w, "INSERT INTO kine(id, name, created, deleted, create_revision, prev_revision, lease, value, old_value) "+
+			"VALUES(%d, %s, 1, 0, %d, %d, 0, %s, %s);\n", id, sqlQuote(row.Key), id+1, id+2, value, value)
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
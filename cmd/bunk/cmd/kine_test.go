@@ -0,0 +1,201 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openTestKineDatabase creates an empty kine database in a temp dir and returns it and the dir
+func openTestKineDatabase(t *testing.T) (*sql.DB, string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "bunk-kine-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	database, err := sql.Open("sqlite3", filepath.Join(dir, "state.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	for _, statement := range kineSchema {
+		if _, err := database.Exec(statement); err != nil {
+			database.Close()
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+	return database, dir
+}
+
+// readKineRows returns the id, name, revisions and value of each row of the kine table, in id order
+func readKineRows(t *testing.T, database *sql.DB) []string {
+	t.Helper()
+	result, err := database.Query("SELECT id, name, created, deleted, create_revision, prev_revision, lease, value, old_value FROM kine ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer result.Close()
+
+	var rows []string
+	for result.Next() {
+		var id, created, deleted, createRevision, prevRevision, lease int
+		var name string
+		var value, oldValue []byte
+		if err := result.Scan(&id, &name, &created, &deleted, &createRevision, &prevRevision, &lease, &value, &oldValue); err != nil {
+			t.Fatal(err)
+		}
+		if string(oldValue) != string(value) {
+			t.Errorf("row %d has old_value %q; want its value %q", id, oldValue, value)
+		}
+		rows = append(rows, fmt.Sprintf("%d %s %d %d %d %d %d %s", id, name, created, deleted, createRevision, prevRevision, lease, value))
+	}
+	if err := result.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+var testKineRows = []KineRow{
+	{Key: "/registry/minions/node-1", Value: []byte(`{"kind":"Node"}`), File: "nodes.yaml", Object: "node/node-1"},
+	{Key: "/registry/pods/default/it's-quoted", Value: []byte("{\"kind\":\"Pod\",\"note\":\"'\\u0000\"}\x00"), File: "pods.yaml", Object: "pod/default/it's-quoted"},
+	{Key: "/registry/pods/default/web", Value: []byte(`{"kind":"Pod"}`), File: "pods.yaml", Object: "pod/default/web"},
+}
+
+func TestInsertKineRows(t *testing.T) {
+	database, dir := openTestKineDatabase(t)
+	defer os.RemoveAll(dir)
+	defer database.Close()
+
+	// A row k3s wrote itself, which loading resources again must keep
+	if _, err := database.Exec("INSERT INTO kine(id, name, created, deleted, create_revision, prev_revision, lease, value, old_value) VALUES(1, 'compact_rev_key', 1, 0, 0, 0, 0, '', '')"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Loading twice replaces the rows of the first load
+	for i := 0; i < 2; i++ {
+		inserted, failures, err := insertKineRows(database, testKineRows)
+		if err != nil || inserted != 3 || len(failures) != 0 {
+			t.Fatalf("insertKineRows = %d, %v, %v; want 3 rows inserted", inserted, failures, err)
+		}
+	}
+	want := []string{
+		"1 compact_rev_key 1 0 0 0 0 ",
+		fmt.Sprintf("5000 /registry/minions/node-1 1 0 5001 5002 0 %s", testKineRows[0].Value),
+		fmt.Sprintf("5004 /registry/pods/default/it's-quoted 1 0 5005 5006 0 %s", testKineRows[1].Value),
+		fmt.Sprintf("5008 /registry/pods/default/web 1 0 5009 5010 0 %s", testKineRows[2].Value),
+	}
+	if got := readKineRows(t, database); fmt.Sprintf("%q", got) != fmt.Sprintf("%q", want) {
+		t.Errorf("kine rows = %q; want %q", got, want)
+	}
+}
+
+func TestInsertKineRowsReportsFailures(t *testing.T) {
+	database, dir := openTestKineDatabase(t)
+	defer os.RemoveAll(dir)
+	defer database.Close()
+
+	if _, err := database.Exec(`CREATE TRIGGER reject_web BEFORE INSERT ON kine WHEN NEW.name = '/registry/pods/default/web'
+		BEGIN SELECT RAISE(ABORT, 'rejected'); END`); err != nil {
+		t.Fatal(err)
+	}
+	inserted, failures, err := insertKineRows(database, testKineRows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserted != 2 || len(failures) != 1 || failures[0].Row.Object != "pod/default/web" || failures[0].Err == nil {
+		t.Fatalf("insertKineRows = %d, %v; want pod/default/web to fail and the rest to be inserted", inserted, failures)
+	}
+	if got := readKineRows(t, database); len(got) != 2 {
+		t.Errorf("kine rows = %q; want the two rows that did not fail", got)
+	}
+}
+
+func TestWriteKineSQL(t *testing.T) {
+	database, dir := openTestKineDatabase(t)
+	defer os.RemoveAll(dir)
+	defer database.Close()
+
+	path := filepath.Join(dir, "resources.sql")
+	if err := writeKineSQL(path, testKineRows); err != nil {
+		t.Fatal(err)
+	}
+	statements, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The debugging SQL loads the same rows insertKineRows does
+	if _, err := database.Exec(string(statements)); err != nil {
+		t.Fatalf("executing %s: %v", statements, err)
+	}
+	written := readKineRows(t, database)
+	if _, _, err := insertKineRows(database, testKineRows); err != nil {
+		t.Fatal(err)
+	}
+	if inserted := readKineRows(t, database); fmt.Sprintf("%q", written) != fmt.Sprintf("%q", inserted) {
+		t.Errorf("rows from %s = %q; want %q", filepath.Base(path), written, inserted)
+	}
+}
+
+func TestSeedKineDatabase(t *testing.T) {
+	resourceDir, err := ioutil.TempDir("", "bunk-kbk-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(resourceDir)
+	if err := os.Mkdir(filepath.Join(resourceDir, "db"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(resourceDir, "db", "state.db")
+
+	// The database is created with k3s's schema, then seeded again when up is resumed
+	for i := 0; i < 2; i++ {
+		inserted, failures, err := seedKineDatabase(path, testKineRows)
+		if err != nil || inserted != 3 || len(failures) != 0 {
+			t.Fatalf("seedKineDatabase = %d, %v, %v; want 3 rows inserted", inserted, failures, err)
+		}
+	}
+	database, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readKineRows(t, database); len(got) != 3 {
+		t.Errorf("seeded kine rows = %q; want 3", got)
+	}
+	var indexes int
+	if err := database.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'index' AND tbl_name = 'kine' AND name LIKE 'kine_%'").Scan(&indexes); err != nil || indexes != len(kineSchema)-1 {
+		t.Errorf("seeded database has %d kine indexes, %v; want %d", indexes, err, len(kineSchema)-1)
+	}
+	database.Close()
+
+	// The database belongs to the current user, who can remove it without sudo
+	provider := &fakeClusterProvider{name: "bunk", running: true}
+	resetKineDatabase(provider, resourceDir)
+	if fmt.Sprint(provider.calls) != "[delete]" {
+		t.Errorf("resetKineDatabase called %v; want the cluster deleted", provider.calls)
+	}
+	for _, file := range kineDatabaseFiles {
+		if _, err := os.Stat(filepath.Join(resourceDir, "db", file)); !os.IsNotExist(err) {
+			t.Errorf("%s still exists after resetKineDatabase: %v", file, err)
+		}
+	}
+}
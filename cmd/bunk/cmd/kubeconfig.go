@@ -17,25 +17,300 @@ package cmd
 
 import (
 	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
 
+	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
 )
 
+// defaultKubeconfig is the kubeconfig that --merge adds the bundle's context to
+const defaultKubeconfig = "~/.kube/config"
+
 // kubeconfigCmd represents the kubeconfig command
 var kubeconfigCmd = &cobra.Command{
 	Use:   "kubeconfig",
 	Short: "Display the kubeconfig file location for a kbk cluster",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
+	Long: `Display the kubeconfig file location for the replay cluster of the bundle in the current directory.
+
+  bunk kubeconfig            print the path of the cluster's kubeconfig
+  bunk kubeconfig --export   print an export line, for eval "$(bunk kubeconfig --export)"
+  bunk kubeconfig --merge    add a context named after the ticket and bundle to ~/.kube/config
+  bunk kubeconfig --remove   remove that context from ~/.kube/config again
 
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+The kubeconfig is for a view-only user, so that the replayed resources cannot be modified by
+accident. Pass --admin for the cluster's admin kubeconfig instead; with --merge, its context is
+named with an -admin suffix.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("kubeconfig called")
+		export, _ := cmd.Flags().GetBool("export")
+		merge, _ := cmd.Flags().GetBool("merge")
+		remove, _ := cmd.Flags().GetBool("remove")
+		admin, _ := cmd.Flags().GetBool("admin")
+		if (export && merge) || (export && remove) || (merge && remove) {
+			log.Fatalf("--export, --merge and --remove cannot be combined\n")
+		}
+		kubeconfig(export, merge, remove, admin)
 	},
 }
 
+// kubeconfigNamedLists are the kubeconfig lists whose entries are keyed by name
+var kubeconfigNamedLists = []string{"clusters", "contexts", "users"}
+
+// adminContextName returns the name --merge gives the admin context of the cluster called name
+func adminContextName(name string) string {
+	return name + "-admin"
+}
+
+// bundleKubeconfig returns the context name and kubeconfig path for the bundle's cluster,
+// writing the view-only kubeconfig to .kbk unless admin is set
+func bundleKubeconfig(bundleRootDir string, admin bool) (string, string) {
+	name, state, err := bundleClusterState(bundleRootDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if state == nil {
+		// bunk up --mode=local keeps no state, only a kubeconfig while it runs, and needs no k3d
+		localKubeconfigPath := bundleRootDir + "/.kbk/kubeconfig-local.yaml"
+		if _, err := os.Stat(localKubeconfigPath); err == nil {
+			return name, localKubeconfigPath
+		}
+		log.Fatalf("No bunk cluster found for bundle %s; create one with `bunk up`\n", bundleRootDir)
+	}
+
+	provider, err := newClusterProvider(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	kubeconfigPath, err := provider.KubeconfigPath()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if admin {
+		return adminContextName(provider.Name()), kubeconfigPath
+	}
+
+	resourceDir := bundleRootDir + "/.kbk"
+	token, err := readReadOnlyToken(resourceDir)
+	if err != nil {
+		log.Fatalf("Cluster %s has no read-only user (%v); recreate it with `bunk down && bunk up` or pass --admin\n", provider.Name(), err)
+	}
+	readOnlyKubeconfigPath := resourceDir + "/kubeconfig-readonly.yaml"
+	if err := writeReadOnlyKubeconfig(kubeconfigPath, readOnlyKubeconfigPath, token); err != nil {
+		log.Fatalf("Failed to write read-only kubeconfig: %v\n", err)
+	}
+	return provider.Name(), readOnlyKubeconfigPath
+}
+
+// readKubeconfig reads a kubeconfig as a generic map so that merging preserves fields bunk
+// does not know about; a missing file reads as an empty kubeconfig
+func readKubeconfig(path string) (map[string]interface{}, error) {
+	config := map[string]interface{}{}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig %s: %v", path, err)
+	}
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	return config, nil
+}
+
+// writeKubeconfig replaces the kubeconfig at path, so an interrupted write never truncates it
+func writeKubeconfig(path string, config map[string]interface{}) error {
+	content, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".bunk-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// kubeconfigEntries returns the named entries of a kubeconfig list
+func kubeconfigEntries(config map[string]interface{}, list string) []interface{} {
+	entries, _ := config[list].([]interface{})
+	return entries
+}
+
+// kubeconfigEntryName returns the name of a kubeconfig list entry
+func kubeconfigEntryName(entry interface{}) string {
+	if fields, ok := entry.(map[string]interface{}); ok {
+		name, _ := fields["name"].(string)
+		return name
+	}
+	return ""
+}
+
+// removeKubeconfigEntries removes the cluster, context and user called name from config,
+// returning whether anything was removed
+func removeKubeconfigEntries(config map[string]interface{}, name string) bool {
+	removed := false
+	for _, list := range kubeconfigNamedLists {
+		kept := []interface{}{}
+		for _, entry := range kubeconfigEntries(config, list) {
+			if kubeconfigEntryName(entry) == name {
+				removed = true
+				continue
+			}
+			kept = append(kept, entry)
+		}
+		if _, ok := config[list]; ok {
+			config[list] = kept
+		}
+	}
+	if config["current-context"] == name {
+		config["current-context"] = ""
+		removed = true
+	}
+	return removed
+}
+
+// mergeKubeconfig adds the current context of the kubeconfig at sourcePath to the kubeconfig
+// at targetPath, with its cluster, context and user all renamed to name
+func mergeKubeconfig(sourcePath string, targetPath string, name string) error {
+	source, err := readKubeconfig(sourcePath)
+	if err != nil {
+		return err
+	}
+
+	currentContext, _ := source["current-context"].(string)
+	var context map[string]interface{}
+	for _, entry := range kubeconfigEntries(source, "contexts") {
+		if kubeconfigEntryName(entry) == currentContext {
+			fields, _ := entry.(map[string]interface{})
+			context, _ = fields["context"].(map[string]interface{})
+		}
+	}
+	if context == nil {
+		return fmt.Errorf("kubeconfig %s has no current context", sourcePath)
+	}
+
+	renamed := map[string]interface{}{}
+	for list, field := range map[string]string{"clusters": "cluster", "users": "user"} {
+		for _, entry := range kubeconfigEntries(source, list) {
+			fields, _ := entry.(map[string]interface{})
+			if fields != nil && kubeconfigEntryName(entry) == context[field] {
+				renamed[list] = map[string]interface{}{"name": name, field: fields[field]}
+			}
+		}
+		if renamed[list] == nil {
+			return fmt.Errorf("kubeconfig %s has no %s %v", sourcePath, field, context[field])
+		}
+	}
+	contextFields := map[string]interface{}{}
+	for key, value := range context {
+		contextFields[key] = value
+	}
+	contextFields["cluster"] = name
+	contextFields["user"] = name
+	renamed["contexts"] = map[string]interface{}{"name": name, "context": contextFields}
+
+	target, err := readKubeconfig(targetPath)
+	if err != nil {
+		return err
+	}
+	removeKubeconfigEntries(target, name)
+	if target["apiVersion"] == nil {
+		target["apiVersion"] = "v1"
+		target["kind"] = "Config"
+	}
+	for _, list := range kubeconfigNamedLists {
+		target[list] = append(kubeconfigEntries(target, list), renamed[list])
+	}
+	return writeKubeconfig(targetPath, target)
+}
+
+// removeKubeconfigContext removes the context called name, with its cluster and user, from the
+// kubeconfig at path, returning whether it was there
+func removeKubeconfigContext(path string, name string) (bool, error) {
+	config, err := readKubeconfig(path)
+	if err != nil {
+		return false, err
+	}
+	if !removeKubeconfigEntries(config, name) {
+		return false, nil
+	}
+	return true, writeKubeconfig(path, config)
+}
+
+// shellQuote quotes value for a POSIX shell
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'"'"'`, -1) + "'"
+}
+
+func kubeconfig(export bool, merge bool, remove bool, admin bool) {
+	bundleRootDir := getBundleRootDir()
+
+	targetPath, err := homedir.Expand(defaultKubeconfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if remove {
+		name, _, err := bundleClusterState(bundleRootDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if admin {
+			name = adminContextName(name)
+		}
+		removed, err := removeKubeconfigContext(targetPath, name)
+		if err != nil {
+			log.Fatalf("Failed to remove context %s from %s: %v\n", name, targetPath, err)
+		}
+		if removed {
+			log.Printf("Removed context %s from %s\n", name, targetPath)
+		} else {
+			log.Printf("No context %s in %s\n", name, targetPath)
+		}
+		return
+	}
+
+	name, kubeconfigPath := bundleKubeconfig(bundleRootDir, admin)
+
+	if merge {
+		if err := mergeKubeconfig(kubeconfigPath, targetPath, name); err != nil {
+			log.Fatalf("Failed to merge kubeconfig into %s: %v\n", targetPath, err)
+		}
+		log.Printf("Added context %s to %s. Please access the cluster with:\nkubectl --context %s\n", name, targetPath, name)
+		return
+	}
+
+	if export {
+		fmt.Printf("export KUBECONFIG=%s\n", shellQuote(kubeconfigPath))
+		return
+	}
+	fmt.Println(kubeconfigPath)
+}
+
 func init() {
 	rootCmd.AddCommand(kubeconfigCmd)
 
@@ -48,4 +323,8 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// kubeconfigCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	kubeconfigCmd.Flags().Bool("export", false, "Print an export KUBECONFIG=... line for eval")
+	kubeconfigCmd.Flags().Bool("merge", false, "Add a context for the cluster to "+defaultKubeconfig)
+	kubeconfigCmd.Flags().Bool("remove", false, "Remove the cluster's context from "+defaultKubeconfig)
+	kubeconfigCmd.Flags().Bool("admin", false, "Use the cluster's admin kubeconfig instead of the view-only one")
 }
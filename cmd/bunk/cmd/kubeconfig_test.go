@@ -0,0 +1,160 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testUserKubeconfig = `apiVersion: v1
+kind: Config
+current-context: prod
+preferences:
+  colors: true
+clusters:
+- name: prod
+  cluster:
+    server: https://prod.example.com:6443
+contexts:
+- name: prod
+  context:
+    cluster: prod
+    user: prod-admin
+    namespace: payments
+users:
+- name: prod-admin
+  user:
+    token: prod-token
+`
+
+const testClusterKubeconfig = `apiVersion: v1
+kind: Config
+current-context: k3d-bunk
+clusters:
+- name: k3d-bunk
+  cluster:
+    server: https://0.0.0.0:6443
+contexts:
+- name: k3d-bunk
+  context:
+    cluster: k3d-bunk
+    user: admin@k3d-bunk
+users:
+- name: admin@k3d-bunk
+  user:
+    token: replay-token
+`
+
+func TestMergeAndRemoveKubeconfig(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "bunk-kubeconfig-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	sourcePath := filepath.Join(tmp, "kubeconfig.yaml")
+	targetPath := filepath.Join(tmp, ".kube", "config")
+	if err := ioutil.WriteFile(sourcePath, []byte(testClusterKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(targetPath, []byte(testUserKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	original, err := readKubeconfig(targetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Merging twice replaces the entries of the first merge
+	for i := 0; i < 2; i++ {
+		if err := mergeKubeconfig(sourcePath, targetPath, "bunk-12345-a"); err != nil {
+			t.Fatalf("mergeKubeconfig: %v", err)
+		}
+	}
+	merged, err := readKubeconfig(targetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, list := range kubeconfigNamedLists {
+		entries := kubeconfigEntries(merged, list)
+		if len(entries) != 2 || !reflect.DeepEqual(entries[0], kubeconfigEntries(original, list)[0]) || kubeconfigEntryName(entries[1]) != "bunk-12345-a" {
+			t.Errorf("merged %s = %v; want the original entry, then bunk-12345-a", list, entries)
+		}
+	}
+	context := kubeconfigEntries(merged, "contexts")[1].(map[string]interface{})["context"].(map[string]interface{})
+	if context["cluster"] != "bunk-12345-a" || context["user"] != "bunk-12345-a" {
+		t.Errorf("merged context = %v; want its cluster and user renamed to bunk-12345-a", context)
+	}
+	user := kubeconfigEntries(merged, "users")[1].(map[string]interface{})["user"].(map[string]interface{})
+	if user["token"] != "replay-token" {
+		t.Errorf("merged user = %v; want the replay cluster's token", user)
+	}
+	if merged["current-context"] != "prod" || !reflect.DeepEqual(merged["preferences"], original["preferences"]) {
+		t.Errorf("merge changed current-context to %v or preferences to %v", merged["current-context"], merged["preferences"])
+	}
+	if info, err := os.Stat(targetPath); err != nil {
+		t.Error(err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Errorf("merged kubeconfig has mode %v; want 0600", info.Mode().Perm())
+	}
+
+	removed, err := removeKubeconfigContext(targetPath, "bunk-12345-a")
+	if err != nil || !removed {
+		t.Fatalf("removeKubeconfigContext = %v, %v; want true", removed, err)
+	}
+	restored, err := readKubeconfig(targetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored, original) {
+		t.Errorf("kubeconfig after merge and remove = %v; want %v", restored, original)
+	}
+
+	if removed, err := removeKubeconfigContext(targetPath, "bunk-12345-a"); err != nil || removed {
+		t.Errorf("removing a missing context = %v, %v; want false", removed, err)
+	}
+}
+
+func TestMergeKubeconfigIntoMissingFile(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "bunk-kubeconfig-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	sourcePath := filepath.Join(tmp, "kubeconfig.yaml")
+	targetPath := filepath.Join(tmp, ".kube", "config")
+	if err := ioutil.WriteFile(sourcePath, []byte(testClusterKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := mergeKubeconfig(sourcePath, targetPath, "bunk-12345-a"); err != nil {
+		t.Fatalf("mergeKubeconfig: %v", err)
+	}
+	merged, err := readKubeconfig(targetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged["apiVersion"] != "v1" || merged["kind"] != "Config" || len(kubeconfigEntries(merged, "contexts")) != 1 {
+		t.Errorf("kubeconfig merged into a missing file = %v", merged)
+	}
+}
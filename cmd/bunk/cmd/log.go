@@ -16,45 +16,79 @@ limitations under the License.
 package cmd
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 
-	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 )
 
+// maxLogLineSize bounds the length of a single log line bunk reads
+const maxLogLineSize = 64 * 1024 * 1024
+
 // logCmd represents the log command
 var logCmd = &cobra.Command{
 	Use:   "log",
-	Short: "Display the logs for a pod",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
+	Short: "List, view and search the pod logs of a bundle",
+	Long: `List, view and search the container logs in the pods_logs dir of the bundle in the current
+directory. Log files are named <namespace>_<pod>[_<container>].log.
+
+  bunk log ls                      list the log files, with their sizes
+  bunk log view <ns> <pod>         open a pod's log in $PAGER
+  bunk log tail <ns> <pod> -n 50   print the last lines of a pod's log
+  bunk log grep <regex>            search all the logs, with file and line context
+  bunk log view <ns> <pod> --level error -o pretty
+                                   show the errors of a klog, logfmt or JSON log readably
 
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+Without a subcommand, bunk log lists the log files, and bunk log <ns> <pod> views a pod's log.`,
 	Aliases: []string{"logs"},
 	Args:    cobra.MaximumNArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
-		// fmt.Println("log called")
-
-		bundleRootDir := getBundleRootDir()
-		podLogsDir := getPodLogsDir(bundleRootDir)
-
-		// TODO: Make this a true cobra nested subcommand instead
-		if len(args) == 0 || args[0] == "ls" || args[0] == "list" {
-			listPodLogs(podLogsDir)
-		} else {
-			viewPodLog(args, podLogsDir)
+		switch len(args) {
+		case 0:
+			listPodLogs(podLogFilter{})
+		case 2:
+			viewPodLog(args[0], args[1], "", &logRenderer{Output: logOutputRaw, Levels: &logLevelFilter{Rank: -1}})
+		default:
+			log.Fatalf("Expected a namespace and a pod, or a subcommand; see `bunk log --help`\n")
 		}
 	},
 }
 
+// logLsCmd represents the log ls command
+var logLsCmd = &cobra.Command{
+	Use:     "ls",
+	Aliases: []string{"list"},
+	Short:   "List the pod log files of the bundle, with their sizes",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		namespace, _ := cmd.Flags().GetString("namespace")
+		name, _ := cmd.Flags().GetString("name")
+		container, _ := cmd.Flags().GetString("container")
+		listPodLogs(podLogFilter{Namespace: namespace, Name: name, Container: container})
+	},
+}
+
 func getPodLogsDir(bundleRootDir string) string {
+	podLogsDir := findPodLogsDir(bundleRootDir)
+	if podLogsDir == "" {
+		log.Fatalf("Failed to find pod logs dir within bundle directory: %s\n", bundleRootDir)
+	}
+
+	return podLogsDir
+}
+
+// findPodLogsDir returns the pods_logs dir within the bundle, or "" if there is none
+func findPodLogsDir(bundleRootDir string) string {
+	if manifest, err := readBundleManifest(bundleRootDir); err == nil && manifest != nil {
+		return manifestRoleDir(bundleRootDir, manifest, rolePodLogs)
+	}
+
 	var podLogsDir string
 
 	err := filepath.Walk(bundleRootDir, func(path string, info os.FileInfo, err error) error {
@@ -71,107 +105,166 @@ func getPodLogsDir(bundleRootDir string) string {
 		log.Fatalf("Error walking the path %q: %v\n", bundleRootDir, err)
 	}
 
-	if podLogsDir == "" {
-		log.Fatalf("Failed to find pod logs dir within bundle directory: %s\n", bundleRootDir)
-	}
-
 	return podLogsDir
 }
 
-func listPodLogs(podLogsDir string) {
-	var files []string
-	// Find yaml files in directory -- Maybe clean this up a bit in the future
-	err := filepath.Walk(podLogsDir, func(path string, info os.FileInfo, err error) error {
-		if filepath.Ext(path) == ".log" {
-			files = append(files, path)
+// PodLogFile : A container log file within the bundle's pods_logs dir
+type PodLogFile struct {
+	Path      string
+	Namespace string
+	Pod       string
+	// Container is empty if the file name does not include one
+	Container string
+}
+
+// Name returns the namespace, pod and container of the log as <namespace>/<pod>[/<container>]
+func (f PodLogFile) Name() string {
+	if f.Container == "" {
+		return f.Namespace + "/" + f.Pod
+	}
+	return f.Namespace + "/" + f.Pod + "/" + f.Container
+}
+
+// findPodLogFiles returns every .log file in the pods_logs dir, parsed from
+// file names of the form <namespace>_<pod>[_<container>].log
+func findPodLogFiles(podLogsDir string) []PodLogFile {
+	var paths []string
+	if manifest, bundleRootDir := findBundleManifest(podLogsDir); manifest != nil {
+		paths = manifestFilesIn(bundleRootDir, manifest, podLogsDir, ".log")
+	} else {
+		err := filepath.Walk(podLogsDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if filepath.Ext(path) == ".log" {
+				paths = append(paths, path)
+			}
 			return nil
+		})
+		if err != nil {
+			log.Fatal(err)
 		}
-		return nil
-	})
-	if err != nil {
-		log.Fatal(err)
 	}
 
-	podList := [][]string{}
-	for _, file := range files {
-		podMetadata := strings.Split(strings.TrimSuffix(file[strings.LastIndex(file, "/")+1:], ".log"), "_")
-		podNamespace := podMetadata[0]
-		podName := podMetadata[1]
-		podList = append(podList, []string{podNamespace, podName})
+	var logFiles []PodLogFile
+	for _, path := range paths {
+		podMetadata := strings.Split(strings.TrimSuffix(filepath.Base(path), ".log"), "_")
+		if len(podMetadata) < 2 {
+			continue
+		}
+		logFile := PodLogFile{
+			Path:      path,
+			Namespace: podMetadata[0],
+			Pod:       podMetadata[1],
+		}
+		if len(podMetadata) > 2 {
+			logFile.Container = strings.Join(podMetadata[2:], "_")
+		}
+		logFiles = append(logFiles, logFile)
+	}
 
-		// fmt.Printf("Pod log file name: %v\n", file)
-		// fmt.Printf("Pod namespace: %v\n", podNamespace)
-		// fmt.Printf("Pod name: %v\n", podName)
+	return logFiles
+}
 
-	}
+// podLogFilter : Which pod log files a log subcommand looks at; empty fields match anything
+type podLogFilter struct {
+	Namespace string
+	// Name is a glob, such as web-*
+	Name      string
+	Container string
+}
 
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Namespace", "Name"})
-	table.SetAutoWrapText(false)
-	table.SetAutoFormatHeaders(true)
-	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
-	table.SetAlignment(tablewriter.ALIGN_LEFT)
-	table.SetCenterSeparator("")
-	table.SetColumnSeparator("")
-	table.SetRowSeparator("")
-	table.SetHeaderLine(false)
-	table.SetBorder(false)
-	table.SetTablePadding("\t") // pad with tabs
-	table.SetNoWhiteSpace(true)
-	table.AppendBulk(podList) // Add Bulk Data
-	table.Render()
+func (f podLogFilter) matches(logFile PodLogFile) bool {
+	if f.Namespace != "" && logFile.Namespace != f.Namespace {
+		return false
+	}
+	if f.Name != "" {
+		if ok, err := filepath.Match(f.Name, logFile.Pod); err != nil || !ok {
+			return false
+		}
+	}
+	return f.Container == "" || logFile.Container == f.Container
 }
 
-func viewPodLog(args []string, podLogsDir string) {
-	var files []string
-	// Find yaml files in directory -- Maybe clean this up a bit in the future
-	err := filepath.Walk(podLogsDir, func(path string, info os.FileInfo, err error) error {
-		if filepath.Ext(path) == ".log" {
-			files = append(files, path)
-			return nil
+// bundlePodLogFiles returns the log files of the bundle in the current directory that filter
+// matches, sorted by name
+func bundlePodLogFiles(filter podLogFilter) []PodLogFile {
+	if _, err := filepath.Match(filter.Name, ""); err != nil {
+		log.Fatalf("Invalid pod name pattern %q: %v\n", filter.Name, err)
+	}
+
+	var logFiles []PodLogFile
+	for _, logFile := range findPodLogFiles(getPodLogsDir(getBundleRootDir())) {
+		if filter.matches(logFile) {
+			logFiles = append(logFiles, logFile)
 		}
-		return nil
-	})
-	if err != nil {
-		log.Fatal(err)
 	}
+	sort.Slice(logFiles, func(i, j int) bool { return logFiles[i].Name() < logFiles[j].Name() })
+	return logFiles
+}
+
+// findPodLog returns the log file of a pod's container; container may be empty if the pod
+// has a single log file
+func findPodLog(namespace string, pod string, container string) PodLogFile {
+	logFiles := bundlePodLogFiles(podLogFilter{Namespace: namespace})
 
-	var podLogFile string
-	for _, file := range files {
-		filePodMetadata := strings.Split(strings.TrimSuffix(file[strings.LastIndex(file, "/")+1:], ".log"), "_")
-		// podNamespace := filePodMetadata[0]
-		// podName := filePodMetadata[1]
-
-		if args[0] == filePodMetadata[0] && args[1] == filePodMetadata[1] {
-			// fmt.Printf("Pod match found!\n")
-			// fmt.Printf("Pod log file name: %v\n", file)
-			// fmt.Printf("Pod namespace: %v\n", filePodMetadata[0])
-			// fmt.Printf("Pod name: %v\n", filePodMetadata[1])
-			podLogFile = file
+	var matches []PodLogFile
+	for _, logFile := range logFiles {
+		if logFile.Pod != pod {
+			continue
+		}
+		if container == "" || logFile.Container == container {
+			matches = append(matches, logFile)
 		}
 	}
 
-	if podLogFile != "" {
-		pager := os.Getenv("PAGER")
-		if pager == "" {
-			pager = "less"
+	switch {
+	case len(matches) == 1:
+		return matches[0]
+	case len(matches) > 1:
+		var containers []string
+		for _, logFile := range matches {
+			containers = append(containers, logFile.Container)
 		}
+		log.Fatalf("Pod %s in namespace %s has logs for containers %s; pick one with -c\n", pod, namespace, strings.Join(containers, ", "))
+	case container != "":
+		log.Fatalf("Could not find log file for container %s of pod %s in namespace %s\n", container, pod, namespace)
+	default:
+		log.Fatalf("Could not find log file for pod %s in namespace %s\n", pod, namespace)
+	}
+	return PodLogFile{}
+}
 
-		cmd := exec.Command(pager, podLogFile)
-		cmd.Stdout = os.Stdout
+// newLogScanner returns a scanner over the lines of a log, allowing for very long lines
+func newLogScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLogLineSize)
+	return scanner
+}
 
-		fmt.Printf("Opening pod log file: %v", podLogFile)
-		err := cmd.Run()
-		if err != nil {
-			log.Fatalf("Could not open %v in `%v`: %v", podLogFile, pager, err)
+func listPodLogs(filter podLogFilter) {
+	logFiles := bundlePodLogFiles(filter)
+
+	podList := [][]string{}
+	var total int64
+	for _, logFile := range logFiles {
+		var size int64
+		if info, err := os.Stat(logFile.Path); err == nil {
+			size = info.Size()
 		}
-	} else {
-		log.Fatalf("Could not find log file for %v pod in %v namespace.", args[1], args[0])
+		total += size
+		podList = append(podList, []string{logFile.Namespace, logFile.Pod, logFile.Container, formatBytes(size)})
 	}
+
+	table := newPlainTable([]string{"Namespace", "Name", "Container", "Size"})
+	table.AppendBulk(podList) // Add Bulk Data
+	table.Render()
+	fmt.Printf("\n%d log files, %s\n", len(logFiles), formatBytes(total))
 }
 
 func init() {
 	rootCmd.AddCommand(logCmd)
+	logCmd.AddCommand(logLsCmd)
 
 	// Here you will define your flags and configuration settings.
 
@@ -182,4 +275,7 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// logCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	logLsCmd.Flags().StringP("namespace", "n", "", "Only list the logs of pods in this namespace")
+	logLsCmd.Flags().String("name", "", "Only list the logs of pods whose name matches this glob, e.g. web-*")
+	logLsCmd.Flags().StringP("container", "c", "", "Only list the logs of this container")
 }
@@ -0,0 +1,181 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// logGrepCmd represents the log grep command
+var logGrepCmd = &cobra.Command{
+	Use:   "grep <regex>",
+	Short: "Search the pod logs of the bundle",
+	Long: `Search every log file under pods_logs for lines matching a regular expression, printing them
+grep style as <file>:<line>:<text>, with -A, -B or -C lines of context around them. Exits with 1
+if no line matches.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ignoreCase, _ := cmd.Flags().GetBool("ignore-case")
+		context, _ := cmd.Flags().GetInt("context")
+		before, _ := cmd.Flags().GetInt("before-context")
+		after, _ := cmd.Flags().GetInt("after-context")
+		if !cmd.Flags().Changed("before-context") {
+			before = context
+		}
+		if !cmd.Flags().Changed("after-context") {
+			after = context
+		}
+		if before < 0 || after < 0 {
+			log.Fatalf("Context must not be negative\n")
+		}
+
+		expr := args[0]
+		if ignoreCase {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			log.Fatalf("Invalid regex %q: %v\n", args[0], err)
+		}
+
+		namespace, _ := cmd.Flags().GetString("namespace")
+		name, _ := cmd.Flags().GetString("name")
+		container, _ := cmd.Flags().GetString("container")
+		matched := grepPodLogs(os.Stdout, re, bundlePodLogFiles(podLogFilter{Namespace: namespace, Name: name, Container: container}), before, after)
+		if !matched {
+			os.Exit(1)
+		}
+	},
+}
+
+// logLine : A numbered line of a log file
+type logLine struct {
+	Number int
+	Text   string
+}
+
+// logGrepPrinter : Prints matches grep style to out, separating groups of lines that are not
+// adjacent with -- if context was asked for
+type logGrepPrinter struct {
+	out      io.Writer
+	re       *regexp.Regexp
+	separate bool
+	printed  bool
+	// last is the number of the last line printed of the current file
+	last int
+}
+
+var (
+	grepFileColor  = color.New(color.FgMagenta)
+	grepLineColor  = color.New(color.FgGreen)
+	grepMatchColor = color.New(color.FgRed, color.Bold)
+)
+
+// print prints a line of file; match tells if it matched or is context
+func (p *logGrepPrinter) print(file string, line logLine, match bool) {
+	if p.separate && p.printed && line.Number != p.last+1 {
+		fmt.Fprintln(p.out, "--")
+	}
+	p.printed = true
+	p.last = line.Number
+
+	separator := "-"
+	text := line.Text
+	if match {
+		separator = ":"
+		text = p.re.ReplaceAllStringFunc(text, func(s string) string { return grepMatchColor.Sprint(s) })
+	}
+	fmt.Fprintf(p.out, "%s%s%s%s%s\n", grepFileColor.Sprint(file), separator, grepLineColor.Sprint(line.Number), separator, text)
+}
+
+// grepPodLog prints the lines of a log file that match, with before and after lines of context,
+// returning whether any did
+func (p *logGrepPrinter) grepPodLog(path string, file string, before int, after int) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	p.last = -1
+	matched := false
+	// previous holds up to before lines preceding the current one that were not printed
+	var previous []logLine
+	afterLeft := 0
+	scanner := newLogScanner(f)
+	for number := 1; scanner.Scan(); number++ {
+		line := logLine{Number: number, Text: scanner.Text()}
+		switch {
+		case p.re.MatchString(line.Text):
+			matched = true
+			for _, context := range previous {
+				p.print(file, context, false)
+			}
+			previous = previous[:0]
+			p.print(file, line, true)
+			afterLeft = after
+		case afterLeft > 0:
+			p.print(file, line, false)
+			afterLeft--
+		case before > 0:
+			if len(previous) == before {
+				previous = previous[1:]
+			}
+			previous = append(previous, line)
+		}
+	}
+	return matched, scanner.Err()
+}
+
+// grepPodLogs searches logFiles for re, printing the lines found to out, returning whether any
+// line matched
+func grepPodLogs(out io.Writer, re *regexp.Regexp, logFiles []PodLogFile, before int, after int) bool {
+	bundleRootDir := getBundleRootDir()
+	printer := &logGrepPrinter{out: out, re: re, separate: before > 0 || after > 0}
+	matched := false
+	for _, logFile := range logFiles {
+		file, err := filepath.Rel(bundleRootDir, logFile.Path)
+		if err != nil {
+			file = logFile.Path
+		}
+		fileMatched, err := printer.grepPodLog(logFile.Path, file, before, after)
+		if err != nil {
+			log.Fatalf("Failed to search %v: %v\n", logFile.Path, err)
+		}
+		matched = matched || fileMatched
+	}
+	return matched
+}
+
+func init() {
+	logCmd.AddCommand(logGrepCmd)
+
+	logGrepCmd.Flags().BoolP("ignore-case", "i", false, "Match case insensitively")
+	logGrepCmd.Flags().IntP("context", "C", 0, "Lines of context to print around matches")
+	logGrepCmd.Flags().IntP("before-context", "B", 0, "Lines of context to print before matches")
+	logGrepCmd.Flags().IntP("after-context", "A", 0, "Lines of context to print after matches")
+	logGrepCmd.Flags().StringP("namespace", "n", "", "Only search the logs of pods in this namespace")
+	logGrepCmd.Flags().String("name", "", "Only search the logs of pods whose name matches this glob, e.g. web-*")
+	logGrepCmd.Flags().StringP("container", "c", "", "Only search the logs of this container")
+}
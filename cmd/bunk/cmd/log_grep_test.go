@@ -0,0 +1,101 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+)
+
+func TestGrepPodLogs(t *testing.T) {
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+	color.NoColor = true
+
+	lines := []string{"start", "ERROR a", "ERROR b", "ok", "ok", "ok", "ok", "ERROR c", "ok", "end"}
+	bundleRootDir := writeTestBundle(t, map[string]string{
+		"pods_logs/default_web_app.log":   strings.Join(lines, "\n") + "\n",
+		"pods_logs/default_web_proxy.log": "ok\nERROR d\n",
+		"pods_logs/default_db_db.log":     "ok\n",
+	})
+	defer os.RemoveAll(bundleRootDir)
+	defer os.Setenv("BUNK_BUNDLE_DIR", os.Getenv("BUNK_BUNDLE_DIR"))
+	os.Setenv("BUNK_BUNDLE_DIR", bundleRootDir)
+
+	logFiles := []PodLogFile{
+		{Path: filepath.Join(bundleRootDir, "pods_logs", "default_web_app.log")},
+		{Path: filepath.Join(bundleRootDir, "pods_logs", "default_web_proxy.log")},
+	}
+	app, proxy := "pods_logs/default_web_app.log", "pods_logs/default_web_proxy.log"
+
+	tests := []struct {
+		before int
+		after  int
+		want   []string
+	}{
+		{
+			// Like grep, groups are only separated when context is printed
+			want: []string{app + ":2:ERROR a", app + ":3:ERROR b", app + ":8:ERROR c", proxy + ":2:ERROR d"},
+		},
+		{
+			before: 1,
+			want: []string{
+				app + "-1-start", app + ":2:ERROR a", app + ":3:ERROR b", "--",
+				app + "-7-ok", app + ":8:ERROR c", "--",
+				proxy + "-1-ok", proxy + ":2:ERROR d",
+			},
+		},
+		{
+			after: 1,
+			want: []string{
+				app + ":2:ERROR a", app + ":3:ERROR b", app + "-4-ok", "--",
+				app + ":8:ERROR c", app + "-9-ok", "--",
+				proxy + ":2:ERROR d",
+			},
+		},
+		{
+			// Context that meets or overlaps is printed once, without a separator
+			before: 2,
+			after:  2,
+			want: []string{
+				app + "-1-start", app + ":2:ERROR a", app + ":3:ERROR b", app + "-4-ok", app + "-5-ok",
+				app + "-6-ok", app + "-7-ok", app + ":8:ERROR c", app + "-9-ok", app + "-10-end", "--",
+				proxy + "-1-ok", proxy + ":2:ERROR d",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+		if !grepPodLogs(&out, regexp.MustCompile("ERROR"), logFiles, test.before, test.after) {
+			t.Errorf("-B %d -A %d matched nothing", test.before, test.after)
+		}
+		got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("-B %d -A %d printed\n%s\nwant\n%s", test.before, test.after, strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+		}
+	}
+
+	var out bytes.Buffer
+	if grepPodLogs(&out, regexp.MustCompile("ERROR"), []PodLogFile{{Path: filepath.Join(bundleRootDir, "pods_logs", "default_db_db.log")}}, 1, 1) || out.Len() != 0 {
+		t.Errorf("grep of a log without errors matched, printing %q", out.String())
+	}
+}
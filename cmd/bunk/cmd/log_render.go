@@ -0,0 +1,135 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// Output formats of bunk log view and tail
+const (
+	logOutputRaw    = "raw"
+	logOutputPretty = "pretty"
+	logOutputJSONL  = "jsonl"
+)
+
+// logLevelColors color the levels of pretty printed log records
+var logLevelColors = map[string]*color.Color{
+	"debug":   color.New(color.Faint),
+	"info":    color.New(color.FgGreen),
+	"warning": color.New(color.FgYellow),
+	"error":   color.New(color.FgRed),
+	"fatal":   color.New(color.FgRed, color.Bold),
+}
+
+var (
+	logSourceColor = color.New(color.FgCyan)
+	logFieldsColor = color.New(color.Faint)
+)
+
+// logRenderer : Parses the lines of a log, selects them by level and formats them for output
+type logRenderer struct {
+	File   PodLogFile
+	Output string
+	Parser logParser
+	Levels *logLevelFilter
+	// Emit is called with each line of output, without its newline
+	Emit func(string)
+
+	// pending is the JSON record that continuation lines are still being added to
+	pending *logRecordJSON
+}
+
+// validLogOutput reports whether output is a format a logRenderer knows
+func validLogOutput(output string) bool {
+	return output == logOutputRaw || output == logOutputPretty || output == logOutputJSONL
+}
+
+// Line renders the line numbered number of the log; number is 0 if it is not known
+func (r *logRenderer) Line(line []byte, number int) {
+	if r.Output == logOutputRaw && !r.Levels.Enabled() {
+		r.Emit(string(line))
+		return
+	}
+
+	if r.Output == logOutputRaw {
+		if r.Levels.Keep(r.Parser.ParseLevel(line)) {
+			r.Emit(string(line))
+		}
+		return
+	}
+	record := r.Parser.Parse(line)
+	if !r.Levels.Keep(record) {
+		return
+	}
+
+	switch r.Output {
+	case logOutputPretty:
+		r.Emit(prettyLogRecord(record, line))
+	case logOutputJSONL:
+		// Continuation lines, such as stack traces, belong to the message of the record before
+		if record.Continuation && r.pending != nil {
+			r.pending.Message += "\n" + string(line)
+			return
+		}
+		r.Flush()
+		out := record.JSON(r.File.Name(), number)
+		r.pending = &out
+	}
+}
+
+// Flush emits the record continuation lines were being added to, if any
+func (r *logRenderer) Flush() {
+	if r.pending == nil {
+		return
+	}
+	encoded, err := json.Marshal(r.pending)
+	if err != nil {
+		encoded, _ = json.Marshal(logRecordJSON{Log: r.pending.Log, Line: r.pending.Line, Message: r.pending.Message, Format: r.pending.Format})
+	}
+	r.Emit(string(encoded))
+	r.pending = nil
+}
+
+// prettyLogRecord formats a record as its time, level, source, message and fields; plain text
+// lines are readable as they are
+func prettyLogRecord(record LogRecord, line []byte) string {
+	if record.Format == logFormatText {
+		return string(line)
+	}
+
+	parts := []string{}
+	if !record.Time.IsZero() {
+		parts = append(parts, timelineTimeColor.Sprint(record.Time.UTC().Format(timelineTimeLayout)))
+	}
+	level := fmt.Sprintf("%-7s", strings.ToUpper(record.Level))
+	if c, ok := logLevelColors[record.Level]; ok {
+		level = c.Sprint(level)
+	}
+	parts = append(parts, level)
+	if record.Source != "" {
+		parts = append(parts, logSourceColor.Sprint(record.Source))
+	}
+	parts = append(parts, record.Message)
+	if fields := record.fieldsString(); fields != "" {
+		parts = append(parts, logFieldsColor.Sprint(fields))
+	}
+	return strings.Join(parts, "  ")
+}
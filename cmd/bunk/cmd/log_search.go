@@ -0,0 +1,476 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"regexp/syntax"
+	"runtime"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// logSearchCmd represents the log search command
+var logSearchCmd = &cobra.Command{
+	Use:   "search <regex>",
+	Short: "Search the pod logs of the bundle in parallel, by pod and time",
+	Long: `Search every log file under pods_logs for lines matching a regular expression, reading the
+files in parallel, and print the matches grouped by pod and container.
+
+--since and --until only keep lines logged within that time, going by the timestamps of klog,
+RFC 3339, JSON (ts, time or timestamp fields) and logfmt lines. klog timestamps have no year;
+they are taken to be of the year the bundle was collected, or else of --until or --since. Lines
+without a timestamp, such as stack traces, take the timestamp of the line before them. Times
+are in UTC unless they have a zone, e.g. 2020-09-18T14:03:00Z, 2020-09-18 14:03 or 2020-09-18.
+
+--level only searches lines of that level or above, going by the levels of klog, logfmt and JSON
+lines and the level words of plain text lines, and the lines continuing them.
+
+Exits with 1 if no line matches.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ignoreCase, _ := cmd.Flags().GetBool("ignore-case")
+		invert, _ := cmd.Flags().GetBool("invert-match")
+		since, _ := cmd.Flags().GetString("since")
+		until, _ := cmd.Flags().GetString("until")
+		maxCount, _ := cmd.Flags().GetInt("max-count")
+		workers, _ := cmd.Flags().GetInt("workers")
+		namespace, _ := cmd.Flags().GetString("namespace")
+		name, _ := cmd.Flags().GetString("name")
+		container, _ := cmd.Flags().GetString("container")
+		level, _ := cmd.Flags().GetString("level")
+
+		expr := args[0]
+		if ignoreCase {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			log.Fatalf("Invalid regex %q: %v\n", args[0], err)
+		}
+		options := logSearchOptions{Regexp: re, Invert: invert, MaxCount: maxCount}
+		levels, err := newLogLevelFilter(level)
+		if err != nil {
+			log.Fatalf("Invalid --level: %v\n", err)
+		}
+		options.Levels = *levels
+		if options.Since, err = parseOptionalTimeArg(since); err != nil {
+			log.Fatalf("Invalid --since: %v\n", err)
+		}
+		if options.Until, err = parseOptionalTimeArg(until); err != nil {
+			log.Fatalf("Invalid --until: %v\n", err)
+		}
+		if !options.Since.IsZero() || !options.Until.IsZero() {
+			// klog timestamps have no year; without the bundle's, take the range's
+			reference := options.Until
+			if reference.IsZero() {
+				reference = options.Since
+			}
+			options.Times = newLogTimeParser(getBundleRootDir(), reference)
+		}
+
+		logFiles := bundlePodLogFiles(podLogFilter{Namespace: namespace, Name: name, Container: container})
+		if !searchPodLogs(logFiles, options, workers) {
+			os.Exit(1)
+		}
+	},
+}
+
+// logSearchOptions : What bunk log search looks for in each log file
+type logSearchOptions struct {
+	Regexp *regexp.Regexp
+	// Invert selects the lines that do not match
+	Invert bool
+	// Since and Until bound the times of the lines selected, unless they are zero
+	Since time.Time
+	Until time.Time
+	// Times parses the timestamps of lines, if Since or Until are set
+	Times logTimeParser
+	// Levels selects lines by level, if it is enabled
+	Levels logLevelFilter
+	// MaxCount stops searching a file after that many matches, unless it is 0
+	MaxCount int
+}
+
+// LogSearchMatch : A line of a log file selected by bunk log search
+type LogSearchMatch struct {
+	Line int
+	Text string
+}
+
+// logSearchBuffer is how many selected lines of a log file are held until they are printed;
+// the search of a file waits while as many are held
+const logSearchBuffer = 1024
+
+// logSearchResult : The lines selected from a log file, streamed to the printer
+type logSearchResult struct {
+	File    PodLogFile
+	Matches chan LogSearchMatch
+	// Bytes and Err are set once Matches is closed
+	Bytes int64
+	Err   error
+}
+
+// logPrefilter : Literals at least one of which every line a regexp matches contains, so that
+// most lines can be skipped without running the regexp on them
+type logPrefilter struct {
+	literals [][]byte
+	// fold compares lowercased lines, for case insensitive regexps
+	fold  bool
+	lower []byte
+}
+
+// newLogPrefilter returns a prefilter for re, or nil if re requires no literal it can find
+func newLogPrefilter(re *regexp.Regexp) *logPrefilter {
+	parsed, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	literals, fold, ok := requiredLiterals(parsed.Simplify())
+	if !ok || len(literals) == 0 {
+		return nil
+	}
+
+	prefilter := &logPrefilter{fold: fold}
+	for _, literal := range literals {
+		if literal == "" {
+			return nil
+		}
+		b := []byte(literal)
+		if fold {
+			// The same lowercasing as mayMatch gives lines
+			b = foldLower(b, nil)
+		}
+		prefilter.literals = append(prefilter.literals, b)
+	}
+	return prefilter
+}
+
+// requiredLiterals returns literals one of which every match of re contains, and whether they
+// must be compared case insensitively; ok is false if there are none it can tell
+func requiredLiterals(re *syntax.Regexp) (literals []string, fold bool, ok bool) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		literal := string(re.Rune)
+		fold = re.Flags&syntax.FoldCase != 0
+		// foldLower only folds ASCII letters the way the regexp does
+		if fold && !allASCII([]string{literal}) {
+			return nil, false, false
+		}
+		return []string{literal}, fold, true
+
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiterals(re.Sub[0])
+
+	case syntax.OpRepeat:
+		if re.Min < 1 {
+			return nil, false, false
+		}
+		return requiredLiterals(re.Sub[0])
+
+	case syntax.OpConcat:
+		// Any part's literals are required; prefer the longest
+		best := -1
+		for _, sub := range re.Sub {
+			subLiterals, subFold, subOK := requiredLiterals(sub)
+			if !subOK {
+				continue
+			}
+			shortest := len(subLiterals[0])
+			for _, literal := range subLiterals {
+				if len(literal) < shortest {
+					shortest = len(literal)
+				}
+			}
+			if shortest > best {
+				best, literals, fold, ok = shortest, subLiterals, subFold, true
+			}
+		}
+		return literals, fold, ok
+
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			subLiterals, subFold, subOK := requiredLiterals(sub)
+			if !subOK {
+				return nil, false, false
+			}
+			literals = append(literals, subLiterals...)
+			// Comparing case insensitively only ever selects more lines
+			fold = fold || subFold
+		}
+		// foldLower only folds ASCII letters the way the regexp does, which now applies to
+		// every branch's literals
+		if fold && !allASCII(literals) {
+			return nil, false, false
+		}
+		return literals, fold, true
+	}
+	return nil, false, false
+}
+
+// allASCII reports whether literals are all ASCII
+func allASCII(literals []string) bool {
+	for _, literal := range literals {
+		for _, r := range literal {
+			if r > unicode.MaxASCII {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// foldLower appends b to dst with its ASCII letters lowercased, and with the runes that case
+// insensitive regexps fold to ASCII letters, such as the Kelvin sign U+212A for k and the long s
+// U+017F for s, replaced by those letters lowercased
+func foldLower(b []byte, dst []byte) []byte {
+	for i := 0; i < len(b); {
+		c := b[i]
+		if c < utf8.RuneSelf {
+			if 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			dst = append(dst, c)
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(b[i:])
+		if folded, ok := asciiFold(r); ok {
+			dst = append(dst, folded)
+		} else {
+			dst = append(dst, b[i:i+size]...)
+		}
+		i += size
+	}
+	return dst
+}
+
+// asciiFold returns the lowercase ASCII letter that the non-ASCII r folds to, if any
+func asciiFold(r rune) (byte, bool) {
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if 'a' <= f && f <= 'z' {
+			return byte(f), true
+		}
+	}
+	return 0, false
+}
+
+// mayMatch reports whether line contains any of the prefilter's literals
+func (p *logPrefilter) mayMatch(line []byte) bool {
+	if p.fold {
+		p.lower = foldLower(line, p.lower[:0])
+		line = p.lower
+	}
+	for _, literal := range p.literals {
+		if bytes.Contains(line, literal) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseOptionalTimeArg parses a time flag, which may be unset
+func parseOptionalTimeArg(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return parseTimeArg(value)
+}
+
+// inTimeRange reports whether the time of a line is within the search's range; lines of unknown
+// time are not, if there is a range
+func (o logSearchOptions) inTimeRange(t time.Time) bool {
+	if o.Since.IsZero() && o.Until.IsZero() {
+		return true
+	}
+	if t.IsZero() {
+		return false
+	}
+	return (o.Since.IsZero() || !t.Before(o.Since)) && (o.Until.IsZero() || !t.After(o.Until))
+}
+
+// searchPodLog sends the lines selected from the log file of result to its Matches, closing it
+// when done
+func searchPodLog(result *logSearchResult, options logSearchOptions) {
+	defer close(result.Matches)
+	f, err := os.Open(result.File.Path)
+	if err != nil {
+		result.Err = err
+		return
+	}
+	defer f.Close()
+
+	timed := !options.Since.IsZero() || !options.Until.IsZero()
+	prefilter := newLogPrefilter(options.Regexp)
+	var lineTime time.Time
+	levels := options.Levels
+	parser := logParser{Times: options.Times}
+	selected := 0
+	scanner := newLogScanner(f)
+	for number := 1; scanner.Scan(); number++ {
+		line := scanner.Bytes()
+		result.Bytes += int64(len(line)) + 1
+		if levels.Enabled() && !levels.Keep(parser.ParseLevel(line)) {
+			continue
+		}
+		if timed {
+			// Lines without a timestamp belong to the one before
+			if t, ok := options.Times.Parse(line); ok {
+				lineTime = t
+			}
+			if !options.inTimeRange(lineTime) {
+				continue
+			}
+		}
+		matched := (prefilter == nil || prefilter.mayMatch(line)) && options.Regexp.Match(line)
+		if matched == options.Invert {
+			continue
+		}
+		result.Matches <- LogSearchMatch{Line: number, Text: string(line)}
+		if selected++; options.MaxCount > 0 && selected >= options.MaxCount {
+			break
+		}
+	}
+	result.Err = scanner.Err()
+}
+
+var (
+	searchPodColor   = color.New(color.FgCyan, color.Bold)
+	searchCountColor = color.New(color.FgYellow)
+)
+
+// searchMatchesCount formats a number of selected lines
+func searchMatchesCount(count int) string {
+	if count == 1 {
+		return searchCountColor.Sprint("(1 match)")
+	}
+	return searchCountColor.Sprintf("(%d matches)", count)
+}
+
+// printLogSearchResult prints the lines selected from a log file under a header naming its pod,
+// as they come in, and returns how many there were. The header counts them if they all fit in
+// logSearchBuffer; otherwise they are counted after them.
+func printLogSearchResult(result *logSearchResult, options logSearchOptions) int {
+	var matches []LogSearchMatch
+	open := true
+	for open && len(matches) <= logSearchBuffer {
+		var match LogSearchMatch
+		if match, open = <-result.Matches; open {
+			matches = append(matches, match)
+		}
+	}
+	if len(matches) == 0 {
+		return 0
+	}
+
+	if open {
+		fmt.Printf("%s\n", searchPodColor.Sprint(result.File.Name()))
+	} else {
+		fmt.Printf("%s %s\n", searchPodColor.Sprint(result.File.Name()), searchMatchesCount(len(matches)))
+	}
+	width := len(fmt.Sprint(matches[len(matches)-1].Line))
+	printMatch := func(match LogSearchMatch) {
+		text := match.Text
+		if !options.Invert {
+			text = options.Regexp.ReplaceAllStringFunc(text, func(s string) string { return grepMatchColor.Sprint(s) })
+		}
+		fmt.Printf("  %s  %s\n", grepLineColor.Sprintf("%*d", width, match.Line), text)
+	}
+	for _, match := range matches {
+		printMatch(match)
+	}
+	count := len(matches)
+	if open {
+		for match := range result.Matches {
+			printMatch(match)
+			count++
+		}
+		fmt.Printf("  %s\n", searchMatchesCount(count))
+	}
+	fmt.Println()
+	return count
+}
+
+// searchPodLogs searches logFiles with up to workers files at a time, printing the results in
+// the order of logFiles as they come in; it returns whether any line was selected. The search
+// runs at most twice as many files as workers ahead of the file being printed, so that only so
+// many selected lines are ever held.
+func searchPodLogs(logFiles []PodLogFile, options logSearchOptions, workers int) bool {
+	if workers < 1 {
+		workers = 1
+	}
+	start := time.Now()
+
+	queue := make(chan *logSearchResult, 2*workers)
+	files := make(chan *logSearchResult)
+	for w := 0; w < workers; w++ {
+		go func() {
+			for result := range files {
+				searchPodLog(result, options)
+			}
+		}()
+	}
+	go func() {
+		for _, logFile := range logFiles {
+			result := &logSearchResult{File: logFile, Matches: make(chan LogSearchMatch, logSearchBuffer)}
+			queue <- result
+			files <- result
+		}
+		close(queue)
+		close(files)
+	}()
+
+	matches, pods := 0, 0
+	var scanned int64
+	for result := range queue {
+		count := printLogSearchResult(result, options)
+		if result.Err != nil {
+			log.Printf("Failed to search %s: %v\n", result.File.Path, result.Err)
+		}
+		scanned += result.Bytes
+		matches += count
+		if count > 0 {
+			pods++
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "%d matching lines in %d of %d log files (%s searched in %s)\n",
+		matches, pods, len(logFiles), formatBytes(scanned), time.Since(start).Round(time.Millisecond))
+	return matches > 0
+}
+
+func init() {
+	logCmd.AddCommand(logSearchCmd)
+
+	logSearchCmd.Flags().BoolP("ignore-case", "i", false, "Match case insensitively")
+	logSearchCmd.Flags().BoolP("invert-match", "v", false, "Select the lines that do not match")
+	logSearchCmd.Flags().String("since", "", "Only select lines logged at or after this time")
+	logSearchCmd.Flags().String("until", "", "Only select lines logged at or before this time")
+	logSearchCmd.Flags().String("level", "", "Only search lines of this level or above: debug, info, warning, error or fatal")
+	logSearchCmd.Flags().IntP("max-count", "m", 0, "Stop searching a log file after this many selected lines")
+	logSearchCmd.Flags().Int("workers", runtime.NumCPU(), "Number of log files to search at once")
+	logSearchCmd.Flags().StringP("namespace", "n", "", "Only search the logs of pods in this namespace")
+	logSearchCmd.Flags().String("name", "", "Only search the logs of pods whose name matches this glob, e.g. web-*")
+	logSearchCmd.Flags().StringP("container", "c", "", "Only search the logs of this container")
+}
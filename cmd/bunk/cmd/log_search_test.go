@@ -0,0 +1,119 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"testing"
+)
+
+func TestRequiredLiterals(t *testing.T) {
+	tests := []struct {
+		expr     string
+		literals string
+		fold     bool
+		ok       bool
+	}{
+		{expr: "error", literals: "error", ok: true},
+		{expr: "(?i)error", literals: "error", fold: true, ok: true},
+		{expr: "timeout|refused", literals: "timeout refused", ok: true},
+		{expr: "(?i)timeout|refused", literals: "timeout refused", fold: true, ok: true},
+		{expr: "conn(ection)? refused", literals: " refused", ok: true},
+		{expr: "(error)+", literals: "error", ok: true},
+		{expr: "Échec", literals: "Échec", ok: true},
+		// Non-ASCII literals cannot be folded the way mayMatch lowercases lines
+		{expr: "(?i)échec"},
+		{expr: "(?i:error)|Échec"},
+		{expr: "Échec|(?i:error)"},
+		{expr: "a*"},
+		{expr: "error|.*"},
+	}
+
+	for _, test := range tests {
+		parsed, err := syntax.Parse(test.expr, syntax.Perl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		literals, fold, ok := requiredLiterals(parsed.Simplify())
+		if ok != test.ok {
+			t.Errorf("requiredLiterals(%q) ok = %v, want %v", test.expr, ok, test.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if got := strings.ToLower(strings.Join(literals, " ")); got != strings.ToLower(test.literals) || fold != test.fold {
+			t.Errorf("requiredLiterals(%q) = %q, fold %v; want %q, fold %v", test.expr, got, fold, test.literals, test.fold)
+		}
+	}
+}
+
+func TestLogPrefilterMayMatch(t *testing.T) {
+	exprs := []string{
+		"error",
+		"(?i)error",
+		"(?i)timeout|refused",
+		"(?i:error)|Échec",
+		"(?i)échec",
+		"Échec",
+		"conn(ection)? refused",
+		"(?i)CONNECTION",
+		"(?i)kube",
+		"(?i)conn(ection)? refused",
+	}
+	lines := []string{
+		"Échec de connexion",
+		"échec de connexion",
+		"ERROR: disk full",
+		"an error occurred",
+		"dial tcp: connection refused",
+		"CONNECTION REFUSED",
+		"request Timeout",
+		// The Kelvin sign U+212A folds to k and the long s U+017F to s
+		"\u212Aube-proxy started",
+		"connection re\u017Fused",
+		"nothing to see here",
+		"",
+	}
+
+	for _, expr := range exprs {
+		re := regexp.MustCompile(expr)
+		prefilter := newLogPrefilter(re)
+		if prefilter == nil {
+			continue
+		}
+		for _, line := range lines {
+			// A prefilter may let lines through that the regexp does not match, never the reverse
+			if re.MatchString(line) && !prefilter.mayMatch([]byte(line)) {
+				t.Errorf("prefilter of %q skips %q, which it matches", expr, line)
+			}
+		}
+	}
+
+	// The prefilter does skip lines
+	prefilter := newLogPrefilter(regexp.MustCompile("(?i)timeout|refused"))
+	if prefilter == nil || prefilter.mayMatch([]byte("nothing to see here")) {
+		t.Errorf("prefilter of (?i)timeout|refused does not skip lines without either")
+	}
+	if !prefilter.mayMatch([]byte("request TIMEOUT")) {
+		t.Errorf("prefilter of (?i)timeout|refused skips request TIMEOUT")
+	}
+	if prefilter = newLogPrefilter(regexp.MustCompile("(?i)kube")); prefilter == nil || !prefilter.mayMatch([]byte("\u212Aube-proxy")) {
+		t.Errorf("prefilter of (?i)kube skips \\u212Aube-proxy, which it matches")
+	}
+}
@@ -0,0 +1,347 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/cobra"
+)
+
+// tailChunkSize is how much of a log is read at a time looking for its last lines
+const tailChunkSize = 64 * 1024
+
+// logViewCmd represents the log view command
+var logViewCmd = &cobra.Command{
+	Use:   "view <namespace> <pod>",
+	Short: "Open the log of a pod in $PAGER",
+	Long: `Open the log of a pod in $PAGER, or less if it is not set. When the output is not a
+terminal, the log is printed instead. Pass -c to pick the container of a pod with logs for
+several.
+
+klog, logfmt and JSON lines are parsed for their time, level, source and message: --level
+only shows the lines of that level or above, with the lines continuing them such as stack
+traces, -o pretty lines up those fields readably and -o jsonl prints them as one normalized JSON
+object per line for other tools.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		container, _ := cmd.Flags().GetString("container")
+		viewPodLog(args[0], args[1], container, logRenderFlags(cmd))
+	},
+}
+
+// logTailCmd represents the log tail command
+var logTailCmd = &cobra.Command{
+	Use:   "tail <namespace> <pod>",
+	Short: "Print the last lines of the log of a pod",
+	Long: `Print the last lines of the log of a pod. With --level, the last lines of that level or
+above are printed; -o pretty and -o jsonl format them as for bunk log view.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		container, _ := cmd.Flags().GetString("container")
+		lines, _ := cmd.Flags().GetInt("lines")
+		if lines < 0 {
+			log.Fatalf("--lines must not be negative\n")
+		}
+		tailPodLog(args[0], args[1], container, lines, logRenderFlags(cmd))
+	},
+}
+
+// logRenderFlags returns a renderer for the --level and --output flags of cmd
+func logRenderFlags(cmd *cobra.Command) *logRenderer {
+	level, _ := cmd.Flags().GetString("level")
+	output, _ := cmd.Flags().GetString("output")
+
+	levels, err := newLogLevelFilter(level)
+	if err != nil {
+		log.Fatalf("Invalid --level: %v\n", err)
+	}
+	if !validLogOutput(output) {
+		log.Fatalf("Invalid --output %q, expected %s, %s or %s\n", output, logOutputRaw, logOutputPretty, logOutputJSONL)
+	}
+	return &logRenderer{Output: output, Levels: levels}
+}
+
+// rendersLog reports whether r changes the log at all, or it can be printed as it is
+func (r *logRenderer) rendersLog() bool {
+	return r.Output != logOutputRaw || r.Levels.Enabled()
+}
+
+// open sets the log file to render; parsing its times needs the nodes and events of the bundle,
+// which are only read if the log is rendered at all
+func (r *logRenderer) open(logFile PodLogFile) {
+	r.File = logFile
+	if r.rendersLog() {
+		r.Parser = logParser{Times: newLogTimeParser(getBundleRootDir(), time.Time{})}
+	}
+}
+
+func stdoutIsTerminal() bool {
+	return isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd())
+}
+
+// renderLogLines renders the lines read from r with renderer; numbered is false if r does not
+// start at the first line of the log
+func renderLogLines(r io.Reader, renderer *logRenderer, numbered bool) error {
+	scanner := newLogScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		if !numbered {
+			number = 0
+		}
+		renderer.Line(scanner.Bytes(), number)
+	}
+	renderer.Flush()
+	return scanner.Err()
+}
+
+// renderPodLog renders the lines of the log file of renderer to w
+func renderPodLog(w io.Writer, renderer *logRenderer) error {
+	f, err := os.Open(renderer.File.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	out := bufio.NewWriter(w)
+	renderer.Emit = func(line string) {
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if err := renderLogLines(f, renderer, true); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+func viewPodLog(namespace string, pod string, container string, renderer *logRenderer) {
+	renderer.open(findPodLog(namespace, pod, container))
+	podLogFile := renderer.File.Path
+
+	if renderer.rendersLog() {
+		if !stdoutIsTerminal() {
+			if err := renderPodLog(os.Stdout, renderer); err != nil {
+				log.Fatalf("Could not print %v: %v\n", podLogFile, err)
+			}
+			return
+		}
+		pageRenderedPodLog(renderer)
+		return
+	}
+
+	if !stdoutIsTerminal() {
+		f, err := os.Open(podLogFile)
+		if err != nil {
+			log.Fatalf("Could not open %v: %v\n", podLogFile, err)
+		}
+		defer f.Close()
+		if _, err := io.Copy(os.Stdout, f); err != nil {
+			log.Fatalf("Could not print %v: %v\n", podLogFile, err)
+		}
+		return
+	}
+
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less"
+	}
+
+	cmd := exec.Command(pager, podLogFile)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	fmt.Printf("Opening pod log file: %v\n", podLogFile)
+	if err := cmd.Run(); err != nil {
+		log.Fatalf("Could not open %v in `%v`: %v\n", podLogFile, pager, err)
+	}
+}
+
+// pageRenderedPodLog pipes the rendered log of renderer into $PAGER, or less -R, which shows
+// its colors
+func pageRenderedPodLog(renderer *logRenderer) {
+	var cmd *exec.Cmd
+	if pager := os.Getenv("PAGER"); pager != "" {
+		cmd = exec.Command(pager)
+	} else {
+		cmd = exec.Command("less", "-R")
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		log.Fatalf("Could not open `%v`: %v\n", cmd.Path, err)
+	}
+	if err := cmd.Start(); err != nil {
+		log.Fatalf("Could not open `%v`: %v\n", cmd.Path, err)
+	}
+
+	// The pager may be quit before the whole log is rendered
+	renderErr := renderPodLog(stdin, renderer)
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		log.Fatalf("Could not open %v in `%v`: %v\n", renderer.File.Path, cmd.Path, err)
+	}
+	if renderErr != nil && !errors.Is(renderErr, syscall.EPIPE) {
+		log.Fatalf("Could not read %v: %v\n", renderer.File.Path, renderErr)
+	}
+}
+
+// tailOffset returns the offset of the last n lines of f, reading it backwards from its end
+func tailOffset(f *os.File, n int) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	end := info.Size()
+	if n == 0 {
+		return end, nil
+	}
+
+	// A newline ending the last line does not start another
+	last := make([]byte, 1)
+	if end > 0 {
+		if _, err := f.ReadAt(last, end-1); err != nil {
+			return 0, err
+		}
+		if last[0] == '\n' {
+			end--
+		}
+	}
+
+	chunk := make([]byte, tailChunkSize)
+	lines := 0
+	for end > 0 {
+		start := end - tailChunkSize
+		if start < 0 {
+			start = 0
+		}
+		buf := chunk[:end-start]
+		if _, err := f.ReadAt(buf, start); err != nil {
+			return 0, err
+		}
+		for i := len(buf) - 1; i >= 0; i-- {
+			if buf[i] != '\n' {
+				continue
+			}
+			lines++
+			if lines == n {
+				return start + int64(i) + 1, nil
+			}
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+// tailRenderedPodLog prints the last lines rendered from the log of renderer, reading all of it
+// since the lines selected by level may be anywhere in it
+func tailRenderedPodLog(renderer *logRenderer, lines int) {
+	// last is a ring buffer of the last lines rendered, the oldest at next once it is full
+	last := make([]string, 0, lines)
+	next := 0
+	renderer.Emit = func(line string) {
+		if lines == 0 {
+			return
+		}
+		if len(last) < lines {
+			last = append(last, line)
+			return
+		}
+		last[next] = line
+		next = (next + 1) % lines
+	}
+	f, err := os.Open(renderer.File.Path)
+	if err != nil {
+		log.Fatalf("Could not open %v: %v\n", renderer.File.Path, err)
+	}
+	defer f.Close()
+	if err := renderLogLines(f, renderer, true); err != nil {
+		log.Fatalf("Could not read %v: %v\n", renderer.File.Path, err)
+	}
+	out := bufio.NewWriter(os.Stdout)
+	for i := range last {
+		line := last[(next+i)%len(last)]
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if err := out.Flush(); err != nil {
+		log.Fatalf("Could not print %v: %v\n", renderer.File.Path, err)
+	}
+}
+
+func tailPodLog(namespace string, pod string, container string, lines int, renderer *logRenderer) {
+	renderer.open(findPodLog(namespace, pod, container))
+	podLogFile := renderer.File.Path
+	if renderer.Levels.Enabled() {
+		tailRenderedPodLog(renderer, lines)
+		return
+	}
+
+	f, err := os.Open(podLogFile)
+	if err != nil {
+		log.Fatalf("Could not open %v: %v\n", podLogFile, err)
+	}
+	defer f.Close()
+
+	offset, err := tailOffset(f, lines)
+	if err != nil {
+		log.Fatalf("Could not read %v: %v\n", podLogFile, err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		log.Fatalf("Could not read %v: %v\n", podLogFile, err)
+	}
+	if !renderer.rendersLog() {
+		if _, err := io.Copy(os.Stdout, f); err != nil {
+			log.Fatalf("Could not print %v: %v\n", podLogFile, err)
+		}
+		return
+	}
+
+	// The line numbers of the last lines are not known without reading the whole log
+	out := bufio.NewWriter(os.Stdout)
+	renderer.Emit = func(line string) {
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if err := renderLogLines(f, renderer, false); err != nil {
+		log.Fatalf("Could not read %v: %v\n", podLogFile, err)
+	}
+	if err := out.Flush(); err != nil {
+		log.Fatalf("Could not print %v: %v\n", podLogFile, err)
+	}
+}
+
+func init() {
+	logCmd.AddCommand(logViewCmd)
+	logCmd.AddCommand(logTailCmd)
+
+	logViewCmd.Flags().StringP("container", "c", "", "Container of the pod to view the log of")
+	logTailCmd.Flags().StringP("container", "c", "", "Container of the pod to print the log of")
+	logTailCmd.Flags().IntP("lines", "n", 10, "Number of lines to print")
+	for _, cmd := range []*cobra.Command{logViewCmd, logTailCmd} {
+		cmd.Flags().String("level", "", "Only show lines of this level or above: debug, info, warning, error or fatal")
+		cmd.Flags().StringP("output", "o", logOutputRaw, "Output format: raw, pretty or jsonl")
+	}
+}
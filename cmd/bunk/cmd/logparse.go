@@ -0,0 +1,410 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats of log lines the log parser recognizes
+const (
+	logFormatKlog   = "klog"
+	logFormatLogfmt = "logfmt"
+	logFormatJSON   = "json"
+	logFormatText   = "text"
+)
+
+// logLevels are the normalized log levels, from least to most severe
+var logLevels = []string{"debug", "info", "warning", "error", "fatal"}
+
+// logLevelAliases maps the level names of common loggers to normalized levels
+var logLevelAliases = map[string]string{
+	"trace": "debug", "debug": "debug", "dbug": "debug", "dbg": "debug",
+	"info": "info", "information": "info", "notice": "info",
+	"warn": "warning", "warning": "warning",
+	"error": "error", "err": "error", "eror": "error",
+	"fatal": "fatal", "panic": "fatal", "dpanic": "fatal", "critical": "fatal", "crit": "fatal",
+}
+
+// klogLevels maps the severity letters of klog headers to normalized levels
+var klogLevels = map[byte]string{'I': "info", 'W': "warning", 'E': "error", 'F': "fatal"}
+
+// Fields that JSON and logfmt lines keep the level, message and source of a record in
+var (
+	logLevelFields   = []string{"level", "lvl", "severity", "log.level"}
+	logMessageFields = []string{"msg", "message", "@message"}
+	logSourceFields  = []string{"caller", "source", "logger.caller"}
+)
+
+// LogRecord : A log line, parsed into its fields
+type LogRecord struct {
+	Time time.Time
+	// Level is one of logLevels, or empty if the line has none
+	Level string
+	// Source is where in its code the line was logged, such as main.go:42
+	Source  string
+	Message string
+	// Fields are the other fields of structured lines
+	Fields map[string]interface{}
+	Format string
+	// Continuation is set for lines without time or level that continue the record before, such
+	// as the lines of a stack trace
+	Continuation bool
+}
+
+// logParser : Parses log lines in the formats of klog, logfmt and JSON, or plain text
+type logParser struct {
+	Times logTimeParser
+}
+
+// parseLogLevel normalizes a level name, returning "" for names it does not know
+func parseLogLevel(name string) string {
+	return logLevelAliases[strings.ToLower(strings.TrimSpace(name))]
+}
+
+// logLevelRank returns the severity of a normalized level, or -1 for none
+func logLevelRank(level string) int {
+	for i, l := range logLevels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// Parse parses a log line
+func (p logParser) Parse(line []byte) LogRecord {
+	trimmed := bytes.TrimSpace(line)
+	switch {
+	case len(trimmed) > 0 && trimmed[0] == '{':
+		if record, ok := p.parseJSON(trimmed); ok {
+			return record
+		}
+	case isKlogHeader(trimmed):
+		if record, ok := p.parseKlog(trimmed); ok {
+			return record
+		}
+	case isLogfmt(trimmed):
+		return p.parseLogfmt(trimmed)
+	}
+	return p.parseText(line)
+}
+
+// ParseLevel parses only the level of a line and whether it continues the record before, which
+// is all a logLevelFilter needs, without decoding structured lines whole
+func (p logParser) ParseLevel(line []byte) LogRecord {
+	trimmed := bytes.TrimSpace(line)
+	var value func([]byte, string) (string, bool)
+	record := LogRecord{}
+	switch {
+	case len(trimmed) > 1 && trimmed[0] == '{' && trimmed[len(trimmed)-1] == '}':
+		value, record.Format = jsonFieldValue, logFormatJSON
+	case isKlogHeader(trimmed):
+		return LogRecord{Format: logFormatKlog, Level: klogLevels[trimmed[0]]}
+	case isLogfmt(trimmed):
+		value, record.Format = logfmtFieldValue, logFormatLogfmt
+	default:
+		return p.parseText(line)
+	}
+	for _, field := range logLevelFields {
+		if level, ok := value(trimmed, field); ok {
+			record.Level = parseLogLevel(level)
+			break
+		}
+	}
+	return record
+}
+
+// takeField removes the first of names present in fields and returns its value as a string
+func takeField(fields map[string]interface{}, names []string) string {
+	for _, name := range names {
+		value, ok := fields[name]
+		if !ok {
+			continue
+		}
+		delete(fields, name)
+		switch v := value.(type) {
+		case string:
+			return v
+		case map[string]interface{}:
+			// slog keeps the source as {"function": ..., "file": ..., "line": ...}
+			if file, ok := v["file"]; ok {
+				return fmt.Sprintf("%v:%v", file, v["line"])
+			}
+		}
+		return fmt.Sprint(value)
+	}
+	return ""
+}
+
+// takeTime removes the first timestamp field present in fields and parses it
+func takeTime(fields map[string]interface{}) time.Time {
+	for _, name := range logTimeFields {
+		value, ok := fields[name]
+		if !ok {
+			continue
+		}
+		if t, ok := parseTimeValue(fmt.Sprint(value)); ok {
+			delete(fields, name)
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (p logParser) parseJSON(line []byte) (LogRecord, bool) {
+	fields := map[string]interface{}{}
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return LogRecord{}, false
+	}
+
+	record := LogRecord{Format: logFormatJSON, Fields: fields}
+	record.Time = takeTime(fields)
+	record.Level = parseLogLevel(takeField(fields, logLevelFields))
+	record.Message = takeField(fields, logMessageFields)
+	record.Source = takeField(fields, logSourceFields)
+	return record, true
+}
+
+// parseKlog parses lines such as I0918 14:02:59.123456  1 main.go:42] starting, including the
+// key="value" pairs of structured klog messages
+func (p logParser) parseKlog(line []byte) (LogRecord, bool) {
+	end := bytes.Index(line, []byte("] "))
+	if end < 0 {
+		end = bytes.IndexByte(line, ']')
+	}
+	if end < 0 {
+		return LogRecord{}, false
+	}
+
+	record := LogRecord{Format: logFormatKlog, Level: klogLevels[line[0]]}
+	record.Time, _ = p.Times.parseKlogTime(line)
+	// The header ends with the thread id and file:line, if the ] comes after the timestamp
+	if timeEnd := klogTimeEnd(line); end > timeEnd {
+		if header := strings.Fields(string(line[timeEnd:end])); len(header) > 0 {
+			record.Source = header[len(header)-1]
+		}
+	}
+	message := strings.TrimPrefix(string(line[end+1:]), " ")
+
+	if strings.HasPrefix(message, `"`) {
+		if quoted, rest, ok := splitQuoted(message); ok {
+			record.Message = quoted
+			if fields := parseLogfmtPairs(rest); len(fields) > 0 {
+				record.Fields = fields
+			}
+			return record, true
+		}
+	}
+	record.Message = message
+	return record, true
+}
+
+// splitQuoted splits a leading Go quoted string off s, returning it unquoted and the rest
+func splitQuoted(s string) (string, string, bool) {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			unquoted, err := strconv.Unquote(s[:i+1])
+			return unquoted, s[i+1:], err == nil
+		}
+	}
+	return "", "", false
+}
+
+// isLogfmt reports whether line looks like logfmt: starting with a key=value pair, with more to
+// follow
+func isLogfmt(line []byte) bool {
+	eq := bytes.IndexByte(line, '=')
+	if eq <= 0 {
+		return false
+	}
+	for _, c := range line[:eq] {
+		if !(c == '_' || c == '.' || c == '-' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return bytes.Count(line, []byte("=")) >= 2
+}
+
+// parseLogfmtPairs parses the key=value and key="quoted value" pairs of a logfmt line; bare
+// keys are true
+func parseLogfmtPairs(s string) map[string]interface{} {
+	fields := map[string]interface{}{}
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return fields
+		}
+		end := strings.IndexAny(s, "= \t")
+		if end < 0 {
+			fields[s] = true
+			return fields
+		}
+		key := s[:end]
+		if s[end] != '=' {
+			fields[key] = true
+			s = s[end:]
+			continue
+		}
+		s = s[end+1:]
+		if strings.HasPrefix(s, `"`) {
+			if value, rest, ok := splitQuoted(s); ok {
+				fields[key] = value
+				s = rest
+				continue
+			}
+		}
+		end = strings.IndexAny(s, " \t")
+		if end < 0 {
+			end = len(s)
+		}
+		fields[key] = s[:end]
+		s = s[end:]
+	}
+}
+
+func (p logParser) parseLogfmt(line []byte) LogRecord {
+	fields := parseLogfmtPairs(string(line))
+	record := LogRecord{Format: logFormatLogfmt, Fields: fields}
+	record.Time = takeTime(fields)
+	record.Level = parseLogLevel(takeField(fields, logLevelFields))
+	record.Message = takeField(fields, logMessageFields)
+	record.Source = takeField(fields, logSourceFields)
+	return record
+}
+
+// parseText parses a plain text line: its leading timestamp, and a level among its first words
+func (p logParser) parseText(line []byte) LogRecord {
+	record := LogRecord{Format: logFormatText, Message: string(line)}
+	t, hasTime := p.Times.Parse(line)
+	if hasTime {
+		record.Time = t
+	}
+
+	words := strings.Fields(record.Message)
+	for i, word := range words {
+		if i == 4 {
+			break
+		}
+		if level := parseLogLevel(strings.Trim(word, "[]():|")); level != "" {
+			record.Level = level
+			break
+		}
+	}
+	record.Continuation = !hasTime && record.Level == ""
+	return record
+}
+
+// logRecordJSON : The normalized JSON form of a log record
+type logRecordJSON struct {
+	Log     string                 `json:"log,omitempty"`
+	Line    int                    `json:"line,omitempty"`
+	Time    string                 `json:"time,omitempty"`
+	Level   string                 `json:"level,omitempty"`
+	Source  string                 `json:"source,omitempty"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+	Format  string                 `json:"format"`
+}
+
+// JSON returns the normalized JSON form of the record, read from line number of the log called
+// name
+func (r LogRecord) JSON(name string, number int) logRecordJSON {
+	out := logRecordJSON{
+		Log:     name,
+		Line:    number,
+		Level:   r.Level,
+		Source:  r.Source,
+		Message: r.Message,
+		Fields:  r.Fields,
+		Format:  r.Format,
+	}
+	if !r.Time.IsZero() {
+		out.Time = r.Time.Format(time.RFC3339Nano)
+	}
+	return out
+}
+
+// fieldsString formats the fields of a record as sorted logfmt pairs
+func (r LogRecord) fieldsString() string {
+	keys := make([]string, 0, len(r.Fields))
+	for key := range r.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		value := fmt.Sprint(r.Fields[key])
+		if nested, ok := r.Fields[key].(map[string]interface{}); ok {
+			encoded, _ := json.Marshal(nested)
+			value = string(encoded)
+		}
+		if value == "" || strings.ContainsAny(value, " \t\"=") {
+			value = strconv.Quote(value)
+		}
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, " ")
+}
+
+// logLevelFilter : Selects the log records of at least a level, along with the lines continuing
+// them
+type logLevelFilter struct {
+	// Rank is the least severity selected; -1 selects every line
+	Rank int
+	keep bool
+}
+
+// newLogLevelFilter returns a filter for records of level or above, selecting every line if level
+// is empty
+func newLogLevelFilter(level string) (*logLevelFilter, error) {
+	if level == "" {
+		return &logLevelFilter{Rank: -1}, nil
+	}
+	normalized := parseLogLevel(level)
+	if normalized == "" {
+		return nil, fmt.Errorf("unknown level %q, expected one of %s", level, strings.Join(logLevels, ", "))
+	}
+	return &logLevelFilter{Rank: logLevelRank(normalized)}, nil
+}
+
+// Enabled reports whether the filter selects by level at all
+func (f *logLevelFilter) Enabled() bool {
+	return f.Rank >= 0
+}
+
+// Keep reports whether record is selected; continuation lines are if the record before them is
+func (f *logLevelFilter) Keep(record LogRecord) bool {
+	if !f.Enabled() {
+		return true
+	}
+	if !record.Continuation {
+		f.keep = logLevelRank(record.Level) >= f.Rank
+	}
+	return f.keep
+}
@@ -0,0 +1,176 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+// testLogParser parses with bundles collected at the end of September 2020
+var testLogParser = logParser{Times: logTimeParser{Reference: time.Date(2020, 9, 30, 0, 0, 0, 0, time.UTC)}}
+
+func TestLogParserParse(t *testing.T) {
+	tests := []struct {
+		line   string
+		want   LogRecord
+		fields string
+	}{
+		{
+			line: "I0918 12:00:00.000 1 main.go:42] starting",
+			want: LogRecord{Time: time.Date(2020, 9, 18, 12, 0, 0, 0, time.UTC), Level: "info", Source: "main.go:42", Message: "starting", Format: logFormatKlog},
+		},
+		{
+			line:   `E0918 14:03:08.000001       1 reflector.go:138] "Failed to watch" err="forbidden" logger="UnhandledError"`,
+			want:   LogRecord{Time: time.Date(2020, 9, 18, 14, 3, 8, 1000, time.UTC), Level: "error", Source: "reflector.go:138", Message: "Failed to watch", Format: logFormatKlog},
+			fields: "err=forbidden logger=UnhandledError",
+		},
+		{
+			line: "W1231 23:59:59.5 7 x.go:1] last year",
+			want: LogRecord{Time: time.Date(2019, 12, 31, 23, 59, 59, 500000000, time.UTC), Level: "warning", Source: "x.go:1", Message: "last year", Format: logFormatKlog},
+		},
+		{
+			// The ] comes before the timestamp would end
+			line: "I0918 12:00:00.000] shutting down",
+			want: LogRecord{Time: time.Date(2020, 9, 18, 12, 0, 0, 0, time.UTC), Level: "info", Message: "shutting down", Format: logFormatKlog},
+		},
+		{
+			line:   `time=2020-09-18T14:03:07Z level=error msg="disk full" path=/var/lib caller=fs.go:9`,
+			want:   LogRecord{Time: time.Date(2020, 9, 18, 14, 3, 7, 0, time.UTC), Level: "error", Source: "fs.go:9", Message: "disk full", Format: logFormatLogfmt},
+			fields: "path=/var/lib",
+		},
+		{
+			line: `level=warn ts=1600437780 msg=slow retry`,
+			want: LogRecord{Time: time.Date(2020, 9, 18, 14, 3, 0, 0, time.UTC), Level: "warning", Message: "slow", Format: logFormatLogfmt},
+			// Bare keys are true
+			fields: "retry=true",
+		},
+		{
+			line:   `{"level":"info","ts":1600437780.123,"caller":"server/main.go:42","msg":"starting server","port":8080}`,
+			want:   LogRecord{Time: time.Date(2020, 9, 18, 14, 3, 0, 123000000, time.UTC), Level: "info", Source: "server/main.go:42", Message: "starting server", Format: logFormatJSON},
+			fields: "port=8080",
+		},
+		{
+			line: `{"severity":"ERROR","ts":"2020-09-18T14:03:05.5Z","message":"connection refused"}`,
+			want: LogRecord{Time: time.Date(2020, 9, 18, 14, 3, 5, 500000000, time.UTC), Level: "error", Message: "connection refused", Format: logFormatJSON},
+		},
+		{
+			line: `{"time":"2020-09-18T14:03:06Z","level":"WARN","source":{"function":"main.x","file":"/app/x.go","line":12},"msg":"slow"}`,
+			want: LogRecord{Time: time.Date(2020, 9, 18, 14, 3, 6, 0, time.UTC), Level: "warning", Source: "/app/x.go:12", Message: "slow", Format: logFormatJSON},
+		},
+		{
+			line: "2020-09-18T14:03:00Z ERROR something broke",
+			want: LogRecord{Time: time.Date(2020, 9, 18, 14, 3, 0, 0, time.UTC), Level: "error", Message: "2020-09-18T14:03:00Z ERROR something broke", Format: logFormatText},
+		},
+		{
+			line: "[warn] disk almost full",
+			want: LogRecord{Level: "warning", Message: "[warn] disk almost full", Format: logFormatText},
+		},
+		{
+			line: "goroutine 1 [running]:",
+			want: LogRecord{Message: "goroutine 1 [running]:", Format: logFormatText, Continuation: true},
+		},
+		{
+			line: "{not json",
+			want: LogRecord{Message: "{not json", Format: logFormatText, Continuation: true},
+		},
+	}
+
+	for _, test := range tests {
+		got := testLogParser.Parse([]byte(test.line))
+		if !got.Time.Equal(test.want.Time) || got.Level != test.want.Level || got.Source != test.want.Source ||
+			got.Message != test.want.Message || got.Format != test.want.Format || got.Continuation != test.want.Continuation {
+			t.Errorf("Parse(%q)\n got %+v\nwant %+v", test.line, got, test.want)
+		}
+		if fields := got.fieldsString(); fields != test.fields {
+			t.Errorf("Parse(%q) fields %q, want %q", test.line, fields, test.fields)
+		}
+
+		// ParseLevel must agree with Parse on what a level filter looks at
+		level := testLogParser.ParseLevel([]byte(test.line))
+		if level.Level != got.Level || level.Continuation != got.Continuation {
+			t.Errorf("ParseLevel(%q) = %q, continuation %v; Parse found %q, continuation %v", test.line, level.Level, level.Continuation, got.Level, got.Continuation)
+		}
+	}
+}
+
+func TestLogTimeParserKlogFractions(t *testing.T) {
+	for _, line := range []string{
+		"I0918 12:00:00.1 1 a.go:1] x",
+		"I0918 12:00:00.100 1 a.go:1] x",
+		"I0918 12:00:00.100000 1 a.go:1] x",
+		"I0918 12:00:00.100000000 1 a.go:1] x",
+	} {
+		got, ok := testLogParser.Times.Parse([]byte(line))
+		if want := time.Date(2020, 9, 18, 12, 0, 0, 100000000, time.UTC); !ok || !got.Equal(want) {
+			t.Errorf("Parse(%q) = %v, %v; want %v", line, got, ok, want)
+		}
+	}
+}
+
+func TestLogLevelFilter(t *testing.T) {
+	lines := []string{
+		"goroutine 0 [running]:",
+		"I0918 12:00:00.000 1 a.go:1] started",
+		"E0918 12:00:01.000 1 a.go:2] panic: boom",
+		"goroutine 1 [running]:",
+		"main.main()",
+		`{"level":"warn","msg":"slow"}`,
+		"level=fatal msg=dead",
+		"\tmain.go:3",
+		"2020-09-18T12:00:02Z plain line without a level",
+		"\tafter it",
+	}
+	tests := []struct {
+		level string
+		want  []int
+	}{
+		{"", []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{"info", []int{1, 2, 3, 4, 5, 6, 7}},
+		{"warning", []int{2, 3, 4, 5, 6, 7}},
+		{"error", []int{2, 3, 4, 6, 7}},
+		{"FATAL", []int{6, 7}},
+	}
+
+	for _, test := range tests {
+		for _, parse := range []func([]byte) LogRecord{testLogParser.Parse, testLogParser.ParseLevel} {
+			filter, err := newLogLevelFilter(test.level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []int
+			for i, line := range lines {
+				if filter.Keep(parse([]byte(line))) {
+					got = append(got, i)
+				}
+			}
+			if len(got) != len(test.want) {
+				t.Errorf("--level %q kept lines %v, want %v", test.level, got, test.want)
+				continue
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("--level %q kept lines %v, want %v", test.level, got, test.want)
+					break
+				}
+			}
+		}
+	}
+
+	if _, err := newLogLevelFilter("loud"); err == nil {
+		t.Errorf("newLogLevelFilter accepted an unknown level")
+	}
+}
@@ -0,0 +1,265 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// logTimeLayouts are the layouts of timestamps that start log lines, longest first
+var logTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05,999",
+	"2006/01/02 15:04:05.999999",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// logTimeFields are the fields JSON and logfmt log lines keep their timestamp in
+var logTimeFields = []string{"ts", "time", "timestamp", "@timestamp"}
+
+// logTimeParser : Parses the timestamps of log lines, which start them or are a field of JSON
+// and logfmt lines
+type logTimeParser struct {
+	// Reference is about when the logs were collected, which gives klog timestamps their year
+	Reference time.Time
+}
+
+// newLogTimeParser returns a parser for the logs of the bundle in bundleRootDir, collected
+// around the time of the latest node heartbeat or event in it. Without those, the reference is
+// fallback, or else the current time.
+func newLogTimeParser(bundleRootDir string, fallback time.Time) logTimeParser {
+	parser := logTimeParser{Reference: fallback}
+	if fallback.IsZero() {
+		parser.Reference = time.Now().UTC()
+	}
+	apiResourcesDir := findAPIResourcesDir(bundleRootDir)
+	if apiResourcesDir == "" {
+		return parser
+	}
+	resources := loadBundleResourceFiles(bundleRootDir, apiResourcesDir, func(resource string, group string) bool {
+		return group == "" && (resource == "nodes" || resource == "events")
+	})
+	if collectedAt := readBundleMetadata(resources).CollectedAt; !collectedAt.IsZero() {
+		parser.Reference = collectedAt
+	}
+	return parser
+}
+
+// Parse returns the timestamp of a log line, if it has one
+func (p logTimeParser) Parse(line []byte) (time.Time, bool) {
+	line = bytes.TrimLeft(line, " \t")
+	if len(line) == 0 {
+		return time.Time{}, false
+	}
+
+	switch {
+	case line[0] == '{':
+		for _, field := range logTimeFields {
+			if value, ok := jsonFieldValue(line, field); ok {
+				return parseTimeValue(value)
+			}
+		}
+		return time.Time{}, false
+	case isKlogHeader(line):
+		return p.parseKlogTime(line)
+	case len(line) >= 10 && isDigits(line[:4]) && (line[4] == '-' || line[4] == '/'):
+		return parseLeadingTime(line)
+	}
+
+	for _, field := range logTimeFields {
+		if value, ok := logfmtFieldValue(line, field); ok {
+			return parseTimeValue(value)
+		}
+	}
+	return time.Time{}, false
+}
+
+func isDigits(b []byte) bool {
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(b) > 0
+}
+
+// isKlogHeader reports whether line starts with a klog header, such as I0918 14:02:59.123456,
+// with any number of digits of fractional seconds
+func isKlogHeader(line []byte) bool {
+	return len(line) >= 16 && bytes.IndexByte([]byte("IWEF"), line[0]) >= 0 && isDigits(line[1:5]) &&
+		line[5] == ' ' && line[8] == ':' && line[11] == ':' && line[14] == '.' && isDigits(line[15:16])
+}
+
+// klogTimeEnd returns where the timestamp of a klog header ends, after the digits of its
+// fraction of a second
+func klogTimeEnd(line []byte) int {
+	end := 15
+	for end < len(line) && line[end] >= '0' && line[end] <= '9' {
+		end++
+	}
+	return end
+}
+
+// parseKlogTime parses the time of a klog header, which has no year: it is the year of the
+// reference time, or the year before for months after the reference's
+func (p logTimeParser) parseKlogTime(line []byte) (time.Time, bool) {
+	t, err := time.Parse("0102 15:04:05.999999999", string(line[1:klogTimeEnd(line)]))
+	if err != nil {
+		return time.Time{}, false
+	}
+	year := p.Reference.Year()
+	if t.Month() > p.Reference.Month() {
+		year--
+	}
+	return t.AddDate(year, 0, 0), true
+}
+
+// parseLeadingTime parses a timestamp starting line, taking its date and time fields
+func parseLeadingTime(line []byte) (time.Time, bool) {
+	fields := strings.SplitN(string(line[:minInt(len(line), 40)]), " ", 3)
+	candidates := []string{fields[0]}
+	if len(fields) > 1 {
+		candidates = append([]string{fields[0] + " " + fields[1]}, candidates...)
+	}
+	for _, candidate := range candidates {
+		for _, layout := range logTimeLayouts {
+			if t, err := time.Parse(layout, candidate); err == nil {
+				return t.UTC(), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseTimeValue parses the value of a timestamp field: a time string, or seconds or
+// milliseconds since the epoch
+func parseTimeValue(value string) (time.Time, bool) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds > 1e11 {
+			seconds /= 1000
+		}
+		// Floats keep about microseconds of a current time in seconds
+		return time.Unix(0, int64(math.Round(seconds*1e6))*1000).UTC(), true
+	}
+	for _, layout := range logTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// jsonFieldValue returns the value of a top level field of a JSON object, unquoted if it is a
+// string, without decoding the whole line
+func jsonFieldValue(line []byte, field string) (string, bool) {
+	key := []byte(`"` + field + `"`)
+	for offset := 0; ; {
+		i := bytes.Index(line[offset:], key)
+		if i < 0 {
+			return "", false
+		}
+		rest := bytes.TrimLeft(line[offset+i+len(key):], " \t")
+		offset += i + len(key)
+		if len(rest) == 0 || rest[0] != ':' {
+			// The key appeared as a value
+			continue
+		}
+		rest = bytes.TrimLeft(rest[1:], " \t")
+		if len(rest) > 0 && rest[0] == '"' {
+			end := 1
+			for end < len(rest) && rest[end] != '"' {
+				if rest[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(rest) {
+				return "", false
+			}
+			value, err := strconv.Unquote(string(rest[:end+1]))
+			return value, err == nil
+		}
+		end := bytes.IndexAny(rest, ",} \t")
+		if end < 0 {
+			end = len(rest)
+		}
+		return string(rest[:end]), end > 0
+	}
+}
+
+// logfmtFieldValue returns the value of a logfmt field, key=value or key="quoted value"
+func logfmtFieldValue(line []byte, field string) (string, bool) {
+	key := []byte(field + "=")
+	for offset := 0; ; {
+		i := bytes.Index(line[offset:], key)
+		if i < 0 {
+			return "", false
+		}
+		start := offset + i
+		offset = start + len(key)
+		if start > 0 && line[start-1] != ' ' && line[start-1] != '\t' {
+			continue
+		}
+		rest := line[offset:]
+		if len(rest) > 0 && rest[0] == '"' {
+			end := bytes.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return "", false
+			}
+			return string(rest[1 : end+1]), true
+		}
+		end := bytes.IndexAny(rest, " \t")
+		if end < 0 {
+			end = len(rest)
+		}
+		return string(rest[:end]), end > 0
+	}
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// timeArgLayouts are the layouts accepted for times given on the command line
+var timeArgLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseTimeArg parses a time given on the command line, in UTC unless it has a zone
+func parseTimeArg(value string) (time.Time, error) {
+	for _, layout := range timeArgLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q; expected e.g. 2020-09-18T14:03:00Z or 2020-09-18 14:03", value)
+}
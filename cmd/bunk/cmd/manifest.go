@@ -0,0 +1,275 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// manifestDir holds what bunk extract records about a bundle, apart from the .kbk dir of bunk up
+const manifestDir = ".bunk"
+
+// manifestFile is the manifest of a bundle, relative to its dir
+const manifestFile = manifestDir + "/manifest.json"
+
+// manifestVersion is the version of the manifest format bunk writes
+const manifestVersion = 1
+
+// Roles of the files in a bundle
+const (
+	roleAPIResources = "api-resources"
+	rolePodLogs      = "pods_logs"
+	// roleNode is data collected from a node, which Konvoy bundles as a nested archive per node
+	roleNode = "node"
+)
+
+// BundleManifest : Every file bunk extract extracted for a bundle, stored in .bunk/manifest.json
+type BundleManifest struct {
+	Version     int            `json:"version"`
+	Source      string         `json:"source"`
+	ExtractedAt time.Time      `json:"extractedAt"`
+	Files       []ManifestFile `json:"files"`
+}
+
+// ManifestFile : A file of a bundle, as extracted
+type ManifestFile struct {
+	// Path is slash separated and relative to the bundle dir
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	// Link is the target of a symlink, which has no size or checksum
+	Link string `json:"link,omitempty"`
+	// Archive is the innermost archive the file was extracted from, if any
+	Archive string `json:"archive,omitempty"`
+	Role    string `json:"role,omitempty"`
+}
+
+// isBundleMetadataDir reports whether rel, relative to the bundle dir, is a dir bunk keeps its
+// own files in rather than bundle content
+func isBundleMetadataDir(rel string) bool {
+	return rel == manifestDir || rel == ".kbk"
+}
+
+// readBundleManifest reads the manifest of the bundle in bundleRootDir; it returns nil without
+// error if there is none, as for bundles extracted by hand or by older versions of bunk
+func readBundleManifest(bundleRootDir string) (*BundleManifest, error) {
+	content, err := ioutil.ReadFile(filepath.Join(bundleRootDir, manifestFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest BundleManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+func writeBundleManifest(bundleRootDir string, manifest *BundleManifest) error {
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(bundleRootDir, manifestDir), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(bundleRootDir, manifestFile), content, 0644)
+}
+
+// hashFile returns the SHA-256 of the file at path, as hex
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fileContentType returns the content type of the file at path
+func fileContentType(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return GetFileContentType(f)
+}
+
+// manifestRole returns the role of the file at the slash separated path rel, going by the dirs
+// it is in
+func manifestRole(rel string) string {
+	for _, dir := range strings.Split(pathDir(rel), "/") {
+		switch dir {
+		case roleAPIResources:
+			return roleAPIResources
+		case rolePodLogs:
+			return rolePodLogs
+		}
+	}
+	return ""
+}
+
+// pathDir is path.Dir, but "" for files at the top
+func pathDir(rel string) string {
+	if i := strings.LastIndex(rel, "/"); i >= 0 {
+		return rel[:i]
+	}
+	return ""
+}
+
+// assignNodeRoles marks the files extracted from nested archives into top level dirs that hold
+// no api-resources or pods_logs as node data
+func assignNodeRoles(files []ManifestFile) {
+	kubeDirs := map[string]bool{}
+	for _, file := range files {
+		if file.Role != "" {
+			kubeDirs[strings.SplitN(file.Path, "/", 2)[0]] = true
+		}
+	}
+	for i, file := range files {
+		top := strings.SplitN(file.Path, "/", 2)
+		if file.Role == "" && file.Archive != "" && len(top) == 2 && !kubeDirs[top[0]] {
+			files[i].Role = roleNode
+		}
+	}
+}
+
+// buildBundleManifest lists every file in bundleRootDir, taking the sizes and checksums of the
+// files recorded while they were extracted from recorded, by path, and computing the rest
+func buildBundleManifest(bundleRootDir string, source string, recorded map[string]ManifestFile) (*BundleManifest, error) {
+	manifest := &BundleManifest{
+		Version:     manifestVersion,
+		Source:      source,
+		ExtractedAt: time.Now().UTC(),
+		Files:       []ManifestFile{},
+	}
+	err := filepath.Walk(bundleRootDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(bundleRootDir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			if isBundleMetadataDir(rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		file, ok := recorded[path]
+		file.Path = rel
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			if file.Link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			if !ok {
+				file.Size = info.Size()
+				if file.SHA256, err = hashFile(path); err != nil {
+					return err
+				}
+			}
+			if file.ContentType, err = fileContentType(path); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		file.Role = manifestRole(rel)
+		manifest.Files = append(manifest.Files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(manifest.Files, func(i, j int) bool { return manifest.Files[i].Path < manifest.Files[j].Path })
+	assignNodeRoles(manifest.Files)
+	return manifest, nil
+}
+
+// manifestRoleDir returns the dir holding the files of role in the bundle, as listed in its
+// manifest, or "" if it lists none
+func manifestRoleDir(bundleRootDir string, manifest *BundleManifest, role string) string {
+	for _, file := range manifest.Files {
+		if file.Role != role {
+			continue
+		}
+		// The role is named after the dir holding its files
+		parts := strings.Split(file.Path, "/")
+		for i := len(parts) - 2; i >= 0; i-- {
+			if parts[i] == role {
+				return filepath.Join(bundleRootDir, filepath.FromSlash(strings.Join(parts[:i+1], "/")))
+			}
+		}
+	}
+	return ""
+}
+
+// manifestFilesIn returns the files the manifest lists beneath dir with the extension ext,
+// sorted by path
+func manifestFilesIn(bundleRootDir string, manifest *BundleManifest, dir string, ext string) []string {
+	var files []string
+	for _, file := range manifest.Files {
+		path := filepath.Join(bundleRootDir, filepath.FromSlash(file.Path))
+		if file.Link == "" && filepath.Ext(path) == ext && withinDir(dir, path) {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// findBundleManifest returns the manifest of the bundle dir is in, if it has one, and the dir of
+// that bundle
+func findBundleManifest(dir string) (*BundleManifest, string) {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, manifestFile)); err == nil {
+			manifest, err := readBundleManifest(dir)
+			if err != nil || manifest == nil {
+				return nil, ""
+			}
+			return manifest, dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, ""
+		}
+		dir = parent
+	}
+}
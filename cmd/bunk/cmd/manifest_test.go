@@ -0,0 +1,124 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// sha256Hex returns the SHA-256 of content, as hex
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeTestManifestBundle writes a bundle with api-resources, pods_logs, files from a node
+// archive and bunk's own dirs, and returns it and the files recorded while it was "extracted"
+func writeTestManifestBundle(t *testing.T) (string, map[string]ManifestFile) {
+	t.Helper()
+	bundleRootDir := writeTestBundle(t, map[string]string{
+		"kube/api-resources/pods.yaml":         "items: []\n",
+		"kube/pods_logs/default_web_app.log":   "started\n",
+		"node1/logs/kubelet.log":               "kubelet started\n",
+		"README":                               "bundle\n",
+		manifestDir + "/previous.json":         "{}\n",
+		".kbk/state.json":                      "{}\n",
+		"kube/api-resources/nodes.yaml":        "items: []\n",
+		"kube/pods_logs/default_web_proxy.log": "",
+	})
+	if runtime.GOOS != "windows" {
+		if err := os.Symlink("pods.yaml", filepath.Join(bundleRootDir, "kube", "api-resources", "pods.current.yaml")); err != nil {
+			os.RemoveAll(bundleRootDir)
+			t.Fatal(err)
+		}
+	}
+	path := func(rel string) string { return filepath.Join(bundleRootDir, filepath.FromSlash(rel)) }
+	recorded := map[string]ManifestFile{
+		path("kube/api-resources/pods.yaml"):       {Size: 10, SHA256: sha256Hex("items: []\n"), Archive: "bundle.tar.gz"},
+		path("kube/pods_logs/default_web_app.log"): {Size: 8, SHA256: sha256Hex("started\n"), Archive: "bundle.tar.gz"},
+		path("node1/logs/kubelet.log"):             {Size: 16, SHA256: sha256Hex("kubelet started\n"), Archive: "bundle.tar.gz/bundles/node1.tar.gz"},
+		// Recorded as extracted, before anything changed it
+		path("README"): {Size: 7, SHA256: sha256Hex("BUNDLE\n"), Archive: "bundle.tar.gz"},
+	}
+	return bundleRootDir, recorded
+}
+
+func TestBuildBundleManifest(t *testing.T) {
+	bundleRootDir, recorded := writeTestManifestBundle(t)
+	defer os.RemoveAll(bundleRootDir)
+
+	manifest, err := buildBundleManifest(bundleRootDir, "/downloads/bundle.tar.gz", recorded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, file := range manifest.Files {
+		got = append(got, fmt.Sprintf("%s %d %.8s %s %s %s", file.Path, file.Size, file.SHA256, file.Link, file.Archive, file.Role))
+	}
+	want := []string{
+		// Recorded checksums are kept, so that changes made since extracting are caught
+		"README 7 " + sha256Hex("BUNDLE\n")[:8] + "  bundle.tar.gz ",
+		"kube/api-resources/nodes.yaml 10 " + sha256Hex("items: []\n")[:8] + "   api-resources",
+	}
+	if runtime.GOOS != "windows" {
+		want = append(want, "kube/api-resources/pods.current.yaml 0  pods.yaml  api-resources")
+	}
+	want = append(want,
+		"kube/api-resources/pods.yaml 10 "+sha256Hex("items: []\n")[:8]+"  bundle.tar.gz api-resources",
+		"kube/pods_logs/default_web_app.log 8 "+sha256Hex("started\n")[:8]+"  bundle.tar.gz pods_logs",
+		"kube/pods_logs/default_web_proxy.log 0 "+sha256Hex("")[:8]+"   pods_logs",
+		"node1/logs/kubelet.log 16 "+sha256Hex("kubelet started\n")[:8]+"  bundle.tar.gz/bundles/node1.tar.gz node",
+	)
+	if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", want) {
+		t.Errorf("manifest files =\n%q\nwant\n%q", got, want)
+	}
+	if manifest.Version != manifestVersion || manifest.Source != "/downloads/bundle.tar.gz" || manifest.ExtractedAt.IsZero() {
+		t.Errorf("manifest = %+v", manifest)
+	}
+
+	// The manifest is read back as written, and found from anywhere in the bundle
+	if err := writeBundleManifest(bundleRootDir, manifest); err != nil {
+		t.Fatal(err)
+	}
+	found, dir := findBundleManifest(filepath.Join(bundleRootDir, "kube", "pods_logs"))
+	if dir != bundleRootDir || found == nil || fmt.Sprint(found.Files) != fmt.Sprint(manifest.Files) {
+		t.Errorf("findBundleManifest = %v, %q; want the manifest written to %s", found, dir, bundleRootDir)
+	}
+	if found, dir := findBundleManifest(filepath.Dir(bundleRootDir)); found != nil {
+		t.Errorf("findBundleManifest outside the bundle found one in %s", dir)
+	}
+
+	if dir := manifestRoleDir(bundleRootDir, manifest, rolePodLogs); dir != filepath.Join(bundleRootDir, "kube", "pods_logs") {
+		t.Errorf("pods_logs dir = %q", dir)
+	}
+	if dir := manifestRoleDir(bundleRootDir, &BundleManifest{}, rolePodLogs); dir != "" {
+		t.Errorf("pods_logs dir of an empty manifest = %q; want none", dir)
+	}
+	// Symlinks are not listed as resource files
+	files := manifestFilesIn(bundleRootDir, manifest, filepath.Join(bundleRootDir, "kube", "api-resources"), ".yaml")
+	if fmt.Sprint(files) != fmt.Sprint([]string{
+		filepath.Join(bundleRootDir, "kube", "api-resources", "nodes.yaml"),
+		filepath.Join(bundleRootDir, "kube", "api-resources", "pods.yaml"),
+	}) {
+		t.Errorf("api-resources files = %q", files)
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// readOnlyUser is the view-only identity bunk hands out for replay clusters
+const readOnlyUser = "bunk-viewer"
+
+// readOnlyRole names the cluster role and binding that let readOnlyUser read everything
+const readOnlyRole = "bunk:read-only"
+
+// readOnlyTokenFile holds readOnlyUser's token in the db dir, so that the k3s server sees it
+// as k3sDBDir + readOnlyTokenFile
+const readOnlyTokenFile = "bunk-tokens.csv"
+
+// readOnlyAPIServerArg makes the API server authenticate readOnlyUser by its token
+const readOnlyAPIServerArg = "--kube-apiserver-arg=token-auth-file=" + k3sDBDir + readOnlyTokenFile
+
+// writeReadOnlyToken generates a token for readOnlyUser and writes it to the cluster's token file
+func writeReadOnlyToken(resourceDir string) error {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+
+	// The token is a bearer credential, readable by the current user only
+	dbDir := resourceDir + "/db"
+	if err := os.MkdirAll(dbDir, 0700); err != nil {
+		return err
+	}
+	if err := os.Chmod(dbDir, 0700); err != nil {
+		return err
+	}
+	line := fmt.Sprintf("%s,%s,%s\n", hex.EncodeToString(secret), readOnlyUser, readOnlyUser)
+	tokenPath := dbDir + "/" + readOnlyTokenFile
+	if err := ioutil.WriteFile(tokenPath, []byte(line), 0600); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of a token file left by an earlier run
+	return os.Chmod(tokenPath, 0600)
+}
+
+// readReadOnlyToken returns readOnlyUser's token from the cluster's token file
+func readReadOnlyToken(resourceDir string) (string, error) {
+	f, err := os.Open(resourceDir + "/db/" + readOnlyTokenFile)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return "", err
+	}
+	for _, record := range records {
+		if len(record) >= 2 && record[1] == readOnlyUser {
+			return record[0], nil
+		}
+	}
+	return "", fmt.Errorf("no token for %s in %s", readOnlyUser, f.Name())
+}
+
+// readOnlyRBACRows returns the cluster role and binding granting readOnlyUser get, list and
+// watch on every resource, as rows to load alongside the bundle's resources
+func readOnlyRBACRows(mapper *registryMapper) []KineRow {
+	metadata := map[string]interface{}{
+		"name":   readOnlyRole,
+		"labels": map[string]string{"app.kubernetes.io/managed-by": "bunk"},
+	}
+	objects := []struct {
+		resource string
+		object   map[string]interface{}
+	}{
+		{"clusterroles", map[string]interface{}{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "ClusterRole",
+			"metadata":   metadata,
+			"rules": []interface{}{
+				map[string]interface{}{"apiGroups": []string{"*"}, "resources": []string{"*"}, "verbs": []string{"get", "list", "watch"}},
+				map[string]interface{}{"nonResourceURLs": []string{"*"}, "verbs": []string{"get"}},
+			},
+		}},
+		{"clusterrolebindings", map[string]interface{}{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "ClusterRoleBinding",
+			"metadata":   metadata,
+			"roleRef":    map[string]string{"apiGroup": "rbac.authorization.k8s.io", "kind": "ClusterRole", "name": readOnlyRole},
+			"subjects":   []interface{}{map[string]string{"apiGroup": "rbac.authorization.k8s.io", "kind": "User", "name": readOnlyUser}},
+		}},
+	}
+
+	var rows []KineRow
+	for _, object := range objects {
+		prefix, _ := mapper.resolve(object.resource, "rbac.authorization.k8s.io")
+		if prefix == "" {
+			prefix = object.resource
+		}
+		value, _ := json.Marshal(object.object)
+		rows = append(rows, KineRow{
+			Key:    registryKey(prefix, "", readOnlyRole),
+			Value:  value,
+			File:   "bunk",
+			Object: strings.ToLower(object.object["kind"].(string)) + "/" + readOnlyRole,
+		})
+	}
+	return rows
+}
+
+// writeReadOnlyKubeconfig writes a copy of the admin kubeconfig at adminPath to path, with the
+// credentials of its current context's user replaced by readOnlyUser's token
+func writeReadOnlyKubeconfig(adminPath string, path string, token string) error {
+	config, err := readKubeconfig(adminPath)
+	if err != nil {
+		return err
+	}
+
+	currentContext, _ := config["current-context"].(string)
+	var context map[string]interface{}
+	for _, entry := range kubeconfigEntries(config, "contexts") {
+		if kubeconfigEntryName(entry) == currentContext {
+			fields, _ := entry.(map[string]interface{})
+			context, _ = fields["context"].(map[string]interface{})
+		}
+	}
+
+	replaced := false
+	for _, entry := range kubeconfigEntries(config, "users") {
+		if fields, ok := entry.(map[string]interface{}); ok && context != nil && kubeconfigEntryName(entry) == context["user"] {
+			fields["name"] = readOnlyUser
+			fields["user"] = map[string]interface{}{"token": token}
+			context["user"] = readOnlyUser
+			replaced = true
+		}
+	}
+	if !replaced {
+		return fmt.Errorf("kubeconfig %s has no user for its current context %q", adminPath, currentContext)
+	}
+	return writeKubeconfig(path, config)
+}
@@ -0,0 +1,167 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"testing"
+)
+
+func TestReadOnlyToken(t *testing.T) {
+	resourceDir, err := ioutil.TempDir("", "bunk-kbk-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(resourceDir)
+
+	if _, err := readReadOnlyToken(resourceDir); !os.IsNotExist(err) {
+		t.Errorf("readReadOnlyToken without a token file = %v; want it not to exist", err)
+	}
+
+	// A token file left readable by an earlier run is made private again
+	tokenPath := filepath.Join(resourceDir, "db", readOnlyTokenFile)
+	if err := os.MkdirAll(filepath.Dir(tokenPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(tokenPath, []byte("old,bunk-viewer,bunk-viewer\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var tokens []string
+	for i := 0; i < 2; i++ {
+		if err := writeReadOnlyToken(resourceDir); err != nil {
+			t.Fatal(err)
+		}
+		token, err := readReadOnlyToken(resourceDir)
+		if err != nil || !regexp.MustCompile(`^[0-9a-f]{64}$`).MatchString(token) {
+			t.Fatalf("readReadOnlyToken = %q, %v; want 32 random bytes in hex", token, err)
+		}
+		tokens = append(tokens, token)
+	}
+	if tokens[0] == tokens[1] {
+		t.Errorf("writeReadOnlyToken wrote the same token twice")
+	}
+
+	if runtime.GOOS != "windows" {
+		for path, want := range map[string]os.FileMode{filepath.Dir(tokenPath): 0700, tokenPath: 0600} {
+			if info, err := os.Stat(path); err != nil {
+				t.Error(err)
+			} else if info.Mode().Perm() != want {
+				t.Errorf("%s has mode %v; want %v", filepath.Base(path), info.Mode().Perm(), want)
+			}
+		}
+	}
+
+	if err := ioutil.WriteFile(tokenPath, []byte("token,admin,admin\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if token, err := readReadOnlyToken(resourceDir); err == nil {
+		t.Errorf("readReadOnlyToken without a bunk-viewer token = %q; want an error", token)
+	}
+}
+
+func TestReadOnlyRBACRows(t *testing.T) {
+	mapper, err := newRegistryMapper("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := readOnlyRBACRows(mapper)
+	want := map[string]string{
+		"clusterrole/bunk:read-only":        "/registry/clusterroles/bunk:read-only",
+		"clusterrolebinding/bunk:read-only": "/registry/clusterrolebindings/bunk:read-only",
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("readOnlyRBACRows returned %d rows; want %d", len(rows), len(want))
+	}
+	for _, row := range rows {
+		if want[row.Object] != row.Key {
+			t.Errorf("%s has key %s; want %s", row.Object, row.Key, want[row.Object])
+		}
+		var object struct {
+			Rules []struct {
+				Verbs []string
+			}
+			Subjects []struct {
+				Kind string
+				Name string
+			}
+		}
+		if err := json.Unmarshal(row.Value, &object); err != nil {
+			t.Errorf("%s: %v", row.Object, err)
+		}
+		// The role only ever reads
+		for _, rule := range object.Rules {
+			for _, verb := range rule.Verbs {
+				if verb != "get" && verb != "list" && verb != "watch" {
+					t.Errorf("%s grants %s", row.Object, verb)
+				}
+			}
+		}
+		for _, subject := range object.Subjects {
+			if subject.Kind != "User" || subject.Name != readOnlyUser {
+				t.Errorf("%s binds %s %s; want User %s", row.Object, subject.Kind, subject.Name, readOnlyUser)
+			}
+		}
+	}
+}
+
+func TestWriteReadOnlyKubeconfig(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "bunk-kubeconfig-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	adminPath := filepath.Join(tmp, "kubeconfig.yaml")
+	path := filepath.Join(tmp, "kubeconfig-readonly.yaml")
+	if err := ioutil.WriteFile(adminPath, []byte(testClusterKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeReadOnlyKubeconfig(adminPath, path, "view-token"); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := readKubeconfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := kubeconfigEntries(config, "users")
+	context := kubeconfigEntries(config, "contexts")[0].(map[string]interface{})["context"].(map[string]interface{})
+	if len(users) != 1 || kubeconfigEntryName(users[0]) != readOnlyUser || context["user"] != readOnlyUser {
+		t.Fatalf("read-only kubeconfig has users %v and context %v; want only %s", users, context, readOnlyUser)
+	}
+	// The admin token is gone, not just unused
+	user := users[0].(map[string]interface{})["user"].(map[string]interface{})
+	if len(user) != 1 || user["token"] != "view-token" {
+		t.Errorf("read-only user = %v; want only the view token", user)
+	}
+	if admin, err := readKubeconfig(adminPath); err != nil || kubeconfigEntryName(kubeconfigEntries(admin, "users")[0]) != "admin@k3d-bunk" {
+		t.Errorf("writeReadOnlyKubeconfig changed the admin kubeconfig: %v", err)
+	}
+
+	// A kubeconfig whose current context has no user cannot be made read-only
+	if err := ioutil.WriteFile(adminPath, []byte("apiVersion: v1\nkind: Config\ncurrent-context: missing\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeReadOnlyKubeconfig(adminPath, path, "view-token"); err == nil {
+		t.Errorf("writeReadOnlyKubeconfig without a user for the current context succeeded; want an error")
+	}
+}
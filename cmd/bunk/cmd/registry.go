@@ -0,0 +1,276 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/spf13/viper"
+)
+
+// RegistryMapping : Where the objects of an api-resources file are stored in the kine/etcd registry
+type RegistryMapping struct {
+	Resource string `mapstructure:"resource"`
+	Group    string `mapstructure:"group"`
+	// Prefix is the key prefix below /registry/; the namespace and name are appended to it
+	Prefix string `mapstructure:"prefix"`
+	// Skip is the reason the resource is not loaded, if it is not
+	Skip string `mapstructure:"skip"`
+	// Since and Until bound the Kubernetes minor versions, e.g. 1.16, whose apiserver serves the resource
+	Since string `mapstructure:"since"`
+	Until string `mapstructure:"until"`
+}
+
+// builtinRegistryMappings lists the registry prefixes of the built-in Kubernetes resources.
+// Built-in resources are stored without their group; resources of any other group are
+// assumed to be custom resources stored under /registry/<group>/<resource>/.
+var builtinRegistryMappings = []RegistryMapping{
+	// core
+	{Resource: "bindings", Skip: "bindings are not stored"},
+	{Resource: "componentstatuses", Skip: "component statuses are not stored"},
+	{Resource: "configmaps", Prefix: "configmaps"},
+	{Resource: "endpoints", Prefix: "services/endpoints"},
+	{Resource: "events", Prefix: "events"},
+	{Resource: "limitranges", Prefix: "limitranges"},
+	{Resource: "namespaces", Prefix: "namespaces"},
+	{Resource: "nodes", Prefix: "minions"},
+	{Resource: "persistentvolumeclaims", Prefix: "persistentvolumeclaims"},
+	{Resource: "persistentvolumes", Prefix: "persistentvolumes"},
+	{Resource: "pods", Prefix: "pods"},
+	{Resource: "podtemplates", Prefix: "podtemplates"},
+	{Resource: "replicationcontrollers", Prefix: "controllers"},
+	{Resource: "resourcequotas", Prefix: "resourcequotas"},
+	{Resource: "secrets", Prefix: "secrets"},
+	{Resource: "serviceaccounts", Prefix: "serviceaccounts"},
+	{Resource: "services", Prefix: "services/specs"},
+
+	// admissionregistration.k8s.io
+	{Resource: "mutatingwebhookconfigurations", Group: "admissionregistration.k8s.io", Prefix: "mutatingwebhookconfigurations"},
+	{Resource: "validatingwebhookconfigurations", Group: "admissionregistration.k8s.io", Prefix: "validatingwebhookconfigurations"},
+
+	// apiextensions.k8s.io and apiregistration.k8s.io keep their group in the key
+	{Resource: "customresourcedefinitions", Group: "apiextensions.k8s.io", Prefix: "apiextensions.k8s.io/customresourcedefinitions"},
+	{Resource: "apiservices", Group: "apiregistration.k8s.io", Prefix: "apiregistration.k8s.io/apiservices"},
+
+	// apps
+	{Resource: "controllerrevisions", Group: "apps", Prefix: "controllerrevisions"},
+	{Resource: "daemonsets", Group: "apps", Prefix: "daemonsets"},
+	{Resource: "deployments", Group: "apps", Prefix: "deployments"},
+	{Resource: "replicasets", Group: "apps", Prefix: "replicasets"},
+	{Resource: "statefulsets", Group: "apps", Prefix: "statefulsets"},
+
+	// authentication.k8s.io and authorization.k8s.io
+	{Resource: "tokenreviews", Group: "authentication.k8s.io", Skip: "reviews are not stored"},
+	{Resource: "localsubjectaccessreviews", Group: "authorization.k8s.io", Skip: "reviews are not stored"},
+	{Resource: "selfsubjectaccessreviews", Group: "authorization.k8s.io", Skip: "reviews are not stored"},
+	{Resource: "selfsubjectrulesreviews", Group: "authorization.k8s.io", Skip: "reviews are not stored"},
+	{Resource: "subjectaccessreviews", Group: "authorization.k8s.io", Skip: "reviews are not stored"},
+
+	// autoscaling
+	{Resource: "horizontalpodautoscalers", Group: "autoscaling", Prefix: "horizontalpodautoscalers"},
+
+	// batch
+	{Resource: "cronjobs", Group: "batch", Prefix: "cronjobs"},
+	{Resource: "jobs", Group: "batch", Prefix: "jobs"},
+
+	// certificates.k8s.io
+	{Resource: "certificatesigningrequests", Group: "certificates.k8s.io", Prefix: "certificatesigningrequests"},
+
+	// coordination.k8s.io
+	{Resource: "leases", Group: "coordination.k8s.io", Prefix: "leases"},
+
+	// discovery.k8s.io
+	{Resource: "endpointslices", Group: "discovery.k8s.io", Prefix: "endpointslices"},
+
+	// events.k8s.io shares storage with core events
+	{Resource: "events", Group: "events.k8s.io", Prefix: "events"},
+
+	// extensions
+	{Resource: "daemonsets", Group: "extensions", Prefix: "daemonsets", Until: "1.15"},
+	{Resource: "deployments", Group: "extensions", Prefix: "deployments", Until: "1.15"},
+	{Resource: "ingresses", Group: "extensions", Prefix: "ingress", Until: "1.21"},
+	{Resource: "networkpolicies", Group: "extensions", Prefix: "networkpolicies", Until: "1.15"},
+	{Resource: "podsecuritypolicies", Group: "extensions", Prefix: "podsecuritypolicy", Until: "1.15"},
+	{Resource: "replicasets", Group: "extensions", Prefix: "replicasets", Until: "1.15"},
+
+	// flowcontrol.apiserver.k8s.io
+	{Resource: "flowschemas", Group: "flowcontrol.apiserver.k8s.io", Prefix: "flowschemas", Since: "1.18"},
+	{Resource: "prioritylevelconfigurations", Group: "flowcontrol.apiserver.k8s.io", Prefix: "prioritylevelconfigurations", Since: "1.18"},
+
+	// metrics.k8s.io is served by metrics-server
+	{Resource: "nodes", Group: "metrics.k8s.io", Skip: "metrics are not stored"},
+	{Resource: "pods", Group: "metrics.k8s.io", Skip: "metrics are not stored"},
+
+	// networking.k8s.io
+	{Resource: "ingressclasses", Group: "networking.k8s.io", Prefix: "ingressclasses", Since: "1.18"},
+	{Resource: "ingresses", Group: "networking.k8s.io", Prefix: "ingress", Since: "1.14"},
+	{Resource: "networkpolicies", Group: "networking.k8s.io", Prefix: "networkpolicies"},
+
+	// node.k8s.io
+	{Resource: "runtimeclasses", Group: "node.k8s.io", Prefix: "runtimeclasses", Since: "1.14"},
+
+	// policy
+	{Resource: "poddisruptionbudgets", Group: "policy", Prefix: "poddisruptionbudgets"},
+	{Resource: "podsecuritypolicies", Group: "policy", Prefix: "podsecuritypolicy", Until: "1.24"},
+
+	// rbac.authorization.k8s.io
+	{Resource: "clusterrolebindings", Group: "rbac.authorization.k8s.io", Prefix: "clusterrolebindings"},
+	{Resource: "clusterroles", Group: "rbac.authorization.k8s.io", Prefix: "clusterroles"},
+	{Resource: "rolebindings", Group: "rbac.authorization.k8s.io", Prefix: "rolebindings"},
+	{Resource: "roles", Group: "rbac.authorization.k8s.io", Prefix: "roles"},
+
+	// scheduling.k8s.io
+	{Resource: "priorityclasses", Group: "scheduling.k8s.io", Prefix: "priorityclasses"},
+
+	// storage.k8s.io
+	{Resource: "csidrivers", Group: "storage.k8s.io", Prefix: "csidrivers", Since: "1.14"},
+	{Resource: "csinodes", Group: "storage.k8s.io", Prefix: "csinodes", Since: "1.14"},
+	{Resource: "csistoragecapacities", Group: "storage.k8s.io", Prefix: "csistoragecapacities", Since: "1.19"},
+	{Resource: "storageclasses", Group: "storage.k8s.io", Prefix: "storageclasses"},
+	{Resource: "volumeattachments", Group: "storage.k8s.io", Prefix: "volumeattachments"},
+}
+
+// builtinGroups is the set of api groups whose resources are stored without their group
+var builtinGroups = map[string]bool{}
+
+func init() {
+	for _, mapping := range builtinRegistryMappings {
+		builtinGroups[mapping.Group] = true
+	}
+	// Custom resources in these groups still keep their group in the key
+	delete(builtinGroups, "apiextensions.k8s.io")
+	delete(builtinGroups, "apiregistration.k8s.io")
+	delete(builtinGroups, "metrics.k8s.io")
+}
+
+// kubernetesMinorPattern matches the major and minor version of e.g. v1.18.6 or 1.18
+var kubernetesMinorPattern = regexp.MustCompile(`^v?(\d+)\.(\d+)`)
+
+// parseKubernetesMinor returns the minor version of a 1.x Kubernetes version, or -1 if it is empty or invalid
+func parseKubernetesMinor(version string) int {
+	m := kubernetesMinorPattern.FindStringSubmatch(version)
+	if m == nil || m[1] != "1" {
+		return -1
+	}
+	minor, _ := strconv.Atoi(m[2])
+	return minor
+}
+
+// registryMapper : Resolves api-resources files to registry prefixes for an apiserver version
+type registryMapper struct {
+	mappings []RegistryMapping
+	// minor is the apiserver's Kubernetes minor version, or -1 if unknown
+	minor int
+}
+
+// newRegistryMapper returns a mapper using the registry.mappings config, which take
+// precedence over the built-in mappings, for the given apiserver version
+func newRegistryMapper(apiserverVersion string) (*registryMapper, error) {
+	var overrides []RegistryMapping
+	if err := viper.UnmarshalKey("registry.mappings", &overrides); err != nil {
+		return nil, fmt.Errorf("invalid registry.mappings config: %v", err)
+	}
+	for _, mapping := range overrides {
+		if mapping.Resource == "" || (mapping.Prefix == "" && mapping.Skip == "") {
+			return nil, fmt.Errorf("invalid registry.mappings entry %+v: resource and one of prefix or skip are required", mapping)
+		}
+	}
+
+	minor := -1
+	if apiserverVersion != "" {
+		if minor = parseKubernetesMinor(apiserverVersion); minor < 0 {
+			return nil, fmt.Errorf("invalid apiserver version %q", apiserverVersion)
+		}
+	}
+
+	return &registryMapper{
+		mappings: append(overrides, builtinRegistryMappings...),
+		minor:    minor,
+	}, nil
+}
+
+// resolve returns the registry prefix for a resource and group, or the reason it is skipped
+func (m *registryMapper) resolve(resource string, group string) (string, string) {
+	for _, mapping := range m.mappings {
+		if mapping.Resource != resource || mapping.Group != group {
+			continue
+		}
+		if mapping.Skip != "" {
+			return "", mapping.Skip
+		}
+		if m.minor >= 0 {
+			if since := parseKubernetesMinor(mapping.Since); since >= 0 && m.minor < since {
+				return "", fmt.Sprintf("not served before Kubernetes %s", mapping.Since)
+			}
+			if until := parseKubernetesMinor(mapping.Until); until >= 0 && m.minor > until {
+				return "", fmt.Sprintf("not served after Kubernetes %s", mapping.Until)
+			}
+		}
+		return mapping.Prefix, ""
+	}
+
+	// Unknown resources of built-in groups are stored under their own name,
+	// and custom resources under their group and name
+	if builtinGroups[group] {
+		return resource, ""
+	}
+	return group + "/" + resource, ""
+}
+
+// registryKey returns the kine key of an object stored under prefix
+func registryKey(prefix string, namespace string, name string) string {
+	if namespace != "" {
+		return "/registry/" + prefix + "/" + namespace + "/" + name
+	}
+	return "/registry/" + prefix + "/" + name
+}
+
+// RegistryPlanEntry : How one api-resources file is loaded into the registry, or why it is skipped
+type RegistryPlanEntry struct {
+	File   string
+	Prefix string
+	// Objects is the number of objects to be loaded; Skipped those that are not, as they were already loaded from another file
+	Objects int
+	Skipped int
+	Skip    string
+}
+
+// printRegistryPlan prints the --explain-keys report
+func printRegistryPlan(plan []RegistryPlanEntry) {
+	loaded := [][]string{}
+	skipped := [][]string{}
+	for _, entry := range plan {
+		if entry.Skip != "" {
+			skipped = append(skipped, []string{filepath.Base(entry.File), entry.Skip})
+		} else {
+			loaded = append(loaded, []string{filepath.Base(entry.File), "/registry/" + entry.Prefix + "/", strconv.Itoa(entry.Objects)})
+		}
+	}
+
+	table := newPlainTable([]string{"File", "Registry Prefix", "Objects"})
+	table.AppendBulk(loaded)
+	table.Render()
+
+	if len(skipped) > 0 {
+		fmt.Println()
+		table = newPlainTable([]string{"Skipped File", "Reason"})
+		table.AppendBulk(skipped)
+		table.Render()
+	}
+}
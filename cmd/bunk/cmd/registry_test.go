@@ -0,0 +1,121 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestRegistryMapperResolve(t *testing.T) {
+	tests := []struct {
+		version   string
+		resource  string
+		group     string
+		namespace string
+		key       string
+		skip      string
+	}{
+		// Built-in resources stored under another name
+		{resource: "nodes", key: "/registry/minions/node-1"},
+		{resource: "services", namespace: "default", key: "/registry/services/specs/default/node-1"},
+		{resource: "endpoints", namespace: "default", key: "/registry/services/endpoints/default/node-1"},
+		{resource: "replicationcontrollers", namespace: "default", key: "/registry/controllers/default/node-1"},
+		{resource: "ingresses", group: "networking.k8s.io", namespace: "web", key: "/registry/ingress/web/node-1"},
+		{resource: "podsecuritypolicies", group: "policy", key: "/registry/podsecuritypolicy/node-1"},
+		{resource: "events", group: "events.k8s.io", namespace: "default", key: "/registry/events/default/node-1"},
+
+		// Built-in groups are left out of the key, apiextensions and apiregistration are not
+		{resource: "deployments", group: "apps", namespace: "default", key: "/registry/deployments/default/node-1"},
+		{resource: "customresourcedefinitions", group: "apiextensions.k8s.io", key: "/registry/apiextensions.k8s.io/customresourcedefinitions/node-1"},
+		{resource: "apiservices", group: "apiregistration.k8s.io", key: "/registry/apiregistration.k8s.io/apiservices/node-1"},
+
+		// Unknown resources of built-in groups, and custom resources
+		{resource: "widgets", group: "apps", namespace: "default", key: "/registry/widgets/default/node-1"},
+		{resource: "certificates", group: "cert-manager.io", namespace: "default", key: "/registry/cert-manager.io/certificates/default/node-1"},
+		{resource: "crontabs", group: "apiextensions.k8s.io", key: "/registry/apiextensions.k8s.io/crontabs/node-1"},
+		{resource: "podmetrics", group: "metrics.k8s.io", key: "/registry/metrics.k8s.io/podmetrics/node-1"},
+
+		// Resources that are never stored
+		{resource: "componentstatuses", skip: "component statuses are not stored"},
+		{resource: "pods", group: "metrics.k8s.io", skip: "metrics are not stored"},
+
+		// Since and Until are inclusive minor versions, and ignored if the version is unknown
+		{version: "v1.15.3", resource: "deployments", group: "extensions", namespace: "default", key: "/registry/deployments/default/node-1"},
+		{version: "v1.16.0", resource: "deployments", group: "extensions", skip: "not served after Kubernetes 1.15"},
+		{resource: "deployments", group: "extensions", namespace: "default", key: "/registry/deployments/default/node-1"},
+		{version: "1.17", resource: "flowschemas", group: "flowcontrol.apiserver.k8s.io", skip: "not served before Kubernetes 1.18"},
+		{version: "v1.18.6+k3s1", resource: "flowschemas", group: "flowcontrol.apiserver.k8s.io", key: "/registry/flowschemas/node-1"},
+		{version: "v1.21.1", resource: "ingresses", group: "extensions", namespace: "web", key: "/registry/ingress/web/node-1"},
+		{version: "v1.22.0", resource: "ingresses", group: "extensions", skip: "not served after Kubernetes 1.21"},
+	}
+
+	for _, test := range tests {
+		mapper, err := newRegistryMapper(test.version)
+		if err != nil {
+			t.Fatalf("newRegistryMapper(%q): %v", test.version, err)
+		}
+		prefix, skip := mapper.resolve(test.resource, test.group)
+		if skip != test.skip {
+			t.Errorf("%s.%s at %q skipped as %q; want %q", test.resource, test.group, test.version, skip, test.skip)
+			continue
+		}
+		if skip != "" {
+			continue
+		}
+		if key := registryKey(prefix, test.namespace, "node-1"); key != test.key {
+			t.Errorf("%s.%s at %q has key %s; want %s", test.resource, test.group, test.version, key, test.key)
+		}
+	}
+}
+
+func TestRegistryMapperOverrides(t *testing.T) {
+	defer viper.Set("registry.mappings", nil)
+	viper.Set("registry.mappings", []interface{}{
+		map[string]interface{}{"resource": "nodes", "prefix": "nodes"},
+		map[string]interface{}{"resource": "widgets", "group": "example.com", "prefix": "example.com/gadgets"},
+		map[string]interface{}{"resource": "leases", "group": "coordination.k8s.io", "skip": "leases expire"},
+		map[string]interface{}{"resource": "flowschemas", "group": "flowcontrol.apiserver.k8s.io", "prefix": "flowschemas", "since": "1.20"},
+	})
+
+	mapper, err := newRegistryMapper("v1.19.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct{ resource, group, prefix, skip string }{
+		{resource: "nodes", prefix: "nodes"},
+		{resource: "widgets", group: "example.com", prefix: "example.com/gadgets"},
+		{resource: "leases", group: "coordination.k8s.io", skip: "leases expire"},
+		{resource: "flowschemas", group: "flowcontrol.apiserver.k8s.io", skip: "not served before Kubernetes 1.20"},
+		// Resources without overrides keep their built-in mapping
+		{resource: "services", prefix: "services/specs"},
+	} {
+		if prefix, skip := mapper.resolve(test.resource, test.group); prefix != test.prefix || skip != test.skip {
+			t.Errorf("%s.%s resolved to %q, skip %q; want %q, skip %q", test.resource, test.group, prefix, skip, test.prefix, test.skip)
+		}
+	}
+
+	// Overrides must name a resource and either a prefix or a reason to skip it
+	viper.Set("registry.mappings", []interface{}{map[string]interface{}{"resource": "nodes"}})
+	if _, err := newRegistryMapper(""); err == nil {
+		t.Errorf("newRegistryMapper accepted a mapping without a prefix or skip")
+	}
+	viper.Set("registry.mappings", nil)
+	if _, err := newRegistryMapper("2.0"); err == nil {
+		t.Errorf("newRegistryMapper accepted apiserver version 2.0")
+	}
+}
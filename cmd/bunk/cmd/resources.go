@@ -0,0 +1,235 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// BundleObject : A single Kubernetes object loaded from an api-resources file
+type BundleObject struct {
+	KubernetesObject
+	// File is the api-resources file the object was read from
+	File string
+	// Raw is the JSON encoding of the full object
+	Raw []byte
+}
+
+// BundleResourceFile : All objects read from a single api-resources file
+type BundleResourceFile struct {
+	Path     string
+	Resource string
+	Group    string
+	Objects  []BundleObject
+}
+
+// BundleResources : Every Kubernetes object found in a bundle's api-resources dir
+type BundleResources struct {
+	BundleRootDir   string
+	APIResourcesDir string
+	Files           []*BundleResourceFile
+	// Invalid holds files that could not be parsed, keyed by path
+	Invalid map[string]error
+
+	podLogFiles []PodLogFile
+	podLogsRead bool
+}
+
+// Decode unmarshals the full object into v
+func (o BundleObject) Decode(v interface{}) error {
+	return json.Unmarshal(o.Raw, v)
+}
+
+// Ref returns a kind/namespace/name reference for the object
+func (o BundleObject) Ref() string {
+	kind := strings.ToLower(o.Kind)
+	if o.Metadata.Namespace != "" {
+		return fmt.Sprintf("%s/%s/%s", kind, o.Metadata.Namespace, o.Metadata.Name)
+	}
+	return fmt.Sprintf("%s/%s", kind, o.Metadata.Name)
+}
+
+// Objects returns every object loaded for a resource and group, e.g. ("deployments", "apps")
+func (r *BundleResources) Objects(resource string, group string) []BundleObject {
+	var objects []BundleObject
+	for _, file := range r.Files {
+		if file.Resource == resource && file.Group == group {
+			objects = append(objects, file.Objects...)
+		}
+	}
+	return objects
+}
+
+// PodLogFile returns the pods_logs file for a container, or "" if the bundle has none
+func (r *BundleResources) PodLogFile(namespace string, pod string, container string) string {
+	if !r.podLogsRead {
+		if podLogsDir := findPodLogsDir(r.BundleRootDir); podLogsDir != "" {
+			r.podLogFiles = findPodLogFiles(podLogsDir)
+		}
+		r.podLogsRead = true
+	}
+
+	var match string
+	for _, logFile := range r.podLogFiles {
+		if logFile.Namespace != namespace || logFile.Pod != pod {
+			continue
+		}
+		if logFile.Container == container {
+			return logFile.Path
+		}
+		// Fall back to a per-pod log file when there is no per-container one
+		if logFile.Container == "" {
+			match = logFile.Path
+		}
+	}
+	return match
+}
+
+// Count returns the total number of objects loaded
+func (r *BundleResources) Count() int {
+	count := 0
+	for _, file := range r.Files {
+		count += len(file.Objects)
+	}
+	return count
+}
+
+// parseResourceFileName splits an api-resources file name such as
+// deployments.apps.yaml into its resource name and api group
+func parseResourceFileName(basename string) (string, string) {
+	name := strings.TrimSuffix(basename, ".yaml")
+	parts := strings.SplitN(name, ".", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// findResourceFiles returns every yaml file beneath the api-resources dir
+func findResourceFiles(apiResourcesDir string) []string {
+	if manifest, bundleRootDir := findBundleManifest(apiResourcesDir); manifest != nil {
+		return manifestFilesIn(bundleRootDir, manifest, apiResourcesDir, ".yaml")
+	}
+
+	var files []string
+	err := filepath.Walk(apiResourcesDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if filepath.Ext(path) == ".yaml" {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// newBundleObject encodes a decoded yaml item as a BundleObject read from file
+func newBundleObject(file string, item interface{}) (BundleObject, error) {
+	buffer := &bytes.Buffer{}
+	enc := json.NewEncoder(buffer)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(item); err != nil {
+		return BundleObject{}, err
+	}
+
+	object := BundleObject{
+		File: file,
+		Raw:  bytes.TrimSuffix(buffer.Bytes(), []byte("\n")),
+	}
+	if err := json.Unmarshal(object.Raw, &object.KubernetesObject); err != nil {
+		return BundleObject{}, err
+	}
+	return object, nil
+}
+
+// readResourceFile parses an api-resources yaml file into individual objects
+func readResourceFile(file string) ([]BundleObject, error) {
+	if resource, group := parseResourceFileName(filepath.Base(file)); resource == "secrets" && group == "" {
+		return readSecretsFile(file)
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var kubernetesItems KubernetesItems
+	if err := yaml.Unmarshal(content, &kubernetesItems); err != nil {
+		return nil, err
+	}
+
+	objects := make([]BundleObject, 0, len(kubernetesItems.Items))
+	for _, item := range kubernetesItems.Items {
+		object, err := newBundleObject(file, item)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+
+	return objects, nil
+}
+
+// loadBundleResources reads every object in the api-resources dir into memory
+func loadBundleResources(bundleRootDir string, apiResourcesDir string) *BundleResources {
+	return loadBundleResourceFiles(bundleRootDir, apiResourcesDir, nil)
+}
+
+// loadBundleResourceFiles reads the objects of the api-resources files accepted by include, or all files if it is nil
+func loadBundleResourceFiles(bundleRootDir string, apiResourcesDir string, include func(resource string, group string) bool) *BundleResources {
+	resources := &BundleResources{
+		BundleRootDir:   bundleRootDir,
+		APIResourcesDir: apiResourcesDir,
+		Invalid:         map[string]error{},
+	}
+
+	for _, file := range findResourceFiles(apiResourcesDir) {
+		resource, group := parseResourceFileName(filepath.Base(file))
+		if include != nil && !include(resource, group) {
+			continue
+		}
+
+		objects, err := readResourceFile(file)
+		if err != nil {
+			resources.Invalid[file] = err
+			continue
+		}
+
+		resources.Files = append(resources.Files, &BundleResourceFile{
+			Path:     file,
+			Resource: resource,
+			Group:    group,
+			Objects:  objects,
+		})
+	}
+
+	return resources
+}
@@ -0,0 +1,215 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// redactedSecretValue replaces the value of every key of a restored secret
+const redactedSecretValue = "<redacted by bunk>"
+
+// redactedAnnotation marks secrets whose data bunk replaced with placeholders
+const redactedAnnotation = "bunk/redacted"
+
+// yamlIndicatorValuePattern matches mapping values and list items starting with a yaml
+// indicator character, as redacted bundles write e.g. `tls.key: **REDACTED**`
+var yamlIndicatorValuePattern = regexp.MustCompile("^(\\s*(?:-\\s+)?(?:[^\\s#'\"][^:]*:\\s+)?)([*&!%@`][^\\r\\n]*?)\\s*$")
+
+// yamlDocumentSeparator splits multi-document yaml files
+var yamlDocumentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// readSecretsFile parses the bundle's secrets file, which may not be valid yaml, and
+// returns its secrets with their data replaced by placeholders
+func readSecretsFile(file string) ([]BundleObject, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := parseSecrets(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []BundleObject
+	for _, item := range items {
+		object, err := newBundleObject(file, redactSecret(item))
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+// parseSecrets tries each format secrets are found in: a yaml list, a yaml list whose
+// redacted values are not valid yaml, multiple yaml documents, and a `kubectl get` table
+func parseSecrets(content []byte) ([]map[string]interface{}, error) {
+	if items, err := parseSecretDocuments(content); err == nil {
+		return items, nil
+	}
+
+	sanitized := quoteYAMLIndicatorValues(content)
+	items, err := parseSecretDocuments(sanitized)
+	if err == nil {
+		return items, nil
+	}
+
+	if items, tableErr := parseSecretTable(content); tableErr == nil {
+		return items, nil
+	}
+	return nil, err
+}
+
+// parseSecretDocuments parses one or more yaml documents holding lists or single secrets
+func parseSecretDocuments(content []byte) ([]map[string]interface{}, error) {
+	var items []map[string]interface{}
+	for _, document := range yamlDocumentSeparator.Split(string(content), -1) {
+		if strings.TrimSpace(document) == "" {
+			continue
+		}
+
+		var object map[string]interface{}
+		if err := yaml.Unmarshal([]byte(document), &object); err != nil {
+			return nil, err
+		}
+		if object == nil {
+			continue
+		}
+
+		list, isList := object["items"].([]interface{})
+		if !isList && object["items"] != nil {
+			return nil, fmt.Errorf("items is not a list")
+		}
+		if !isList {
+			if kind, _ := object["kind"].(string); kind == "Secret" {
+				items = append(items, object)
+			}
+			continue
+		}
+		for _, item := range list {
+			secret, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("secret list item is not an object")
+			}
+			items = append(items, secret)
+		}
+	}
+	return items, nil
+}
+
+// quoteYAMLIndicatorValues double-quotes values that start with a yaml indicator character
+func quoteYAMLIndicatorValues(content []byte) []byte {
+	var b strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(string(content)))
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := yamlIndicatorValuePattern.FindStringSubmatch(line); m != nil {
+			line = m[1] + strconv.Quote(m[2])
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	return []byte(b.String())
+}
+
+// parseSecretTable parses metadata-only `kubectl get secrets [-A]` output
+func parseSecretTable(content []byte) ([]map[string]interface{}, error) {
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	header := strings.Fields(lines[0])
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+	nameColumn, hasName := columns["NAME"]
+	typeColumn, hasType := columns["TYPE"]
+	if !hasName || !hasType {
+		return nil, fmt.Errorf("not a secrets table")
+	}
+	namespaceColumn, hasNamespace := columns["NAMESPACE"]
+
+	var items []map[string]interface{}
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) != len(header) {
+			return nil, fmt.Errorf("malformed secrets table row %q", line)
+		}
+		metadata := map[string]interface{}{"name": fields[nameColumn]}
+		if hasNamespace {
+			metadata["namespace"] = fields[namespaceColumn]
+		}
+		items = append(items, map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata":   metadata,
+			"type":       fields[typeColumn],
+		})
+	}
+	return items, nil
+}
+
+// redactSecret replaces every data and stringData value with a placeholder, keeping the
+// keys, type, labels, annotations and owner references intact
+func redactSecret(secret map[string]interface{}) map[string]interface{} {
+	placeholder := base64.StdEncoding.EncodeToString([]byte(redactedSecretValue))
+
+	data := map[string]interface{}{}
+	for _, field := range []string{"data", "stringData"} {
+		values, _ := secret[field].(map[string]interface{})
+		for key := range values {
+			data[key] = placeholder
+		}
+	}
+	delete(secret, "stringData")
+	if len(data) > 0 {
+		secret["data"] = data
+	} else {
+		delete(secret, "data")
+	}
+
+	if _, ok := secret["apiVersion"]; !ok {
+		secret["apiVersion"] = "v1"
+	}
+	if _, ok := secret["kind"]; !ok {
+		secret["kind"] = "Secret"
+	}
+
+	metadata, _ := secret["metadata"].(map[string]interface{})
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+		secret["metadata"] = metadata
+	}
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	if annotations == nil {
+		annotations = map[string]interface{}{}
+		metadata["annotations"] = annotations
+	}
+	// The last applied configuration holds a copy of the secret's data
+	delete(annotations, "kubectl.kubernetes.io/last-applied-configuration")
+	annotations[redactedAnnotation] = "true"
+
+	return secret
+}
@@ -0,0 +1,216 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// describeSecrets returns <namespace>/<name> <type> <sorted data keys> of each secret
+func describeSecrets(items []map[string]interface{}) []string {
+	var secrets []string
+	for _, item := range items {
+		metadata, _ := item["metadata"].(map[string]interface{})
+		data, _ := item["data"].(map[string]interface{})
+		var keys []string
+		for key := range data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		secrets = append(secrets, fmt.Sprintf("%v/%v %v %s", metadata["namespace"], metadata["name"], item["type"], strings.Join(keys, ",")))
+	}
+	return secrets
+}
+
+func TestParseSecrets(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{
+			name: "list",
+			content: `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Secret
+  metadata:
+    name: a
+    namespace: default
+  type: Opaque
+  data:
+    password: aHVudGVyMg==
+- apiVersion: v1
+  kind: Secret
+  metadata:
+    name: b
+    namespace: kube-system
+  type: kubernetes.io/tls
+  data:
+    tls.crt: Y2VydA==
+    tls.key: a2V5
+`,
+			want: []string{"default/a Opaque password", "kube-system/b kubernetes.io/tls tls.crt,tls.key"},
+		},
+		{
+			name: "redaction markers",
+			content: `items:
+- kind: Secret
+  metadata:
+    name: tls
+    namespace: default
+  type: kubernetes.io/tls
+  data:
+    tls.crt: **REDACTED**
+    tls.key: *** redacted ***
+- kind: Secret
+  metadata:
+    name: token
+    namespace: default
+  type: Opaque
+  data:
+    token: &redacted
+`,
+			want: []string{"default/tls kubernetes.io/tls tls.crt,tls.key", "default/token Opaque token"},
+		},
+		{
+			name: "documents",
+			content: `---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: a
+  namespace: default
+type: Opaque
+data:
+  x: eA==
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: not-a-secret
+---
+apiVersion: v1
+kind: SecretList
+items:
+- kind: Secret
+  metadata:
+    name: b
+    namespace: default
+  type: Opaque
+`,
+			want: []string{"default/a Opaque x", "default/b Opaque "},
+		},
+		{
+			name: "table across namespaces",
+			content: `NAMESPACE     NAME                  TYPE                                  DATA   AGE
+default       default-token-abcde   kubernetes.io/service-account-token   3      10d
+kube-system   registry              kubernetes.io/dockerconfigjson        1      2d
+`,
+			want: []string{"default/default-token-abcde kubernetes.io/service-account-token ", "kube-system/registry kubernetes.io/dockerconfigjson "},
+		},
+		{
+			name: "table of one namespace",
+			content: `NAME     TYPE     DATA   AGE
+app      Opaque   2      1h
+`,
+			want: []string{"<nil>/app Opaque "},
+		},
+	}
+
+	for _, test := range tests {
+		items, err := parseSecrets([]byte(test.content))
+		if err != nil {
+			t.Errorf("%s: parseSecrets returned %v", test.name, err)
+			continue
+		}
+		if got := describeSecrets(items); fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: parseSecrets = %q; want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseSecretsRejectsOtherContent(t *testing.T) {
+	for _, content := range []string{
+		"items: not-a-list\n",
+		"NAME  READY  STATUS\nfoo   1/1\n",
+		"items:\n- just a string\n",
+	} {
+		if items, err := parseSecrets([]byte(content)); err == nil {
+			t.Errorf("parseSecrets(%q) = %v; want an error", content, items)
+		}
+	}
+}
+
+func TestRedactSecret(t *testing.T) {
+	secret := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name": "app",
+			"annotations": map[string]interface{}{
+				"kubectl.kubernetes.io/last-applied-configuration": `{"data":{"password":"aHVudGVyMg=="}}`,
+				"owner": "team-a",
+			},
+			"labels": map[string]interface{}{"app": "web"},
+		},
+		"type":       "Opaque",
+		"data":       map[string]interface{}{"password": "aHVudGVyMg==", "user": "YWRtaW4="},
+		"stringData": map[string]interface{}{"token": "s3cr3t"},
+	}
+	redacted := redactSecret(secret)
+
+	placeholder := base64.StdEncoding.EncodeToString([]byte(redactedSecretValue))
+	data, _ := redacted["data"].(map[string]interface{})
+	if len(data) != 3 {
+		t.Errorf("redacted data = %v; want the keys password, user and token", data)
+	}
+	for _, key := range []string{"password", "user", "token"} {
+		if data[key] != placeholder {
+			t.Errorf("redacted data[%s] = %v; want %q", key, data[key], placeholder)
+		}
+	}
+	if _, ok := redacted["stringData"]; ok {
+		t.Errorf("redacted secret keeps stringData")
+	}
+	if redacted["apiVersion"] != "v1" || redacted["kind"] != "Secret" || redacted["type"] != "Opaque" {
+		t.Errorf("redacted secret has apiVersion %v, kind %v, type %v; want v1, Secret, Opaque", redacted["apiVersion"], redacted["kind"], redacted["type"])
+	}
+
+	metadata := redacted["metadata"].(map[string]interface{})
+	annotations := metadata["annotations"].(map[string]interface{})
+	if _, ok := annotations["kubectl.kubernetes.io/last-applied-configuration"]; ok {
+		t.Errorf("redacted secret keeps the last applied configuration")
+	}
+	if annotations[redactedAnnotation] != "true" || annotations["owner"] != "team-a" {
+		t.Errorf("redacted annotations = %v; want %s and owner kept", annotations, redactedAnnotation)
+	}
+	if labels := metadata["labels"].(map[string]interface{}); labels["app"] != "web" {
+		t.Errorf("redacted labels = %v; want app=web kept", labels)
+	}
+
+	// Secrets without data, e.g. from a table, get no data and are still marked
+	redacted = redactSecret(map[string]interface{}{"metadata": map[string]interface{}{"name": "empty"}})
+	if _, ok := redacted["data"]; ok {
+		t.Errorf("redacted secret without data has data %v", redacted["data"])
+	}
+	if annotations := redacted["metadata"].(map[string]interface{})["annotations"].(map[string]interface{}); annotations[redactedAnnotation] != "true" {
+		t.Errorf("redacted secret without data is not annotated %s", redactedAnnotation)
+	}
+}
@@ -0,0 +1,232 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxClusterNameLength keeps cluster names within k3d's limits
+const maxClusterNameLength = 32
+
+// Phases of bunk up, in order; BundleState.Phase is the last one completed
+const (
+	phaseResourcesRead   = "resources-read"
+	phaseResourcesLoaded = "resources-loaded"
+	phaseClusterStarted  = "cluster-started"
+)
+
+// BundleState : What bunk knows about a bundle's replay cluster, stored in .kbk/state.json
+type BundleState struct {
+	ClusterName string            `json:"clusterName"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	Phase       string            `json:"phase,omitempty"`
+	Files       []BundleStateFile `json:"files,omitempty"`
+}
+
+// BundleStateFile : How many objects of an api-resources file were loaded into the cluster
+type BundleStateFile struct {
+	File    string `json:"file"`
+	Prefix  string `json:"prefix,omitempty"`
+	Loaded  int    `json:"loaded"`
+	Skipped int    `json:"skipped"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// invalidClusterNameChars matches runs of characters not allowed in cluster names
+var invalidClusterNameChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// bundleClusterName derives a cluster name from the ticket and bundle dirs of a bundle
+// extracted to <tickets dir>/<ticket>/bundle-<name>
+func bundleClusterName(bundleRootDir string) string {
+	bundle := strings.TrimPrefix(filepath.Base(bundleRootDir), "bundle-")
+	ticket := filepath.Base(filepath.Dir(bundleRootDir))
+
+	name := invalidClusterNameChars.ReplaceAllString(strings.ToLower("bunk-"+ticket+"-"+bundle), "-")
+	name = strings.Trim(name, "-")
+	if len(name) > maxClusterNameLength {
+		// Keep names unique when truncating by appending a hash of the full path
+		sum := sha1.Sum([]byte(bundleRootDir))
+		name = strings.TrimRight(name[:maxClusterNameLength-9], "-") + "-" + hex.EncodeToString(sum[:])[:8]
+	}
+	return name
+}
+
+// bundleStateFiles records how many objects of each planned file were loaded, given the rows that failed
+func bundleStateFiles(bundleRootDir string, plan []RegistryPlanEntry, failures []KineRowError) []BundleStateFile {
+	failed := map[string]int{}
+	for _, failure := range failures {
+		failed[failure.Row.File]++
+	}
+
+	var files []BundleStateFile
+	for _, entry := range plan {
+		file, err := filepath.Rel(bundleRootDir, entry.File)
+		if err != nil {
+			file = entry.File
+		}
+		files = append(files, BundleStateFile{
+			File:    file,
+			Prefix:  entry.Prefix,
+			Loaded:  entry.Objects - failed[entry.File],
+			Skipped: entry.Skipped + failed[entry.File],
+			Reason:  entry.Skip,
+		})
+	}
+	return files
+}
+
+// readBundleState reads .kbk/state.json; it returns nil without error if there is none
+func readBundleState(resourceDir string) (*BundleState, error) {
+	content, err := ioutil.ReadFile(resourceDir + "/state.json")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state BundleState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, err
+	}
+	if state.Phase == "" && len(state.Files) > 0 {
+		// Written before phases were recorded, by an up that completed
+		state.Phase = phaseClusterStarted
+	}
+	return &state, nil
+}
+
+// writeBundleState writes .kbk/state.json
+func writeBundleState(resourceDir string, state *BundleState) error {
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(resourceDir+"/state.json", append(content, '\n'), 0664)
+}
+
+// bundleClusterState returns the name of the bundle's cluster, as recorded in its state, or as
+// bunk up would name it if it has none, and the state; it needs no k3d
+func bundleClusterState(bundleRootDir string) (string, *BundleState, error) {
+	state, err := readBundleState(bundleRootDir + "/.kbk")
+	if err != nil {
+		return "", nil, err
+	}
+
+	name := bundleClusterName(bundleRootDir)
+	if state != nil && state.ClusterName != "" {
+		name = state.ClusterName
+	}
+	return name, state, nil
+}
+
+// bundleClusterProvider returns the provider for the bundle's cluster, named as recorded in
+// its state, or as bunk up would name it if it has none
+func bundleClusterProvider(bundleRootDir string) (ClusterProvider, *BundleState, error) {
+	name, state, err := bundleClusterState(bundleRootDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	provider, err := newClusterProvider(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return provider, state, nil
+}
+
+// bundleRegistryPath returns the file under the tickets dir where bunk up records the root dirs
+// of the bundles it replays, so that bunk status --all also finds bundles extracted elsewhere
+// with --dest
+func bundleRegistryPath() string {
+	return filepath.Join(getTicketsDir(), ".bunk-bundles.json")
+}
+
+// readBundleRegistry returns the bundle root dirs recorded by bunk up; it returns none without
+// error if there are none
+func readBundleRegistry() ([]string, error) {
+	content, err := ioutil.ReadFile(bundleRegistryPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var bundleRootDirs []string
+	if err := json.Unmarshal(content, &bundleRootDirs); err != nil {
+		return nil, err
+	}
+	return bundleRootDirs, nil
+}
+
+// registerBundle records bundleRootDir in the bundle registry, dropping the recorded dirs that
+// no longer exist
+func registerBundle(bundleRootDir string) error {
+	bundleRootDir, err := filepath.Abs(bundleRootDir)
+	if err != nil {
+		return err
+	}
+	recorded, err := readBundleRegistry()
+	if err != nil {
+		return err
+	}
+
+	bundleRootDirs := []string{bundleRootDir}
+	for _, dir := range recorded {
+		if dir == bundleRootDir {
+			continue
+		}
+		if _, err := os.Stat(dir); err == nil {
+			bundleRootDirs = append(bundleRootDirs, dir)
+		}
+	}
+
+	content, err := json.MarshalIndent(bundleRootDirs, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := bundleRegistryPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Write through a temp file so that a concurrent bunk status never reads half a registry
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".bunk-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(content, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0664); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
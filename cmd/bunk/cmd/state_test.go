@@ -0,0 +1,225 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestBundleClusterName(t *testing.T) {
+	tests := []struct {
+		bundleRootDir string
+		want          string
+	}{
+		{"/home/me/tickets/12345/bundle-a", "bunk-12345-a"},
+		{"/home/me/tickets/12345/a", "bunk-12345-a"},
+		{"/home/me/tickets/ACME-42/bundle-Prod_Cluster.2020", "bunk-acme-42-prod-cluster-2020"},
+		{"/home/me/tickets/12345/--bundle--", "bunk-12345-bundle"},
+		// 32 characters are kept as they are
+		{"/home/me/tickets/12345/bundle-abcdefghijklmnopqrstu", "bunk-12345-abcdefghijklmnopqrstu"},
+	}
+	for _, test := range tests {
+		if got := bundleClusterName(test.bundleRootDir); got != test.want {
+			t.Errorf("bundleClusterName(%q) = %q; want %q", test.bundleRootDir, got, test.want)
+		}
+	}
+
+	// Longer names are truncated and kept apart by a hash of the full path
+	hashed := regexp.MustCompile(`^bunk-12345-abcdefghijkl-[0-9a-f]{8}$`)
+	long := []string{
+		"/home/me/tickets/12345/bundle-abcdefghijklmnopqrstuv",
+		"/home/me/tickets/12345/bundle-abcdefghijklmnopqrstuvwxyz",
+		"/tmp/12345/bundle-abcdefghijklmnopqrstuvwxyz",
+	}
+	names := map[string]bool{}
+	for _, bundleRootDir := range long {
+		name := bundleClusterName(bundleRootDir)
+		if !hashed.MatchString(name) || len(name) != maxClusterNameLength {
+			t.Errorf("bundleClusterName(%q) = %q; want it truncated to %d characters ending in a hash", bundleRootDir, name, maxClusterNameLength)
+		}
+		if bundleClusterName(bundleRootDir) != name {
+			t.Errorf("bundleClusterName(%q) changed between calls", bundleRootDir)
+		}
+		names[name] = true
+	}
+	if len(names) != len(long) {
+		t.Errorf("bundleClusterName gave %d names for %d bundles: %v", len(names), len(long), names)
+	}
+
+	// No trailing dash is left where the name was cut
+	if name := bundleClusterName("/t/12345/bundle-abcdefghijk-mnopqrstuvwxyz"); !regexp.MustCompile(`^bunk-12345-abcdefghijk-[0-9a-f]{8}$`).MatchString(name) {
+		t.Errorf("bundleClusterName cut at a dash = %q", name)
+	}
+}
+
+func TestBundleState(t *testing.T) {
+	bundleRootDir, err := ioutil.TempDir("", "bunk-bundle-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(bundleRootDir)
+	resourceDir := filepath.Join(bundleRootDir, ".kbk")
+	if err := os.Mkdir(resourceDir, 0774); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without state, the cluster is named as bunk up would name it
+	name, state, err := bundleClusterState(bundleRootDir)
+	if err != nil || state != nil || name != bundleClusterName(bundleRootDir) {
+		t.Errorf("bundleClusterState without state = %q, %v, %v; want %q", name, state, err, bundleClusterName(bundleRootDir))
+	}
+
+	// With state, by the name it was created with, even if the bundle was moved since
+	createdAt := time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)
+	if err := writeBundleState(resourceDir, &BundleState{ClusterName: "bunk-12345-a", CreatedAt: createdAt, Phase: phaseClusterStarted}); err != nil {
+		t.Fatal(err)
+	}
+	name, state, err = bundleClusterState(bundleRootDir)
+	if err != nil || name != "bunk-12345-a" || state == nil || !state.CreatedAt.Equal(createdAt) || state.Phase != phaseClusterStarted {
+		t.Errorf("bundleClusterState = %q, %+v, %v; want the recorded cluster bunk-12345-a", name, state, err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(resourceDir, "state.json"), []byte("{"), 0664); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := bundleClusterState(bundleRootDir); err == nil {
+		t.Errorf("bundleClusterState with invalid state.json succeeded; want an error")
+	}
+}
+
+func TestRegisterBundle(t *testing.T) {
+	ticketsDir, err := ioutil.TempDir("", "bunk-tickets-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(ticketsDir)
+	defer viper.Set("ticketsDir", nil)
+	viper.Set("ticketsDir", ticketsDir)
+
+	if recorded, err := readBundleRegistry(); err != nil || recorded != nil {
+		t.Errorf("readBundleRegistry without a registry = %q, %v; want none", recorded, err)
+	}
+
+	var bundleRootDirs []string
+	for _, name := range []string{"a", "b", "c"} {
+		dir := filepath.Join(ticketsDir, "elsewhere", "bundle-"+name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		bundleRootDirs = append(bundleRootDirs, dir)
+	}
+
+	// The latest bundle comes first, and bundles registered again are not repeated
+	for _, dir := range []string{bundleRootDirs[0], bundleRootDirs[1], bundleRootDirs[0], bundleRootDirs[2]} {
+		if err := registerBundle(dir); err != nil {
+			t.Fatalf("registerBundle(%q): %v", dir, err)
+		}
+	}
+	want := []string{bundleRootDirs[2], bundleRootDirs[0], bundleRootDirs[1]}
+	if recorded, err := readBundleRegistry(); err != nil || fmt.Sprint(recorded) != fmt.Sprint(want) {
+		t.Errorf("bundle registry = %q, %v; want %q", recorded, err, want)
+	}
+
+	// Bundles that were removed are dropped the next time one is registered
+	if err := os.RemoveAll(bundleRootDirs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := registerBundle(bundleRootDirs[1]); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{bundleRootDirs[1], bundleRootDirs[2]}
+	if recorded, err := readBundleRegistry(); err != nil || fmt.Sprint(recorded) != fmt.Sprint(want) {
+		t.Errorf("bundle registry after removing %s = %q, %v; want %q", bundleRootDirs[0], recorded, err, want)
+	}
+
+	// Relative dirs are recorded as absolute ones
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(filepath.Dir(bundleRootDirs[1])); err != nil {
+		t.Fatal(err)
+	}
+	if err := registerBundle("bundle-c"); err != nil {
+		t.Fatal(err)
+	}
+	if recorded, err := readBundleRegistry(); err != nil || len(recorded) != 2 || !filepath.IsAbs(recorded[0]) || filepath.Base(recorded[0]) != "bundle-c" {
+		t.Errorf("bundle registry after registering bundle-c = %q, %v", recorded, err)
+	}
+
+	// No temp files are left behind
+	if entries, err := ioutil.ReadDir(ticketsDir); err != nil || len(entries) != 2 {
+		t.Errorf("tickets dir has %d entries, %v; want elsewhere and %s", len(entries), err, filepath.Base(bundleRegistryPath()))
+	}
+
+	if err := ioutil.WriteFile(bundleRegistryPath(), []byte("{"), 0664); err != nil {
+		t.Fatal(err)
+	}
+	if err := registerBundle(bundleRootDirs[1]); err == nil {
+		t.Errorf("registerBundle with an invalid registry succeeded; want an error")
+	}
+}
+
+func TestReadBundleStatePhase(t *testing.T) {
+	resourceDir, err := ioutil.TempDir("", "bunk-kbk-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(resourceDir)
+
+	for _, test := range []struct {
+		state string
+		want  string
+	}{
+		{`{"clusterName": "bunk-12345-a", "phase": "resources-read"}`, phaseResourcesRead},
+		// Written before phases were recorded; files were only recorded by an up that completed
+		{`{"clusterName": "bunk-12345-a", "files": [{"file": "api-resources/pods.yaml", "loaded": 1}]}`, phaseClusterStarted},
+		{`{"clusterName": "bunk-12345-a"}`, ""},
+	} {
+		if err := ioutil.WriteFile(filepath.Join(resourceDir, "state.json"), []byte(test.state), 0664); err != nil {
+			t.Fatal(err)
+		}
+		state, err := readBundleState(resourceDir)
+		if err != nil || state.Phase != test.want {
+			t.Errorf("readBundleState(%s) = %+v, %v; want phase %q", test.state, state, err, test.want)
+		}
+	}
+}
+
+func TestBundleStateFiles(t *testing.T) {
+	plan := []RegistryPlanEntry{
+		{File: "/b/api-resources/pods.yaml", Prefix: "pods", Objects: 3, Skipped: 1},
+		{File: "/b/api-resources/componentstatuses.yaml", Skip: "component statuses are not stored"},
+		{File: "/elsewhere/nodes.yaml", Prefix: "minions", Objects: 1},
+	}
+	failures := []KineRowError{
+		{Row: KineRow{File: "/b/api-resources/pods.yaml"}},
+		{Row: KineRow{File: "/b/api-resources/pods.yaml"}},
+	}
+	want := "[{api-resources/pods.yaml pods 1 3 } {api-resources/componentstatuses.yaml  0 0 component statuses are not stored} {../elsewhere/nodes.yaml minions 1 0 }]"
+	if got := bundleStateFiles("/b", plan, failures); fmt.Sprint(got) != want {
+		t.Errorf("bundleStateFiles = %v; want %s", got, want)
+	}
+}
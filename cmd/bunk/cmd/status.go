@@ -16,26 +16,348 @@ limitations under the License.
 package cmd
 
 import (
+	"database/sql"
 	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
 )
 
 // statusCmd represents the status command
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Display kbk cluster information",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
+	Long: `Display the replay cluster of the bundle in the current directory: whether it
+is running, what was loaded into its database and the bundle's own metadata.
 
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+With --all, list every bundle with a running cluster, under the tickets dir or
+started with bunk up from anywhere else.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("status called")
+		all, _ := cmd.Flags().GetBool("all")
+		if all {
+			statusAll()
+		} else {
+			status()
+		}
 	},
 }
 
+// BundleMetadata : What a bundle says about the cluster it was collected from
+type BundleMetadata struct {
+	ClusterName       string
+	KubernetesVersion string
+	// CollectedAt is approximated by the latest node heartbeat or event in the bundle
+	CollectedAt time.Time
+}
+
+// readBundleMetadata reads the cluster name and version from the kubeadm-config configmap,
+// falling back to the kubelet version of the nodes
+func readBundleMetadata(resources *BundleResources) BundleMetadata {
+	var metadata BundleMetadata
+
+	for _, object := range resources.Objects("configmaps", "") {
+		if object.Metadata.Namespace != "kube-system" || object.Metadata.Name != "kubeadm-config" {
+			continue
+		}
+		var configMap struct {
+			Data map[string]string `json:"data"`
+		}
+		if err := object.Decode(&configMap); err != nil {
+			continue
+		}
+		var clusterConfiguration struct {
+			ClusterName       string `json:"clusterName"`
+			KubernetesVersion string `json:"kubernetesVersion"`
+		}
+		if err := yaml.Unmarshal([]byte(configMap.Data["ClusterConfiguration"]), &clusterConfiguration); err == nil {
+			metadata.ClusterName = clusterConfiguration.ClusterName
+			metadata.KubernetesVersion = clusterConfiguration.KubernetesVersion
+		}
+	}
+
+	for _, object := range resources.Objects("nodes", "") {
+		var node struct {
+			Status struct {
+				Conditions []struct {
+					LastHeartbeatTime time.Time `json:"lastHeartbeatTime"`
+				} `json:"conditions"`
+				NodeInfo struct {
+					KubeletVersion string `json:"kubeletVersion"`
+				} `json:"nodeInfo"`
+			} `json:"status"`
+		}
+		if err := object.Decode(&node); err != nil {
+			continue
+		}
+		if metadata.KubernetesVersion == "" {
+			metadata.KubernetesVersion = node.Status.NodeInfo.KubeletVersion
+		}
+		for _, condition := range node.Status.Conditions {
+			if condition.LastHeartbeatTime.After(metadata.CollectedAt) {
+				metadata.CollectedAt = condition.LastHeartbeatTime
+			}
+		}
+	}
+
+	for _, object := range resources.Objects("events", "") {
+		var event struct {
+			LastTimestamp time.Time `json:"lastTimestamp"`
+		}
+		if err := object.Decode(&event); err == nil && event.LastTimestamp.After(metadata.CollectedAt) {
+			metadata.CollectedAt = event.LastTimestamp
+		}
+	}
+
+	return metadata
+}
+
+// registryPrefixOf returns the longest of prefixes that key is stored under, falling back
+// to the key's first segment, or first two for custom resources
+func registryPrefixOf(key string, prefixes []string) string {
+	key = strings.TrimPrefix(key, "/registry/")
+	best := ""
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix+"/") && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best != "" {
+		return best
+	}
+
+	segments := strings.Split(key, "/")
+	if len(segments) > 2 && strings.Contains(segments[0], ".") {
+		return segments[0] + "/" + segments[1]
+	}
+	return segments[0]
+}
+
+// countKineRows counts the live objects in a kine database by registry prefix
+func countKineRows(dbPath string, prefixes []string) (map[string]int, error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, err
+	}
+
+	// Fall back to ignoring the write-ahead log if it belongs to another user
+	var names []string
+	var err error
+	for _, options := range []string{"mode=ro", "mode=ro&immutable=1"} {
+		names, err = queryKineNames("file:" + dbPath + "?" + options)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for _, name := range names {
+		if strings.HasPrefix(name, "/registry/") {
+			counts[registryPrefixOf(name, prefixes)]++
+		}
+	}
+	return counts, nil
+}
+
+// queryKineNames returns the key of every live object in the kine database at dsn
+func queryKineNames(dsn string) ([]string, error) {
+	database, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer database.Close()
+
+	rows, err := database.Query("SELECT name FROM kine WHERE deleted = 0 AND id IN (SELECT MAX(id) FROM kine GROUP BY name)")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func status() {
+	bundleRootDir := getBundleRootDir()
+	resourceDir := bundleRootDir + "/.kbk"
+
+	green := color.New(color.FgGreen).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	fmt.Printf("Bundle:       %s\n", bundleRootDir)
+	fmt.Printf("Ticket:       %s\n", filepath.Base(filepath.Dir(bundleRootDir)))
+
+	if apiResourcesDir := findAPIResourcesDir(bundleRootDir); apiResourcesDir != "" {
+		resources := loadBundleResourceFiles(bundleRootDir, apiResourcesDir, func(resource string, group string) bool {
+			return group == "" && (resource == "configmaps" || resource == "nodes" || resource == "events")
+		})
+		metadata := readBundleMetadata(resources)
+		fmt.Printf("Source:       cluster %s, Kubernetes %s\n", valueOrUnknown(metadata.ClusterName), valueOrUnknown(metadata.KubernetesVersion))
+		if !metadata.CollectedAt.IsZero() {
+			fmt.Printf("Collected:    %s (approx., latest node heartbeat or event)\n", metadata.CollectedAt.Local().Format(time.RFC1123))
+		}
+	}
+
+	if _, err := os.Stat(resourceDir); os.IsNotExist(err) {
+		fmt.Printf("Resources:    %s\n", yellow("no .kbk dir; create a cluster with `bunk up`"))
+		return
+	}
+	fmt.Printf("Resources:    %s\n", resourceDir)
+
+	name, state, err := bundleClusterState(bundleRootDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if state == nil {
+		fmt.Printf("Cluster:      %s\n", yellow("no cluster state recorded; recreate it with `bunk down && bunk up`"))
+		return
+	}
+	provider, err := newClusterProvider(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	running, err := provider.Running()
+	if err != nil {
+		log.Fatal(err)
+	}
+	clusterStatus := yellow("stopped")
+	if running {
+		clusterStatus = green("running")
+	}
+	fmt.Printf("Cluster:      %s (%s)\n", provider.Name(), clusterStatus)
+	fmt.Printf("Created:      %s (%s ago)\n", state.CreatedAt.Local().Format(time.RFC1123), time.Since(state.CreatedAt).Round(time.Second))
+	if state.Phase != phaseClusterStarted {
+		fmt.Printf("Phase:        %s\n", yellow(fmt.Sprintf("bunk up stopped after phase %s; run it again to resume", valueOrUnknown(state.Phase))))
+	}
+
+	dbPath := resourceDir + "/db/state.db"
+	fmt.Printf("Database:     %s\n", dbPath)
+
+	var prefixes []string
+	for _, mapping := range builtinRegistryMappings {
+		prefixes = append(prefixes, mapping.Prefix)
+	}
+	for _, file := range state.Files {
+		prefixes = append(prefixes, file.Prefix)
+	}
+
+	counts, err := countKineRows(dbPath, prefixes)
+	if err != nil {
+		fmt.Printf("              %s\n", yellow(fmt.Sprintf("could not read database: %v", err)))
+	} else {
+		var names []string
+		for prefix := range counts {
+			names = append(names, prefix)
+		}
+		sort.Strings(names)
+
+		rows := [][]string{}
+		for _, prefix := range names {
+			rows = append(rows, []string{"/registry/" + prefix + "/", strconv.Itoa(counts[prefix])})
+		}
+		fmt.Println()
+		table := newPlainTable([]string{"Registry Prefix", "Objects"})
+		table.AppendBulk(rows)
+		table.Render()
+	}
+
+	if len(state.Files) > 0 {
+		rows := [][]string{}
+		for _, file := range state.Files {
+			rows = append(rows, []string{file.File, strconv.Itoa(file.Loaded), strconv.Itoa(file.Skipped), file.Reason})
+		}
+		fmt.Println()
+		table := newPlainTable([]string{"File", "Loaded", "Skipped", "Reason"})
+		table.AppendBulk(rows)
+		table.Render()
+	}
+}
+
+// statusAll lists every bundle in the tickets dir, or recorded by bunk up in the bundle
+// registry, whose cluster is running
+func statusAll() {
+	states, err := filepath.Glob(getTicketsDir() + "/*/bundle-*/.kbk/state.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+	var bundleRootDirs []string
+	seen := map[string]bool{}
+	for _, statePath := range states {
+		bundleRootDir := filepath.Dir(filepath.Dir(statePath))
+		if abs, err := filepath.Abs(bundleRootDir); err == nil {
+			bundleRootDir = abs
+		}
+		bundleRootDirs = append(bundleRootDirs, bundleRootDir)
+		seen[bundleRootDir] = true
+	}
+	registered, err := readBundleRegistry()
+	if err != nil {
+		log.Printf("Failed to read %s; only listing bundles under the tickets dir: %v\n", bundleRegistryPath(), err)
+	}
+	for _, bundleRootDir := range registered {
+		if !seen[bundleRootDir] {
+			bundleRootDirs = append(bundleRootDirs, bundleRootDir)
+			seen[bundleRootDir] = true
+		}
+	}
+
+	rows := [][]string{}
+	for _, bundleRootDir := range bundleRootDirs {
+		provider, state, err := bundleClusterProvider(bundleRootDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if state == nil {
+			continue
+		}
+		running, err := provider.Running()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !running {
+			continue
+		}
+		rows = append(rows, []string{
+			filepath.Base(filepath.Dir(bundleRootDir)),
+			filepath.Base(bundleRootDir),
+			provider.Name(),
+			state.CreatedAt.Local().Format(time.RFC1123),
+		})
+	}
+
+	if len(rows) == 0 {
+		fmt.Println("No bundles with a running cluster")
+		return
+	}
+	table := newPlainTable([]string{"Ticket", "Bundle", "Cluster", "Created"})
+	table.AppendBulk(rows)
+	table.Render()
+}
+
+func valueOrUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
+
 func init() {
 	rootCmd.AddCommand(statusCmd)
 
@@ -48,4 +370,5 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// statusCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	statusCmd.Flags().Bool("all", false, "List every bundle under the tickets dir or started by bunk up with a running cluster")
 }
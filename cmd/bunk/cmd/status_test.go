@@ -0,0 +1,158 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRegistryPrefixOf(t *testing.T) {
+	prefixes := []string{"services/specs", "services/endpoints", "minions", "cert-manager.io/certificates", "pods"}
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"/registry/services/specs/default/web", "services/specs"},
+		{"/registry/services/endpoints/default/web", "services/endpoints"},
+		{"/registry/minions/node-1", "minions"},
+		{"/registry/pods/default/web-1", "pods"},
+		{"/registry/cert-manager.io/certificates/default/web", "cert-manager.io/certificates"},
+		// The longest prefix wins, and must end at a segment
+		{"/registry/podsecuritypolicy/restricted", "podsecuritypolicy"},
+		{"/registry/services/other/default/web", "services"},
+		// Keys under unknown prefixes, of built-in and custom resources
+		{"/registry/configmaps/kube-system/kubeadm-config", "configmaps"},
+		{"/registry/example.com/widgets/default/a", "example.com/widgets"},
+		{"/registry/example.com/widgets", "example.com"},
+		{"/registry/ranges/serviceips", "ranges"},
+		{"/registry/health", "health"},
+	}
+	for _, test := range tests {
+		if got := registryPrefixOf(test.key, prefixes); got != test.want {
+			t.Errorf("registryPrefixOf(%q) = %q; want %q", test.key, got, test.want)
+		}
+	}
+}
+
+func TestReadBundleMetadata(t *testing.T) {
+	kubeadmConfig := `items:
+- kind: ConfigMap
+  metadata:
+    name: kubeadm-config
+    namespace: kube-system
+  data:
+    ClusterConfiguration: |
+      apiVersion: kubeadm.k8s.io/v1beta2
+      kind: ClusterConfiguration
+      clusterName: prod-east
+      kubernetesVersion: v1.18.8
+- kind: ConfigMap
+  metadata:
+    name: kubeadm-config
+    namespace: default
+  data:
+    ClusterConfiguration: |
+      clusterName: not-this-one
+`
+	nodes := `items:
+- kind: Node
+  metadata:
+    name: node-1
+  status:
+    conditions:
+    - type: Ready
+      lastHeartbeatTime: "2020-09-01T10:00:00Z"
+    - type: MemoryPressure
+      lastHeartbeatTime: "2020-09-01T10:05:00Z"
+    nodeInfo:
+      kubeletVersion: v1.18.6
+`
+	events := `items:
+- kind: Event
+  metadata:
+    name: a
+    namespace: default
+  lastTimestamp: "2020-09-01T10:07:30Z"
+- kind: Event
+  metadata:
+    name: b
+    namespace: default
+  lastTimestamp: null
+`
+
+	tests := []struct {
+		files map[string]string
+		want  BundleMetadata
+	}{
+		{
+			files: map[string]string{"configmaps.yaml": kubeadmConfig, "nodes.yaml": nodes, "events.yaml": events},
+			want:  BundleMetadata{ClusterName: "prod-east", KubernetesVersion: "v1.18.8", CollectedAt: time.Date(2020, 9, 1, 10, 7, 30, 0, time.UTC)},
+		},
+		{
+			// Without kubeadm's config, the version of the kubelets
+			files: map[string]string{"nodes.yaml": nodes},
+			want:  BundleMetadata{KubernetesVersion: "v1.18.6", CollectedAt: time.Date(2020, 9, 1, 10, 5, 0, 0, time.UTC)},
+		},
+		{
+			// Events of other API groups are not the core ones
+			files: map[string]string{"events.events.k8s.io.yaml": events, "pods.yaml": "items: []\n"},
+		},
+	}
+
+	for i, test := range tests {
+		files := map[string]string{}
+		for name, content := range test.files {
+			files["api-resources/"+name] = content
+		}
+		bundleRootDir := writeTestBundle(t, files)
+		got := readBundleMetadata(loadBundleResources(bundleRootDir, filepath.Join(bundleRootDir, "api-resources")))
+		os.RemoveAll(bundleRootDir)
+		if got.ClusterName != test.want.ClusterName || got.KubernetesVersion != test.want.KubernetesVersion || !got.CollectedAt.Equal(test.want.CollectedAt) {
+			t.Errorf("%d: readBundleMetadata = %+v; want %+v", i, got, test.want)
+		}
+	}
+}
+
+func TestCountKineRows(t *testing.T) {
+	database, dir := openTestKineDatabase(t)
+	defer os.RemoveAll(dir)
+	defer database.Close()
+
+	if _, _, err := insertKineRows(database, testKineRows); err != nil {
+		t.Fatal(err)
+	}
+	// A node deleted after it was loaded, and a row of kine's own
+	for _, statement := range []string{
+		"INSERT INTO kine(name, created, deleted, create_revision, prev_revision, lease, value, old_value) VALUES('/registry/minions/node-1', 0, 1, 5001, 5001, 0, '', '')",
+		"INSERT INTO kine(name, created, deleted, create_revision, prev_revision, lease, value, old_value) VALUES('compact_rev_key', 0, 0, 0, 0, 0, '', '')",
+	} {
+		if _, err := database.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	counts, err := countKineRows(filepath.Join(dir, "state.db"), []string{"pods", "minions"})
+	if err != nil || fmt.Sprint(counts) != "map[pods:2]" {
+		t.Errorf("countKineRows = %v, %v; want 2 pods", counts, err)
+	}
+	if _, err := countKineRows(filepath.Join(dir, "missing.db"), nil); !os.IsNotExist(err) {
+		t.Errorf("countKineRows of a missing database = %v; want it not to exist", err)
+	}
+}
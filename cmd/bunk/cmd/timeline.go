@@ -0,0 +1,582 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// Sources of timeline entries
+const (
+	timelineSourceLog   = "log"
+	timelineSourceEvent = "event"
+)
+
+// timelineTimeLayout formats the times of timeline entries so that they line up
+const timelineTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// timelineCmd represents the timeline command
+var timelineCmd = &cobra.Command{
+	Use:   "timeline",
+	Short: "Merge the pod logs and events of the bundle into a single timeline",
+	Long: `Merge the timestamped lines of every pod log and the Kubernetes events of the bundle in the
+current directory into a single stream, sorted by time and tagged with where each entry came from.
+
+Timestamps are read from klog, RFC 3339, JSON (ts, time or timestamp fields) and logfmt lines;
+lines without one, such as stack traces, are kept with the line before them. Events are placed at
+their last occurrence.
+
+Narrow the timeline to a window with --since and --until, or with --at and --window to see what
+happened around a given time:
+
+  bunk timeline --at '2020-09-18 14:03' --window 2m
+  bunk timeline --since 2020-09-18T14:00:00Z --source event -o jsonl
+  bunk timeline -n kube-system --name 'kube-apiserver-*' -o html > timeline.html`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		options, output := timelineOptionsFromFlags(cmd)
+		timeline(options, output)
+	},
+}
+
+// TimelineEntry : A log line or event of the bundle, at the time it happened
+type TimelineEntry struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	// Object is the log's <namespace>/<pod>[/<container>], or the event's <kind>/<name>
+	Object    string `json:"object"`
+	Namespace string `json:"namespace,omitempty"`
+	// File and Line locate a log line within the bundle
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+	// Type and Reason are those of events, such as Warning and BackOff
+	Type    string `json:"type,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message"`
+}
+
+// timelineOptions : Which entries go into the timeline
+type timelineOptions struct {
+	// Since and Until bound the window of the timeline, unless they are zero
+	Since time.Time
+	Until time.Time
+	// Sources are the sources to include, or all if empty
+	Sources   map[string]bool
+	Namespace string
+	// Name is a glob for the pods of logs and the objects of events
+	Name string
+	// Grep, if set, only keeps entries whose message matches
+	Grep    *regexp.Regexp
+	Workers int
+}
+
+func timelineOptionsFromFlags(cmd *cobra.Command) (timelineOptions, string) {
+	flags := cmd.Flags()
+	since, _ := flags.GetString("since")
+	until, _ := flags.GetString("until")
+	at, _ := flags.GetString("at")
+	window, _ := flags.GetDuration("window")
+	sources, _ := flags.GetStringSlice("source")
+	grep, _ := flags.GetString("grep")
+	output, _ := flags.GetString("output")
+
+	var options timelineOptions
+	options.Namespace, _ = flags.GetString("namespace")
+	options.Name, _ = flags.GetString("name")
+	options.Workers, _ = flags.GetInt("workers")
+
+	var err error
+	if options.Since, err = parseOptionalTimeArg(since); err != nil {
+		log.Fatalf("Invalid --since: %v\n", err)
+	}
+	if options.Until, err = parseOptionalTimeArg(until); err != nil {
+		log.Fatalf("Invalid --until: %v\n", err)
+	}
+	if at != "" {
+		if since != "" || until != "" {
+			log.Fatalf("--at cannot be combined with --since or --until\n")
+		}
+		center, err := parseTimeArg(at)
+		if err != nil {
+			log.Fatalf("Invalid --at: %v\n", err)
+		}
+		options.Since, options.Until = center.Add(-window), center.Add(window)
+	}
+
+	options.Sources = map[string]bool{}
+	for _, source := range sources {
+		if source != timelineSourceLog && source != timelineSourceEvent {
+			log.Fatalf("Unknown source %q; expected %s or %s\n", source, timelineSourceLog, timelineSourceEvent)
+		}
+		options.Sources[source] = true
+	}
+	if grep != "" {
+		if options.Grep, err = regexp.Compile(grep); err != nil {
+			log.Fatalf("Invalid --grep regex %q: %v\n", grep, err)
+		}
+	}
+	if _, err := filepath.Match(options.Name, ""); err != nil {
+		log.Fatalf("Invalid name pattern %q: %v\n", options.Name, err)
+	}
+	switch output {
+	case "text", "jsonl", "html":
+	default:
+		log.Fatalf("Unknown output format %q; expected text, jsonl or html\n", output)
+	}
+	return options, output
+}
+
+func (o timelineOptions) includesSource(source string) bool {
+	return len(o.Sources) == 0 || o.Sources[source]
+}
+
+func (o timelineOptions) inWindow(t time.Time) bool {
+	return (o.Since.IsZero() || !t.Before(o.Since)) && (o.Until.IsZero() || !t.After(o.Until))
+}
+
+// matchesName reports whether name matches the --name glob, if any
+func (o timelineOptions) matchesName(name string) bool {
+	if o.Name == "" {
+		return true
+	}
+	ok, _ := filepath.Match(o.Name, name)
+	return ok
+}
+
+// keep reports whether an entry that passed the other filters goes into the timeline
+func (o timelineOptions) keep(entry TimelineEntry) bool {
+	return o.inWindow(entry.Time) && (o.Grep == nil || o.Grep.MatchString(entry.Message))
+}
+
+// readLogTimeline returns the entries of a log file within the timeline's window, sorted by time;
+// lines without a timestamp are appended to the entry before them
+func readLogTimeline(bundleRootDir string, logFile PodLogFile, times logTimeParser, options timelineOptions) ([]TimelineEntry, error) {
+	f, err := os.Open(logFile.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	file, err := filepath.Rel(bundleRootDir, logFile.Path)
+	if err != nil {
+		file = logFile.Path
+	}
+
+	var entries []TimelineEntry
+	var current *TimelineEntry
+	// message collects the lines of current, which may run to a long stack trace
+	var message strings.Builder
+	flush := func() {
+		if current != nil {
+			current.Message = message.String()
+			if options.keep(*current) {
+				entries = append(entries, *current)
+			}
+		}
+		current = nil
+		message.Reset()
+	}
+
+	scanner := newLogScanner(f)
+	for number := 1; scanner.Scan(); number++ {
+		line := scanner.Bytes()
+		t, ok := times.Parse(line)
+		if !ok {
+			if current != nil {
+				message.WriteByte('\n')
+				message.Write(line)
+			}
+			continue
+		}
+		flush()
+		if !options.inWindow(t) {
+			// current stays nil, so its continuation lines are skipped too
+			continue
+		}
+		current = &TimelineEntry{
+			Time:      t,
+			Source:    timelineSourceLog,
+			Object:    logFile.Name(),
+			Namespace: logFile.Namespace,
+			File:      filepath.ToSlash(file),
+			Line:      number,
+		}
+		message.Write(line)
+	}
+	flush()
+	sortTimeline(entries)
+	return entries, scanner.Err()
+}
+
+// readLogsTimeline reads the timelines of logFiles, up to options.Workers files at a time
+func readLogsTimeline(bundleRootDir string, logFiles []PodLogFile, times logTimeParser, options timelineOptions) [][]TimelineEntry {
+	workers := options.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	var mu sync.Mutex
+	var timelines [][]TimelineEntry
+	files := make(chan PodLogFile)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for logFile := range files {
+				fileEntries, err := readLogTimeline(bundleRootDir, logFile, times, options)
+				if err != nil {
+					log.Printf("Failed to read %s: %v\n", logFile.Path, err)
+				}
+				if len(fileEntries) == 0 {
+					continue
+				}
+				mu.Lock()
+				timelines = append(timelines, fileEntries)
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, logFile := range logFiles {
+		files <- logFile
+	}
+	close(files)
+	wg.Wait()
+	return timelines
+}
+
+// timelineEvent : The fields of a core or events.k8s.io event that go into a timeline
+type timelineEvent struct {
+	Metadata struct {
+		Namespace         string    `json:"namespace"`
+		CreationTimestamp time.Time `json:"creationTimestamp"`
+	} `json:"metadata"`
+	InvolvedObject struct {
+		Kind string `json:"kind"`
+		Name string `json:"name"`
+	} `json:"involvedObject"`
+	// Regarding is the involved object of events.k8s.io events
+	Regarding struct {
+		Kind string `json:"kind"`
+		Name string `json:"name"`
+	} `json:"regarding"`
+	Type           string    `json:"type"`
+	Reason         string    `json:"reason"`
+	Message        string    `json:"message"`
+	Note           string    `json:"note"`
+	Count          int       `json:"count"`
+	FirstTimestamp time.Time `json:"firstTimestamp"`
+	LastTimestamp  time.Time `json:"lastTimestamp"`
+	EventTime      time.Time `json:"eventTime"`
+}
+
+// timelineEntry returns the entry of an event at its last occurrence, or false if it has no time
+func (e timelineEvent) timelineEntry() (TimelineEntry, bool) {
+	entry := TimelineEntry{
+		Source:    timelineSourceEvent,
+		Namespace: e.Metadata.Namespace,
+		Type:      e.Type,
+		Reason:    e.Reason,
+		Message:   e.Message,
+	}
+	kind, name := e.InvolvedObject.Kind, e.InvolvedObject.Name
+	if name == "" {
+		kind, name = e.Regarding.Kind, e.Regarding.Name
+	}
+	entry.Object = strings.ToLower(kind) + "/" + name
+	if entry.Message == "" {
+		entry.Message = e.Note
+	}
+
+	for _, t := range []time.Time{e.LastTimestamp, e.EventTime, e.FirstTimestamp, e.Metadata.CreationTimestamp} {
+		if !t.IsZero() {
+			entry.Time = t.UTC()
+			break
+		}
+	}
+	if e.Count > 1 && !e.FirstTimestamp.IsZero() {
+		entry.Message += fmt.Sprintf(" (x%d since %s)", e.Count, e.FirstTimestamp.UTC().Format(timelineTimeLayout))
+	}
+	return entry, !entry.Time.IsZero()
+}
+
+// readEventsTimeline returns the entries of the events in the bundle's api-resources dir, sorted
+// by time
+func readEventsTimeline(bundleRootDir string, options timelineOptions) []TimelineEntry {
+	apiResourcesDir := findAPIResourcesDir(bundleRootDir)
+	if apiResourcesDir == "" {
+		log.Printf("No api-resources dir in bundle %s; the timeline has no events\n", bundleRootDir)
+		return nil
+	}
+	resources := loadBundleResourceFiles(bundleRootDir, apiResourcesDir, func(resource string, group string) bool {
+		return resource == "events" && (group == "" || group == "events.k8s.io")
+	})
+	for path, err := range resources.Invalid {
+		log.Printf("Skipping events in %s: %v\n", path, err)
+	}
+
+	var entries []TimelineEntry
+	for _, group := range []string{"", "events.k8s.io"} {
+		for _, object := range resources.Objects("events", group) {
+			var event timelineEvent
+			if err := object.Decode(&event); err != nil {
+				continue
+			}
+			entry, ok := event.timelineEntry()
+			if !ok {
+				continue
+			}
+			if options.Namespace != "" && entry.Namespace != options.Namespace {
+				continue
+			}
+			name := event.InvolvedObject.Name
+			if name == "" {
+				name = event.Regarding.Name
+			}
+			if options.matchesName(name) && options.keep(entry) {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	sortTimeline(entries)
+	return entries
+}
+
+// timelineFallbackTime is the time klog timestamps get their year from when the bundle does not
+// tell when it was collected
+func timelineFallbackTime(options timelineOptions) time.Time {
+	if !options.Until.IsZero() {
+		return options.Until
+	}
+	return options.Since
+}
+
+// timelineEntryBefore orders entries by time, keeping lines of the same log in order when they
+// share a timestamp
+func timelineEntryBefore(a TimelineEntry, b TimelineEntry) bool {
+	if !a.Time.Equal(b.Time) {
+		return a.Time.Before(b.Time)
+	}
+	if a.File != b.File {
+		return a.File < b.File
+	}
+	return a.Line < b.Line
+}
+
+// sortTimeline sorts the entries of a log file or of the events by time
+func sortTimeline(entries []TimelineEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return timelineEntryBefore(entries[i], entries[j])
+	})
+}
+
+// collectTimeline returns the timelines of the bundle's logs and events, each sorted by time,
+// to be merged by mergeTimelines
+func collectTimeline(bundleRootDir string, options timelineOptions) [][]TimelineEntry {
+	var timelines [][]TimelineEntry
+
+	if options.includesSource(timelineSourceLog) {
+		if podLogsDir := findPodLogsDir(bundleRootDir); podLogsDir != "" {
+			var logFiles []PodLogFile
+			filter := podLogFilter{Namespace: options.Namespace, Name: options.Name}
+			for _, logFile := range findPodLogFiles(podLogsDir) {
+				if filter.matches(logFile) {
+					logFiles = append(logFiles, logFile)
+				}
+			}
+			times := newLogTimeParser(bundleRootDir, timelineFallbackTime(options))
+			timelines = append(timelines, readLogsTimeline(bundleRootDir, logFiles, times, options)...)
+		} else {
+			log.Printf("No pods_logs dir in bundle %s; the timeline has no logs\n", bundleRootDir)
+		}
+	}
+
+	if options.includesSource(timelineSourceEvent) {
+		if events := readEventsTimeline(bundleRootDir, options); len(events) > 0 {
+			timelines = append(timelines, events)
+		}
+	}
+	return timelines
+}
+
+// timelineHeap : The timelines being merged, ordered by their next entry
+type timelineHeap [][]TimelineEntry
+
+func (h timelineHeap) Len() int            { return len(h) }
+func (h timelineHeap) Less(i, j int) bool  { return timelineEntryBefore(h[i][0], h[j][0]) }
+func (h timelineHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *timelineHeap) Push(x interface{}) { *h = append(*h, x.([]TimelineEntry)) }
+func (h *timelineHeap) Pop() interface{} {
+	old := *h
+	timeline := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return timeline
+}
+
+// mergeTimelines passes the entries of timelines, each sorted by time, to emit in time order,
+// stopping at the first error
+func mergeTimelines(timelines [][]TimelineEntry, emit func(TimelineEntry) error) error {
+	h := timelineHeap{}
+	for _, timeline := range timelines {
+		if len(timeline) > 0 {
+			h = append(h, timeline)
+		}
+	}
+	heap.Init(&h)
+	for h.Len() > 0 {
+		if err := emit(h[0][0]); err != nil {
+			return err
+		}
+		if h[0] = h[0][1:]; len(h[0]) == 0 {
+			heap.Pop(&h)
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+	return nil
+}
+
+// timelineLength returns the number of entries of timelines
+func timelineLength(timelines [][]TimelineEntry) int {
+	n := 0
+	for _, timeline := range timelines {
+		n += len(timeline)
+	}
+	return n
+}
+
+var (
+	timelineTimeColor    = color.New(color.FgGreen)
+	timelineLogColor     = color.New(color.FgCyan)
+	timelineEventColor   = color.New(color.FgMagenta)
+	timelineWarningColor = color.New(color.FgYellow)
+)
+
+// timelineObjectWidth bounds the width the object column of text timelines is padded to
+const timelineObjectWidth = 60
+
+// timelineObject returns the object of an entry as shown in text timelines
+func timelineObject(entry TimelineEntry) string {
+	if entry.Source == timelineSourceEvent && entry.Namespace != "" {
+		return entry.Namespace + "/" + entry.Object
+	}
+	return entry.Object
+}
+
+// printTimelineText prints the merged entries of timelines one per line, indenting the
+// continuation lines of logs
+func printTimelineText(timelines [][]TimelineEntry) {
+	width := 0
+	for _, timeline := range timelines {
+		for _, entry := range timeline {
+			if n := len(timelineObject(entry)); n > width {
+				width = minInt(n, timelineObjectWidth)
+			}
+		}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	err := mergeTimelines(timelines, func(entry TimelineEntry) error {
+		source := timelineLogColor.Sprintf("%-5s", entry.Source)
+		message := entry.Message
+		if entry.Source == timelineSourceEvent {
+			source = timelineEventColor.Sprintf("%-5s", entry.Source)
+			message = entry.Type + " " + entry.Reason + ": " + entry.Message
+			if entry.Type == "Warning" {
+				message = timelineWarningColor.Sprint(message)
+			}
+		}
+		message = strings.Replace(message, "\n", "\n    ", -1)
+		_, err := fmt.Fprintf(out, "%s  %s  %-*s  %s\n", timelineTimeColor.Sprint(entry.Time.Format(timelineTimeLayout)), source, width, timelineObject(entry), message)
+		return err
+	})
+	if err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		log.Fatalf("Failed to write timeline: %v\n", err)
+	}
+}
+
+// printTimelineJSONLines prints each merged entry of timelines as a JSON object on a line of its own
+func printTimelineJSONLines(timelines [][]TimelineEntry) {
+	out := bufio.NewWriter(os.Stdout)
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	err := mergeTimelines(timelines, func(entry TimelineEntry) error {
+		return enc.Encode(entry)
+	})
+	if err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		log.Fatalf("Failed to write timeline: %v\n", err)
+	}
+}
+
+func timeline(options timelineOptions, output string) {
+	bundleRootDir := getBundleRootDir()
+	if options.Since.IsZero() && options.Until.IsZero() && options.includesSource(timelineSourceLog) {
+		log.Printf("No --since, --until or --at given; reading every timestamped log line of the bundle, which takes as much memory as its logs\n")
+	}
+	timelines := collectTimeline(bundleRootDir, options)
+
+	switch output {
+	case "jsonl":
+		printTimelineJSONLines(timelines)
+	case "html":
+		out := bufio.NewWriter(os.Stdout)
+		err := writeTimelineHTML(out, bundleRootDir, timelines)
+		if err == nil {
+			err = out.Flush()
+		}
+		if err != nil {
+			log.Fatalf("Failed to write timeline: %v\n", err)
+		}
+	default:
+		printTimelineText(timelines)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(timelineCmd)
+
+	timelineCmd.Flags().String("since", "", "Only include what happened at or after this time")
+	timelineCmd.Flags().String("until", "", "Only include what happened at or before this time")
+	timelineCmd.Flags().String("at", "", "Only include what happened within --window of this time")
+	timelineCmd.Flags().Duration("window", 5*time.Minute, "How far before and after --at to include")
+	timelineCmd.Flags().StringSlice("source", nil, "Only include these sources: log, event")
+	timelineCmd.Flags().StringP("namespace", "n", "", "Only include the logs and events of this namespace")
+	timelineCmd.Flags().String("name", "", "Only include the logs of pods, and the events of objects, whose name matches this glob")
+	timelineCmd.Flags().String("grep", "", "Only include entries whose message matches this regex")
+	timelineCmd.Flags().StringP("output", "o", "text", "Output format: text, jsonl or html")
+	timelineCmd.Flags().Int("workers", runtime.NumCPU(), "Number of log files to read at once")
+}
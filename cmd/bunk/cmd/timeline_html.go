@@ -0,0 +1,84 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"html/template"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+// timelineHTMLTemplate renders a timeline as a standalone page, with a box to filter its rows
+var timelineHTMLTemplate = template.Must(template.New("timeline").Funcs(template.FuncMap{
+	"formatTime": func(t time.Time) string { return t.Format(timelineTimeLayout) },
+}).Parse(`{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Timeline of {{.Bundle}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+input { width: 40em; margin-bottom: 1em; }
+table { border-collapse: collapse; font-size: 13px; }
+th { text-align: left; position: sticky; top: 0; background: #eee; }
+td { padding: 2px 8px; vertical-align: top; border-bottom: 1px solid #eee; }
+td.time { white-space: nowrap; font-family: monospace; color: #060; }
+td.message { white-space: pre-wrap; font-family: monospace; }
+tr.event td.source { color: #909; }
+tr.log td.source { color: #099; }
+tr.Warning { background: #fff6d5; }
+</style>
+</head>
+<body>
+<h1>Timeline of {{.Bundle}}</h1>
+<p>{{.Entries}} entries</p>
+<input id="filter" placeholder="Filter rows" oninput="filterRows(this.value)">
+<table>
+<thead><tr><th>Time</th><th>Source</th><th>Object</th><th>Message</th></tr></thead>
+<tbody>
+{{end}}{{define "entry"}}<tr class="{{.Source}} {{.Type}}"><td class="time">{{formatTime .Time}}</td><td class="source">{{.Source}}</td><td>{{if .Namespace}}{{if eq .Source "event"}}{{.Namespace}}/{{end}}{{end}}{{.Object}}{{if .File}}<br><small title="{{.File}}:{{.Line}}">line {{.Line}}</small>{{end}}</td><td class="message">{{if .Reason}}<b>{{.Type}} {{.Reason}}:</b> {{end}}{{.Message}}</td></tr>
+{{end}}{{define "footer"}}</tbody>
+</table>
+<script>
+function filterRows(text) {
+  text = text.toLowerCase();
+  document.querySelectorAll("tbody tr").forEach(function (row) {
+    row.style.display = row.textContent.toLowerCase().indexOf(text) >= 0 ? "" : "none";
+  });
+}
+</script>
+</body>
+</html>
+{{end}}`))
+
+// writeTimelineHTML writes the merged timelines of the bundle in bundleRootDir as an HTML page
+func writeTimelineHTML(w io.Writer, bundleRootDir string, timelines [][]TimelineEntry) error {
+	err := timelineHTMLTemplate.ExecuteTemplate(w, "header", struct {
+		Bundle  string
+		Entries int
+	}{filepath.Base(bundleRootDir), timelineLength(timelines)})
+	if err != nil {
+		return err
+	}
+	err = mergeTimelines(timelines, func(entry TimelineEntry) error {
+		return timelineHTMLTemplate.ExecuteTemplate(w, "entry", entry)
+	})
+	if err != nil {
+		return err
+	}
+	return timelineHTMLTemplate.ExecuteTemplate(w, "footer", nil)
+}
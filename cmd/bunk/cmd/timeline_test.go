@@ -0,0 +1,98 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergeTimelines(t *testing.T) {
+	at := func(second int) time.Time { return time.Date(2020, 9, 18, 12, 0, second, 0, time.UTC) }
+	timelines := [][]TimelineEntry{
+		{{Time: at(1), File: "b.log", Line: 1}, {Time: at(3), File: "b.log", Line: 2}, {Time: at(3), File: "b.log", Line: 3}},
+		{{Time: at(0), Source: timelineSourceEvent}, {Time: at(4), Source: timelineSourceEvent}},
+		{},
+		{{Time: at(3), File: "a.log", Line: 7}},
+	}
+
+	var got []string
+	err := mergeTimelines(timelines, func(entry TimelineEntry) error {
+		got = append(got, fmt.Sprintf("%d %s:%d", entry.Time.Second(), entry.File, entry.Line))
+		return nil
+	})
+	want := []string{"0 :0", "1 b.log:1", "3 a.log:7", "3 b.log:2", "3 b.log:3", "4 :0"}
+	if err != nil || fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("mergeTimelines = %v, %v; want %v", got, err, want)
+	}
+	if n := timelineLength(timelines); n != len(want) {
+		t.Errorf("timelineLength = %d; want %d", n, len(want))
+	}
+
+	stop := errors.New("stop")
+	emitted := 0
+	err = mergeTimelines(timelines, func(TimelineEntry) error {
+		emitted++
+		return stop
+	})
+	if err != stop || emitted != 1 {
+		t.Errorf("mergeTimelines stopped after %d entries with %v; want 1 with %v", emitted, err, stop)
+	}
+}
+
+func TestReadLogTimelineLongContinuation(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "bunk-timeline-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// One entry with a 60,000 line stack trace, then one more entry
+	trace := strings.Repeat("\tat com.example.Foo.bar(Foo.java:42)\n", 60000)
+	content := "E0918 12:00:00.000 1 main.go:1] panic\n" + trace + "I0918 12:00:01.000 1 main.go:2] restarted\n"
+	path := filepath.Join(tmp, "pods_logs", "default", "app.log")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	times := logTimeParser{Reference: time.Date(2020, 9, 30, 0, 0, 0, 0, time.UTC)}
+	start := time.Now()
+	entries, err := readLogTimeline(tmp, PodLogFile{Path: path, Namespace: "default", Pod: "app"}, times, timelineOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("readLogTimeline took %v", elapsed)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("readLogTimeline returned %d entries; want 2", len(entries))
+	}
+	if want := "E0918 12:00:00.000 1 main.go:1] panic\n" + strings.TrimSuffix(trace, "\n"); entries[0].Message != want {
+		t.Errorf("first entry has %d bytes of message; want %d", len(entries[0].Message), len(want))
+	}
+	if want := "I0918 12:00:01.000 1 main.go:2] restarted"; entries[1].Message != want || entries[1].Line != 60002 {
+		t.Errorf("second entry = %q at line %d; want %q at line 60002", entries[1].Message, entries[1].Line, want)
+	}
+}
@@ -16,24 +16,18 @@ limitations under the License.
 package cmd
 
 import (
-	"bytes"
-	"database/sql"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
-	"os/user"
 	"path/filepath"
-	"runtime"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	// Add sqlite3 driver
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/spf13/cobra"
-	"sigs.k8s.io/yaml"
+	"github.com/spf13/viper"
 )
 
 // KubernetesItems : Structure of Kubernetes yaml files -- make this more clear/redefine
@@ -68,15 +62,30 @@ type KubernetesObject struct {
 var upCmd = &cobra.Command{
 	Use:   "up",
 	Short: "Create a kbk cluster for a bundle",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
+	Long: `Create a k3d cluster for the bundle in the current directory and load the bundle's
+api-resources into its database.
 
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+The resources are loaded into the cluster's database before k3s first starts, so bunk never
+needs root to write to it.
+
+bunk up records each phase it completes in .kbk/state.json: resources read, resources loaded
+and cluster started. Running it again resumes after the last completed phase, or starts the
+cluster if it was stopped; pass --recreate to delete the cluster and rebuild it from scratch.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// log.Println("up called")
-		up()
+		mode, _ := cmd.Flags().GetString("mode")
+		switch mode {
+		case "k3d":
+			emitSQL, _ := cmd.Flags().GetBool("emit-sql")
+			explainKeys, _ := cmd.Flags().GetBool("explain-keys")
+			recreate, _ := cmd.Flags().GetBool("recreate")
+			up(upOptions{EmitSQL: emitSQL, ExplainKeys: explainKeys, Recreate: recreate})
+		case "local":
+			listen, _ := cmd.Flags().GetString("listen")
+			upLocal(listen)
+		default:
+			log.Fatalf("Unknown mode %q; expected k3d or local\n", mode)
+		}
 	},
 }
 
@@ -113,6 +122,20 @@ func getBundleRootDir() string {
 }
 
 func getAPIResourcesDir(bundleRootDir string) string {
+	apiResourcesDir := findAPIResourcesDir(bundleRootDir)
+	if apiResourcesDir == "" {
+		log.Fatalf("Failed to find api-resources dir within bundle directory: %s\n", bundleRootDir)
+	}
+
+	return apiResourcesDir
+}
+
+// findAPIResourcesDir returns the api-resources dir within the bundle, or "" if there is none
+func findAPIResourcesDir(bundleRootDir string) string {
+	if manifest, err := readBundleManifest(bundleRootDir); err == nil && manifest != nil {
+		return manifestRoleDir(bundleRootDir, manifest, roleAPIResources)
+	}
+
 	var apiResourcesDir string
 
 	err := filepath.Walk(bundleRootDir, func(path string, info os.FileInfo, err error) error {
@@ -130,10 +153,6 @@ func getAPIResourcesDir(bundleRootDir string) string {
 		log.Fatalf("Error walking the path %q: %v\n", bundleRootDir, err)
 	}
 
-	if apiResourcesDir == "" {
-		log.Fatalf("Failed to find api-resources dir within bundle directory: %s\n", bundleRootDir)
-	}
-
 	return apiResourcesDir
 }
 
@@ -148,7 +167,7 @@ func getAPIResourcesDir(bundleRootDir string) string {
 // }
 
 func initConfigDir(bundleRootDir string) string {
-	err := os.Mkdir(bundleRootDir+"/.kbk", 0774)
+	err := os.MkdirAll(bundleRootDir+"/.kbk", 0774)
 	if err != nil {
 		log.Fatalf("Failed to create .kbk directory at %s: %s\n", bundleRootDir, err)
 	}
@@ -156,309 +175,261 @@ func initConfigDir(bundleRootDir string) string {
 	return resourceDir
 }
 
-func writeKubernetesResources(bundleRootDir string, apiResourcesDir string, resourceDir string) string {
-	// Set sql file for writing
-	kubernetesResourcesSQL := resourceDir + "/kubernetesResources.sql"
-	// Set base resourceID to something out of range
-	resourceID := 5000
-
-	var files []string
-	// Find yaml files in directory -- Maybe clean this up a bit in the future
-	err := filepath.Walk(apiResourcesDir, func(path string, info os.FileInfo, err error) error {
-		if filepath.Ext(path) == ".yaml" {
-			files = append(files, path)
-			return nil
-		}
-		return nil
-	})
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Kill the file because we're lazy atm :)
-	// TODO: remove this
-	os.Remove(kubernetesResourcesSQL)
+// readKubernetesResources converts every object in the api-resources dir into a kine row keyed by
+// its registry path, returning the rows and how each file was mapped
+func readKubernetesResources(apiResourcesDir string, mapper *registryMapper) ([]KineRow, []RegistryPlanEntry) {
+	var rows []KineRow
+	var plan []RegistryPlanEntry
+	// Files already written for each key, as the same objects can be listed under several groups
+	keys := map[string]string{}
 
 	// For each yaml file in apiresources dir
-	for _, file := range files {
-		// Get the file basename from its full path
-		basename := file[strings.LastIndex(file, "/")+1:]
-
-		// log.Println("DEBUG BASENAME: %s", basename)
-
-		// Get api resource group from file name
-		// TODO: fix this to better account for x.yaml files (e.g., pods.yaml)
-		apiResourceGroup := strings.TrimSuffix(strings.SplitN(basename, ".", 2)[1], ".yaml")
-
-		// Write out api resource groups that break path in database
-		switch apiResourceGroup {
-		case "yaml",
-			"apps",
-			"batch",
-			"certificates.k8s.io",
-			"coordination.k8s.io",
-			"extensions",
-			"networking.k8s.io",
-			"rbac.authorization.k8s.io",
-			"scheduling.k8s.io",
-			"storage.k8s.io",
-			"snapshot.storage.k8s.io":
-			apiResourceGroup = ""
-		}
+	for _, file := range findResourceFiles(apiResourcesDir) {
+		entry := RegistryPlanEntry{File: file}
+
+		// Get api resource name and group from file name
+		apiResourceName, apiResourceGroup := parseResourceFileName(filepath.Base(file))
 
-		// log.Println("Debug apiResourceGroup: " + apiResourceGroup)
-
-		// Get api resource name from file name
-		apiResourceName := strings.SplitN(basename, ".", 2)[0]
-
-		// Modify api resource names for known inconsistencies
-		switch apiResourceName {
-		case "nodes":
-			apiResourceName = "minions"
-		case "endpoints":
-			apiResourceName = "services/endpoints"
-		case "services":
-			apiResourceName = "services/specs"
-		case "leases":
-			apiResourceName = "leases/kube-node-lease"
-		case "ingresses":
-			apiResourceName = "ingress"
-		case "podsecuritypolicies":
-			apiResourceName = "podsecuritypolicy"
+		entry.Prefix, entry.Skip = mapper.resolve(apiResourceName, apiResourceGroup)
+		if entry.Skip != "" {
+			plan = append(plan, entry)
+			continue
 		}
 
-		// Ignore secrets file, as it is not valid yaml
-		if apiResourceName == "secrets" {
+		objects, err := readResourceFile(file)
+		if err != nil {
+			entry.Skip = fmt.Sprintf("invalid yaml: %v", err)
+			plan = append(plan, entry)
 			continue
-		} else {
-			// Begin parsing yaml to create sql statements
-			// Open file as read-only
-			f, err := os.OpenFile(file, os.O_RDONLY, 0444)
-			if err != nil {
-				log.Fatal(err)
+		}
+		if len(objects) == 0 {
+			entry.Skip = "no objects"
+			plan = append(plan, entry)
+			continue
+		}
+
+		// For each item in the Kubernetes resource file
+		duplicateOf := ""
+		for _, object := range objects {
+			key := registryKey(entry.Prefix, object.Metadata.Namespace, object.Metadata.Name)
+			if previous, ok := keys[key]; ok {
+				duplicateOf = previous
+				entry.Skipped++
+				continue
 			}
+			keys[key] = file
+
+			rows = append(rows, KineRow{
+				Key:    key,
+				Value:  object.Raw,
+				File:   file,
+				Object: object.Ref(),
+			})
+			entry.Objects++
+		}
+		if entry.Objects == 0 {
+			entry.Skip = fmt.Sprintf("objects already loaded from %s", filepath.Base(duplicateOf))
+		}
+		plan = append(plan, entry)
+	}
 
-			// Close file when finished
-			defer f.Close()
+	return rows, plan
+}
 
-			// Read the file
-			content, err := ioutil.ReadAll(f)
-			if err != nil {
-				log.Fatal(err)
-			}
+// loadKubernetesResources seeds the kine database in resourceDir/db with rows before the cluster
+// first starts, so that it stays owned by the current user. Rows that failed are returned.
+func loadKubernetesResources(rows []KineRow, resourceDir string) []KineRowError {
+	log.Println("Adding cluster resources")
 
-			// Unmarshal yaml to json
-			var kubernetesItems KubernetesItems
-			if err := yaml.Unmarshal(content, &kubernetesItems); err != nil {
-				log.Fatal(err)
-			}
+	inserted, failures, err := seedKineDatabase(resourceDir+"/db/state.db", rows)
+	if err != nil {
+		log.Fatalf("Failed to add cluster resources: %v", err)
+	}
+	for _, failure := range failures {
+		log.Printf("Failed to add %s from %s: %v\n", failure.Row.Object, failure.Row.File, failure.Err)
+	}
+	log.Printf("Added %d of %d cluster resources\n", inserted, len(rows))
 
-			// Pretty colors rock!
-			green := color.New(color.FgGreen).PrintfFunc()
-			yellow := color.New(color.FgYellow).PrintfFunc()
-			// red := color.New(color.FgRed).PrintFunc()
+	return failures
+}
 
-			// Give the people some nice output
-			if len(kubernetesItems.Items) == 0 {
-				yellow("Skipping empty %s resource file: %s\n", apiResourceName, basename)
-			} else {
-				green("Writing %d %s resources from file: %s\n", len(kubernetesItems.Items), apiResourceName, basename)
-			}
+// createKubernetesCluster creates and starts the cluster on the database loaded in resourceDir/db
+func createKubernetesCluster(provider ClusterProvider, resourceDir string) {
+	log.Printf("Creating k3d cluster %s\n", provider.Name())
+	if err := provider.Create(resourceDir); err != nil {
+		log.Fatal(err)
+	}
 
-			// For each item in the Kubernetes resource file
-			for i := 0; i < len(kubernetesItems.Items); i++ {
-				// Marshal the json items to individual []byte
-				// objectJSON, err := json.Marshal(kubernetesItems.Items[i])
-				// if err != nil {
-				// 	log.Fatal(err)
-				// }
-
-				// enc := json.NewEncoder(os.Stdout)
-				// enc.SetEscapeHTML(false)
-				// err := enc.Encode(objectJSON)
-				// if err != nil {
-				// 	log.Fatal(err)
-				// }
-
-				buffer := &bytes.Buffer{}
-				enc := json.NewEncoder(buffer)
-				enc.SetEscapeHTML(false)
-				err := enc.Encode(kubernetesItems.Items[i])
-				if err != nil {
-					log.Fatal(err)
-				}
-
-				objectJSON := buffer.Bytes()
-
-				// Preserve the raw JSON []byte and trim newline for printing >:(
-				objectState := []byte(strings.TrimSuffix(string(objectJSON), "\n"))
-
-				var kubernetesObject KubernetesObject
-
-				// Unmarshal json to kubernetes object struct to write as sql statements
-				if err := json.Unmarshal(objectJSON, &kubernetesObject); err != nil {
-					log.Fatal(err)
-				}
-
-				// Print the full object json
-				// log.Printf("%s\n\n", objectState)
-
-				var sqlInsertStatement string = ""
-				// var apiResourceName string
-				// var apiResourceNamespaced bool
-				// var objectState []byte
-				// var objectName string
-				// var objectNamespace string
-				objectName := kubernetesObject.Metadata.Name
-				objectNamespace := kubernetesObject.Metadata.Namespace
-
-				sqlInsertStatement = fmt.Sprintf(
-					"INSERT INTO kine(id, name, created, deleted, create_revision, prev_revision, lease, value, old_value) "+
-						"VALUES(%d, '/registry/",
-					resourceID)
-
-				// Add api resource group to path if it exists
-				if apiResourceGroup != "" {
-					sqlInsertStatement += fmt.Sprintf("%s/", apiResourceGroup)
-				}
-
-				// Add api resource name
-				sqlInsertStatement += fmt.Sprintf("%s/", apiResourceName)
-
-				// Add namespace if it exists
-				if objectNamespace != "" {
-					sqlInsertStatement += fmt.Sprintf("%s/", objectNamespace)
-				}
-
-				// Escape objectState s/\'/\'\'/g as string and convert back to byte
-				objectState = []byte(strings.ReplaceAll(string(objectState), "'", "''"))
-
-				// Add required additional fields
-				sqlInsertStatement += fmt.Sprintf("%s', 1, 0, %d, %d, 0, '%s', '%s');", objectName, resourceID+1, resourceID+2, objectState, objectState)
-
-				// Increment resourceID
-				// TODO: I think 3 is sufficient -- need to test this
-				resourceID += 4
-
-				// Open file; write the file; close the file; if closing file fails, then sad panda
-				sqlFile, err := os.OpenFile(kubernetesResourcesSQL, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0664)
-				if err != nil {
-					log.Fatal(err)
-				}
-				if _, err := sqlFile.Write([]byte(sqlInsertStatement + "\n")); err != nil {
-					sqlFile.Close() // ignore error; Write error takes precedence
-					log.Fatal(err)
-				}
-
-				// Close the file when done
-				// This must be explicit or we will hit too many open files
-				if err := sqlFile.Close(); err != nil {
-					log.Fatalf("Failed to close sql file: %v", err)
-				}
-			}
+	log.Printf("k3d cluster %s created! Please access the cluster with:\neval \"$(bunk kubeconfig --export)\"\nor add it to ~/.kube/config with `bunk kubeconfig --merge`\n", provider.Name())
+}
+
+// resetKineDatabase removes the cluster and its database after k3s may have written to it,
+// so that the resources can be loaded again
+func resetKineDatabase(provider ClusterProvider, resourceDir string) {
+	provider.Delete()
+	for _, file := range kineDatabaseFiles {
+		if err := os.Remove(resourceDir + "/db/" + file); err != nil && !os.IsNotExist(err) {
+			log.Fatalf("Failed to remove %s; remove the cluster with `bunk up --recreate`: %v\n", file, err)
 		}
 	}
+}
 
-	return kubernetesResourcesSQL
+// upOptions : Flags controlling how `bunk up` replays a bundle
+type upOptions struct {
+	EmitSQL     bool
+	ExplainKeys bool
+	Recreate    bool
 }
 
-func createKubernetesCluster(kubernetesResourcesSQL string, resourceDir string) {
-	log.Println("Creating k3d cluster")
-	cmd := exec.Command("k3d",
-		"create",
-		"--workers", "0",
-		"--volume", resourceDir+"/db:/var/lib/rancher/k3s/server/db/",
-		"--server-arg", "--disable-agent",
-		"--server-arg", "--no-deploy=coredns",
-		"--server-arg", "--no-deploy=servicelb",
-		"--server-arg", "--no-deploy=traefik",
-		"--server-arg", "--no-deploy=local-storage",
-		"--server-arg", "--no-deploy=metrics-server",
-		"--server-arg", "--kube-apiserver-arg=event-ttl=168h0m0s",
-		"--server-arg", "--kube-controller-arg=disable-attach-detach-reconcile-sync",
-		"--server-arg", "--kube-controller-arg=controllers=-attachdetach,-clusterrole-aggregation,-cronjob,-csrapproving,-csrcleaner,-csrsigning,-daemonset,-deployment,-disruption,-endpoint,-garbagecollector,-horizontalpodautoscaling,-job,-namespace,-nodeipam,-nodelifecycle,-persistentvolume-binder,-persistentvolume-expander,-podgc,-pv-protection,-pvc-protection,-replicaset,-replicationcontroller,-resourcequota,-root-ca-cert-publisher,-serviceaccount,-serviceaccount-token,-statefulset,-ttl",
-		"--server-arg", "--disable-scheduler",
-		"--server-arg", "--disable-cloud-controller",
-		"--server-arg", "--disable-network-policy",
-		"--server-arg", "--no-flannel",
-		"--wait", "60",
-	)
-	if err := cmd.Run(); err != nil {
-		log.Fatal(err)
+// replayAPIServerVersion returns the Kubernetes version of the replay cluster's apiserver and
+// where it comes from: --apiserver-version, else the k3s image k3d creates clusters with, else
+// the version of the cluster the bundle was collected from
+func replayAPIServerVersion(bundleRootDir string, apiResourcesDir string) (string, string) {
+	if version := viper.GetString("registry.apiserverVersion"); version != "" {
+		return version, "--apiserver-version"
 	}
-
-	log.Println("Stopping k3d cluster")
-	cmd = exec.Command("k3d",
-		"stop",
-		"--all",
-	)
-	if err := cmd.Run(); err != nil {
-		log.Fatal(err)
+	if version := detectK3sVersion(); parseKubernetesMinor(version) >= 0 {
+		return version, "k3d's k3s image"
 	}
+	resources := loadBundleResourceFiles(bundleRootDir, apiResourcesDir, func(resource string, group string) bool {
+		return group == "" && (resource == "nodes" || resource == "configmaps")
+	})
+	if version := readBundleMetadata(resources).KubernetesVersion; parseKubernetesMinor(version) >= 0 {
+		return version, "the bundle's cluster"
+	}
+	return "", ""
+}
+
+func up(options upOptions) {
+	bundleRootDir := getBundleRootDir()
+	apiResourcesDir := getAPIResourcesDir(bundleRootDir)
+	resourceDir := bundleRootDir + "/.kbk"
 
-	whoami, err := user.Current()
+	apiserverVersion, apiserverVersionSource := replayAPIServerVersion(bundleRootDir, apiResourcesDir)
+	mapper, err := newRegistryMapper(apiserverVersion)
 	if err != nil {
-		log.Fatalf("Failed to get current user: %s", err)
+		log.Fatal(err)
 	}
 
-	// Fix directory permissions on linux
-	hostOS := runtime.GOOS
-	if hostOS == "linux" {
-		cmd = exec.Command("/bin/sh", "-c", "sudo chown -R "+whoami.Username+" "+resourceDir+"/db")
-		if err := cmd.Run(); err != nil {
-			log.Fatalf("Failed to chown directory to user %s: %s", whoami.Username, err)
+	if options.ExplainKeys {
+		if apiserverVersion != "" {
+			fmt.Printf("Apiserver version: %s (from %s)\n\n", apiserverVersion, apiserverVersionSource)
+		} else {
+			fmt.Printf("Apiserver version: unknown; pass --apiserver-version to skip resources it does not serve\n\n")
 		}
+		_, plan := readKubernetesResources(apiResourcesDir, mapper)
+		printRegistryPlan(plan)
+		return
 	}
 
-	log.Println("Adding cluster resources")
-
-	database, err := sql.Open("sqlite3", resourceDir+"/db/state.db")
+	provider, state, err := bundleClusterProvider(bundleRootDir)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	defer database.Close()
+	if options.Recreate {
+		if state != nil {
+			deleteKubernetesCluster(provider)
+		}
+		if _, err := os.Stat(resourceDir); err == nil {
+			deleteResourceDir(resourceDir)
+		}
+		if provider, err = newClusterProvider(bundleClusterName(bundleRootDir)); err != nil {
+			log.Fatal(err)
+		}
+		state = nil
+	}
 
-	kubernetesSQLBackendData, err := ioutil.ReadFile(kubernetesResourcesSQL)
-	if err != nil {
-		log.Fatalf("Failed opening file: %s", err)
+	switch {
+	case state == nil:
+		state = &BundleState{ClusterName: provider.Name(), CreatedAt: time.Now()}
+	case state.Phase == phaseClusterStarted:
+		running, err := provider.Running()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if running {
+			log.Printf("k3d cluster %s is already up; pass --recreate to rebuild it from the bundle\n", provider.Name())
+			return
+		}
+		log.Printf("Starting k3d cluster %s\n", provider.Name())
+		if err := provider.Start(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case state.Phase == "" || state.Phase == phaseResourcesRead || state.Phase == phaseResourcesLoaded:
+		log.Printf("Resuming bunk up of cluster %s after phase %s\n", provider.Name(), valueOrUnknown(state.Phase))
+	default:
+		log.Fatalf("Cannot resume bunk up of cluster %s after phase %s; rebuild it with `bunk up --recreate`\n", provider.Name(), state.Phase)
 	}
 
-	if result, err := database.Exec(string(kubernetesSQLBackendData)); err != nil {
-		log.Fatalf("Error executing query %v: %v", result, err)
+	initConfigDir(bundleRootDir)
+	if err := registerBundle(bundleRootDir); err != nil {
+		log.Printf("Failed to record bundle in %s; bunk status --all will not list it: %v\n", bundleRegistryPath(), err)
 	}
 
-	// Fix directory permissions on linux
-	if hostOS == "linux" {
-		cmd = exec.Command("/bin/sh", "-c", "sudo chown -R root "+resourceDir+"/db")
-		if err := cmd.Run(); err != nil {
-			log.Fatalf("Failed to chown directory to user root: %s", err)
+	// saveState records each completed phase so that an interrupted up can resume after it
+	saveState := func(phase string) {
+		state.Phase = phase
+		if err := writeBundleState(resourceDir, state); err != nil {
+			log.Fatalf("Failed to write bundle state: %v\n", err)
 		}
 	}
 
-	log.Println("Starting k3d cluster")
-	cmd = exec.Command("k3d",
-		"start",
-		"--all",
-	)
-	if err := cmd.Run(); err != nil {
-		log.Fatal(err)
+	log.Printf("Bundle root dir: %s\n", bundleRootDir)
+	log.Printf("api-resources dir: %s\n", apiResourcesDir)
+	if apiserverVersion != "" {
+		log.Printf("Apiserver version: %s (from %s)\n", apiserverVersion, apiserverVersionSource)
 	}
 
-	log.Printf("k3d cluster created! Please access the cluster with:\nexport KUBECONFIG=\"$(k3d get-kubeconfig --name='k3s-default')\"\n")
-}
+	// A previous up may have been interrupted while creating the cluster, after k3s wrote to the
+	// database; start over from a fresh one
+	if state.Phase == phaseResourcesLoaded {
+		resetKineDatabase(provider, resourceDir)
+		state.Phase = phaseResourcesRead
+	}
 
-func up() {
-	bundleRootDir := getBundleRootDir()
-	apiResourcesDir := getAPIResourcesDir(bundleRootDir)
-	resourceDir := initConfigDir(bundleRootDir)
+	rows, plan := readKubernetesResources(apiResourcesDir, mapper)
+	rows = append(rows, readOnlyRBACRows(mapper)...)
 
-	log.Printf("Bundle root dir: %s\n", bundleRootDir)
-	log.Printf("api-resources dir: %s\n", apiResourcesDir)
+	// Pretty colors rock!
+	green := color.New(color.FgGreen).PrintfFunc()
+	yellow := color.New(color.FgYellow).PrintfFunc()
 
-	kubernetesResourcesSQL := writeKubernetesResources(bundleRootDir, apiResourcesDir, resourceDir)
+	// Give the people some nice output
+	for _, entry := range plan {
+		if entry.Skip != "" {
+			yellow("Skipping resource file %s: %s\n", filepath.Base(entry.File), entry.Skip)
+		} else {
+			green("Writing %d resources from file %s to /registry/%s/\n", entry.Objects, filepath.Base(entry.File), entry.Prefix)
+		}
+	}
+
+	if options.EmitSQL {
+		kubernetesResourcesSQL := resourceDir + "/kubernetesResources.sql"
+		if err := writeKineSQL(kubernetesResourcesSQL, rows); err != nil {
+			log.Fatalf("Failed to write %s: %v", kubernetesResourcesSQL, err)
+		}
+		log.Printf("Wrote kine SQL to %s\n", kubernetesResourcesSQL)
+	}
 
-	createKubernetesCluster(kubernetesResourcesSQL, resourceDir)
+	if state.Phase == "" {
+		if err := writeReadOnlyToken(resourceDir); err != nil {
+			log.Fatalf("Failed to write read-only token: %v\n", err)
+		}
+		saveState(phaseResourcesRead)
+	}
+
+	if state.Phase == phaseResourcesRead {
+		failures := loadKubernetesResources(rows, resourceDir)
+		state.Files = bundleStateFiles(bundleRootDir, plan, failures)
+		saveState(phaseResourcesLoaded)
+	}
+
+	if state.Phase == phaseResourcesLoaded {
+		createKubernetesCluster(provider, resourceDir)
+		saveState(phaseClusterStarted)
+	}
 }
 
 func init() {
@@ -473,4 +444,11 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// upCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	upCmd.Flags().String("mode", "k3d", "How to replay the bundle: k3d, or local to serve it read-only from an in-process API server without Docker")
+	upCmd.Flags().Bool("emit-sql", false, "Also write the kine rows as SQL statements to .kbk/kubernetesResources.sql for debugging")
+	upCmd.Flags().Bool("recreate", false, "Delete the bundle's cluster and .kbk dir, if any, and rebuild them from scratch")
+	upCmd.Flags().Bool("explain-keys", false, "Print which resource files map to which registry prefixes, and which are skipped, then exit")
+	upCmd.Flags().String("apiserver-version", "", "Kubernetes version of the replay cluster's apiserver, used to skip resources it does not serve (default: the version of k3d's k3s image, else of the bundle's cluster)")
+	viper.BindPFlag("registry.apiserverVersion", upCmd.Flags().Lookup("apiserver-version"))
+	upCmd.Flags().String("listen", "127.0.0.1:0", "Address for the local API server to listen on (--mode=local only)")
 }
@@ -0,0 +1,654 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// localAPIResource : A resource served by the local API server, built from one api-resources file
+type localAPIResource struct {
+	Group      string
+	Version    string
+	Resource   string
+	Kind       string
+	Namespaced bool
+	Objects    []map[string]interface{}
+}
+
+// GroupVersion returns the resource's apiVersion, e.g. v1 or apps/v1
+func (r *localAPIResource) GroupVersion() string {
+	if r.Group == "" {
+		return r.Version
+	}
+	return r.Group + "/" + r.Version
+}
+
+// localAPIServer : A read-only Kubernetes-style API server for the objects of a bundle
+type localAPIServer struct {
+	address   string
+	version   string
+	resources []*localAPIResource
+	// byPath indexes resources by group/version/resource
+	byPath map[string]*localAPIResource
+}
+
+// newLocalAPIServer indexes the bundle's objects by group, version and resource
+func newLocalAPIServer(resources *BundleResources) *localAPIServer {
+	server := &localAPIServer{
+		version: "v0.0.0",
+		byPath:  map[string]*localAPIResource{},
+	}
+
+	namespaces := map[string]bool{}
+	for _, file := range resources.Files {
+		if len(file.Objects) == 0 {
+			continue
+		}
+
+		group, version := "", file.Objects[0].APIVersion
+		if i := strings.Index(version, "/"); i >= 0 {
+			group, version = version[:i], version[i+1:]
+		}
+		key := group + "/" + version + "/" + file.Resource
+		if _, ok := server.byPath[key]; ok {
+			continue
+		}
+
+		resource := &localAPIResource{
+			Group:    group,
+			Version:  version,
+			Resource: file.Resource,
+			Kind:     file.Objects[0].Kind,
+		}
+		for _, object := range file.Objects {
+			var content map[string]interface{}
+			if err := object.Decode(&content); err != nil {
+				continue
+			}
+			if object.Metadata.Namespace != "" {
+				resource.Namespaced = true
+				namespaces[object.Metadata.Namespace] = true
+			}
+			resource.Objects = append(resource.Objects, content)
+		}
+
+		server.resources = append(server.resources, resource)
+		server.byPath[key] = resource
+	}
+
+	// Bundles do not always include namespaces; serve the ones objects live in
+	if _, ok := server.byPath["/v1/namespaces"]; !ok {
+		resource := &localAPIResource{Version: "v1", Resource: "namespaces", Kind: "Namespace"}
+		var names []string
+		for name := range namespaces {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			resource.Objects = append(resource.Objects, map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Namespace",
+				"metadata":   map[string]interface{}{"name": name},
+				"status":     map[string]interface{}{"phase": "Active"},
+			})
+		}
+		server.resources = append(server.resources, resource)
+		server.byPath["/v1/namespaces"] = resource
+	}
+
+	// Report the cluster's Kubernetes version from its kubelets
+	for _, object := range resources.Objects("nodes", "") {
+		var node struct {
+			Status struct {
+				NodeInfo struct {
+					KubeletVersion string `json:"kubeletVersion"`
+				} `json:"nodeInfo"`
+			} `json:"status"`
+		}
+		if err := object.Decode(&node); err == nil && node.Status.NodeInfo.KubeletVersion != "" {
+			server.version = node.Status.NodeInfo.KubeletVersion
+			break
+		}
+	}
+
+	return server
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
+
+// writeStatus writes a Kubernetes Status error response
+func writeStatus(w http.ResponseWriter, code int, reason string, message string) {
+	writeJSON(w, code, map[string]interface{}{
+		"kind":       "Status",
+		"apiVersion": "v1",
+		"metadata":   map[string]interface{}{},
+		"status":     "Failure",
+		"message":    message,
+		"reason":     reason,
+		"code":       code,
+	})
+}
+
+func (s *localAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeStatus(w, http.StatusMethodNotAllowed, "MethodNotAllowed",
+			fmt.Sprintf("%s is not allowed: the bunk local API server is read-only", r.Method))
+		return
+	}
+
+	path := strings.Trim(r.URL.Path, "/")
+	segments := strings.Split(path, "/")
+
+	switch {
+	case path == "version":
+		s.serveVersion(w)
+	case path == "api":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"kind":     "APIVersions",
+			"versions": []string{"v1"},
+			"serverAddressByClientCIDRs": []map[string]string{
+				{"clientCIDR": "0.0.0.0/0", "serverAddress": s.address},
+			},
+		})
+	case path == "apis":
+		s.serveGroups(w)
+	case path == "healthz" || path == "livez" || path == "readyz":
+		w.Write([]byte("ok"))
+	case segments[0] == "api" && len(segments) >= 2:
+		s.serveResources(w, r, "", segments[1], segments[2:])
+	case segments[0] == "apis" && len(segments) >= 3:
+		s.serveResources(w, r, segments[1], segments[2], segments[3:])
+	default:
+		writeStatus(w, http.StatusNotFound, "NotFound", fmt.Sprintf("the server could not find the requested resource %s", r.URL.Path))
+	}
+}
+
+func (s *localAPIServer) serveVersion(w http.ResponseWriter) {
+	major, minor := "", ""
+	if m := regexp.MustCompile(`^v(\d+)\.(\d+)`).FindStringSubmatch(s.version); m != nil {
+		major, minor = m[1], m[2]
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"major":      major,
+		"minor":      minor,
+		"gitVersion": s.version,
+		"platform":   "bunk/local",
+	})
+}
+
+func (s *localAPIServer) serveGroups(w http.ResponseWriter) {
+	versions := map[string][]string{}
+	var groups []string
+	for _, resource := range s.resources {
+		if resource.Group == "" {
+			continue
+		}
+		if _, ok := versions[resource.Group]; !ok {
+			groups = append(groups, resource.Group)
+		}
+		found := false
+		for _, version := range versions[resource.Group] {
+			found = found || version == resource.Version
+		}
+		if !found {
+			versions[resource.Group] = append(versions[resource.Group], resource.Version)
+		}
+	}
+	sort.Strings(groups)
+
+	groupList := []map[string]interface{}{}
+	for _, group := range groups {
+		var groupVersions []map[string]string
+		for _, version := range versions[group] {
+			groupVersions = append(groupVersions, map[string]string{"groupVersion": group + "/" + version, "version": version})
+		}
+		groupList = append(groupList, map[string]interface{}{
+			"name":             group,
+			"versions":         groupVersions,
+			"preferredVersion": groupVersions[0],
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"kind":       "APIGroupList",
+		"apiVersion": "v1",
+		"groups":     groupList,
+	})
+}
+
+// serveResources handles discovery, list, get and watch requests below a group version
+func (s *localAPIServer) serveResources(w http.ResponseWriter, r *http.Request, group string, version string, segments []string) {
+	if len(segments) == 0 {
+		s.serveDiscovery(w, group, version)
+		return
+	}
+
+	namespace := ""
+	if segments[0] == "namespaces" && len(segments) >= 3 {
+		namespace, segments = segments[1], segments[2:]
+	}
+	if len(segments) > 2 {
+		writeStatus(w, http.StatusNotFound, "NotFound", fmt.Sprintf("subresource %s is not served by the bunk local API server", segments[2]))
+		return
+	}
+
+	resource, ok := s.byPath[group+"/"+version+"/"+segments[0]]
+	if !ok {
+		writeStatus(w, http.StatusNotFound, "NotFound", fmt.Sprintf("the server could not find the requested resource %s", r.URL.Path))
+		return
+	}
+
+	if len(segments) == 2 {
+		name := segments[1]
+		for _, object := range resource.Objects {
+			if objectName(object) == name && objectNamespace(object) == namespace {
+				writeJSON(w, http.StatusOK, object)
+				return
+			}
+		}
+		writeStatus(w, http.StatusNotFound, "NotFound", fmt.Sprintf("%s %q not found", resource.Resource, name))
+		return
+	}
+
+	labelSelector, err := parseLabelSelector(r.URL.Query().Get("labelSelector"))
+	if err != nil {
+		writeStatus(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+	fieldSelector, err := parseFieldSelector(r.URL.Query().Get("fieldSelector"))
+	if err != nil {
+		writeStatus(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+
+	items := []map[string]interface{}{}
+	for _, object := range resource.Objects {
+		if namespace != "" && objectNamespace(object) != namespace {
+			continue
+		}
+		if !labelSelector.matches(objectLabels(object)) || !fieldSelector.matches(object) {
+			continue
+		}
+		items = append(items, object)
+	}
+
+	if watch := r.URL.Query().Get("watch"); watch == "true" || watch == "1" {
+		s.serveWatch(w, r, items)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"kind":       resource.Kind + "List",
+		"apiVersion": resource.GroupVersion(),
+		"metadata":   map[string]interface{}{"resourceVersion": "1"},
+		"items":      items,
+	})
+}
+
+func (s *localAPIServer) serveDiscovery(w http.ResponseWriter, group string, version string) {
+	resources := []map[string]interface{}{}
+	for _, resource := range s.resources {
+		if resource.Group != group || resource.Version != version {
+			continue
+		}
+		resources = append(resources, map[string]interface{}{
+			"name":         resource.Resource,
+			"singularName": strings.ToLower(resource.Kind),
+			"namespaced":   resource.Namespaced,
+			"kind":         resource.Kind,
+			"verbs":        []string{"get", "list", "watch"},
+		})
+	}
+	if len(resources) == 0 {
+		writeStatus(w, http.StatusNotFound, "NotFound", fmt.Sprintf("group version %s/%s is not served", group, version))
+		return
+	}
+
+	groupVersion := version
+	if group != "" {
+		groupVersion = group + "/" + version
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"kind":         "APIResourceList",
+		"apiVersion":   "v1",
+		"groupVersion": groupVersion,
+		"resources":    resources,
+	})
+}
+
+// serveWatch sends an ADDED event for each item, unless the client is resuming
+// from a list, then holds the connection open; the bundle never changes
+func (s *localAPIServer) serveWatch(w http.ResponseWriter, r *http.Request, items []map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	if rv := r.URL.Query().Get("resourceVersion"); rv == "" || rv == "0" {
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		for _, item := range items {
+			if err := enc.Encode(map[string]interface{}{"type": "ADDED", "object": item}); err != nil {
+				return
+			}
+		}
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	timeout := time.Duration(1<<63 - 1)
+	if seconds, err := strconv.Atoi(r.URL.Query().Get("timeoutSeconds")); err == nil && seconds > 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+	select {
+	case <-r.Context().Done():
+	case <-time.After(timeout):
+	}
+}
+
+func objectMetadata(object map[string]interface{}) map[string]interface{} {
+	metadata, _ := object["metadata"].(map[string]interface{})
+	return metadata
+}
+
+func objectName(object map[string]interface{}) string {
+	name, _ := objectMetadata(object)["name"].(string)
+	return name
+}
+
+func objectNamespace(object map[string]interface{}) string {
+	namespace, _ := objectMetadata(object)["namespace"].(string)
+	return namespace
+}
+
+func objectLabels(object map[string]interface{}) map[string]string {
+	labels := map[string]string{}
+	raw, _ := objectMetadata(object)["labels"].(map[string]interface{})
+	for key, value := range raw {
+		labels[key] = fieldString(value)
+	}
+	return labels
+}
+
+// selectorRequirement : A single term of a label or field selector
+type selectorRequirement struct {
+	Key      string
+	Operator string
+	Values   []string
+}
+
+// labelSelector : A parsed label selector; all requirements must match
+type labelSelector []selectorRequirement
+
+// setRequirementPattern matches set-based label requirements such as `tier in (a, b)`
+var setRequirementPattern = regexp.MustCompile(`^(\S+)\s+(in|notin)\s+\((.*)\)$`)
+
+// labelKeyPattern and labelValuePattern match valid label keys, with an optional prefix, and values
+var (
+	labelKeyPattern   = regexp.MustCompile(`^([a-z0-9]([-a-z0-9.]*[a-z0-9])?/)?[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+	labelValuePattern = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?)?$`)
+)
+
+// splitSelector splits a selector on commas outside of parentheses
+func splitSelector(selector string) []string {
+	var terms []string
+	depth, start := 0, 0
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	terms = append(terms, selector[start:])
+	return terms
+}
+
+// parseLabelSelector parses equality- and set-based label selectors
+func parseLabelSelector(selector string) (labelSelector, error) {
+	var requirements labelSelector
+	if strings.TrimSpace(selector) == "" {
+		return requirements, nil
+	}
+
+	for _, term := range splitSelector(selector) {
+		term = strings.TrimSpace(term)
+		if m := setRequirementPattern.FindStringSubmatch(term); m != nil {
+			var values []string
+			for _, value := range strings.Split(m[3], ",") {
+				values = append(values, strings.TrimSpace(value))
+			}
+			requirements = append(requirements, selectorRequirement{m[1], m[2], values})
+			continue
+		}
+		if requirement, ok := parseEqualityRequirement(term); ok {
+			requirements = append(requirements, requirement)
+			continue
+		}
+		if strings.HasPrefix(term, "!") {
+			requirements = append(requirements, selectorRequirement{Key: strings.TrimSpace(term[1:]), Operator: "!"})
+		} else {
+			requirements = append(requirements, selectorRequirement{Key: term, Operator: "exists"})
+		}
+	}
+
+	// Reject what the apiserver would, rather than quietly matching nothing
+	for _, requirement := range requirements {
+		if !labelKeyPattern.MatchString(requirement.Key) {
+			return nil, fmt.Errorf("invalid label selector %q: invalid key %q", selector, requirement.Key)
+		}
+		for _, value := range requirement.Values {
+			if !labelValuePattern.MatchString(value) {
+				return nil, fmt.Errorf("invalid label selector %q: invalid value %q", selector, value)
+			}
+		}
+	}
+	return requirements, nil
+}
+
+// parseEqualityRequirement parses key=value, key==value and key!=value terms
+func parseEqualityRequirement(term string) (selectorRequirement, bool) {
+	for _, operator := range []string{"!=", "==", "="} {
+		if i := strings.Index(term, operator); i > 0 {
+			op := operator
+			if op == "==" {
+				op = "="
+			}
+			return selectorRequirement{
+				Key:      strings.TrimSpace(term[:i]),
+				Operator: op,
+				Values:   []string{strings.TrimSpace(term[i+len(operator):])},
+			}, true
+		}
+	}
+	return selectorRequirement{}, false
+}
+
+func (s labelSelector) matches(labels map[string]string) bool {
+	for _, requirement := range s {
+		value, ok := labels[requirement.Key]
+		if !requirement.matches(value, ok) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r selectorRequirement) matches(value string, ok bool) bool {
+	in := false
+	for _, v := range r.Values {
+		in = in || v == value
+	}
+	switch r.Operator {
+	case "exists":
+		return ok
+	case "!":
+		return !ok
+	case "=", "in":
+		return ok && in
+	case "!=", "notin":
+		return !ok || !in
+	}
+	return false
+}
+
+// fieldSelector : A parsed field selector such as metadata.name=foo,status.phase!=Running
+type fieldSelector []selectorRequirement
+
+func parseFieldSelector(selector string) (fieldSelector, error) {
+	var requirements fieldSelector
+	if strings.TrimSpace(selector) == "" {
+		return requirements, nil
+	}
+	for _, term := range strings.Split(selector, ",") {
+		requirement, ok := parseEqualityRequirement(strings.TrimSpace(term))
+		if !ok {
+			return nil, fmt.Errorf("invalid field selector %q", selector)
+		}
+		requirements = append(requirements, requirement)
+	}
+	return requirements, nil
+}
+
+func (s fieldSelector) matches(object map[string]interface{}) bool {
+	for _, requirement := range s {
+		steps, err := parseFieldPath(requirement.Key)
+		if err != nil {
+			return false
+		}
+		value := ""
+		if values := selectFields(object, steps); len(values) > 0 {
+			value = fieldString(values[0])
+		}
+		// Unset fields compare as empty strings, as they do in the apiserver
+		if !requirement.matches(value, true) {
+			return false
+		}
+	}
+	return true
+}
+
+// localKubeconfig returns a kubeconfig for the local API server at address
+func localKubeconfig(address string) ([]byte, error) {
+	return yaml.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Config",
+		"clusters": []interface{}{
+			map[string]interface{}{
+				"name":    "bunk-local",
+				"cluster": map[string]interface{}{"server": "http://" + address},
+			},
+		},
+		"contexts": []interface{}{
+			map[string]interface{}{
+				"name":    "bunk-local",
+				"context": map[string]interface{}{"cluster": "bunk-local", "user": "bunk-local"},
+			},
+		},
+		"current-context": "bunk-local",
+		"users": []interface{}{
+			map[string]interface{}{
+				"name": "bunk-local",
+				"user": map[string]interface{}{},
+			},
+		},
+	})
+}
+
+// upLocal serves the bundle's resources from an in-process, read-only API
+// server until interrupted, writing a kubeconfig for it into .kbk
+func upLocal(listenAddress string) {
+	bundleRootDir := getBundleRootDir()
+	apiResourcesDir := getAPIResourcesDir(bundleRootDir)
+
+	resourceDir := bundleRootDir + "/.kbk"
+	if err := os.MkdirAll(resourceDir, 0774); err != nil {
+		log.Fatalf("Failed to create .kbk directory at %s: %s\n", bundleRootDir, err)
+	}
+
+	log.Printf("Bundle root dir: %s\n", bundleRootDir)
+	log.Printf("api-resources dir: %s\n", apiResourcesDir)
+
+	resources := loadBundleResources(bundleRootDir, apiResourcesDir)
+	for file, err := range resources.Invalid {
+		log.Printf("Skipping invalid resource file %s: %v\n", file, err)
+	}
+	server := newLocalAPIServer(resources)
+
+	listener, err := net.Listen("tcp", listenAddress)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v\n", listenAddress, err)
+	}
+	server.address = listener.Addr().String()
+
+	kubeconfig, err := localKubeconfig(server.address)
+	if err != nil {
+		log.Fatal(err)
+	}
+	kubeconfigPath := resourceDir + "/kubeconfig-local.yaml"
+	if err := ioutil.WriteFile(kubeconfigPath, kubeconfig, 0600); err != nil {
+		log.Fatalf("Failed to write kubeconfig %s: %v\n", kubeconfigPath, err)
+	}
+
+	httpServer := &http.Server{Handler: server}
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		log.Println("Stopping local API server")
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(ctx)
+	}()
+
+	log.Printf("Serving %d resources on http://%s (read-only). Press Ctrl-C to stop.\n", resources.Count(), server.address)
+	log.Printf("Please access the cluster with:\nexport KUBECONFIG=\"%s\"\n", kubeconfigPath)
+
+	if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	os.Remove(kubeconfigPath)
+}
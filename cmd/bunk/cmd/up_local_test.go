@@ -0,0 +1,252 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseLabelSelector(t *testing.T) {
+	labels := map[string]string{"app": "web", "tier": "frontend", "example.com/team": "payments"}
+	tests := []struct {
+		selector string
+		want     string
+		matches  bool
+		err      bool
+	}{
+		{selector: "", want: "[]", matches: true},
+		{selector: "app=web", want: "[{app = [web]}]", matches: true},
+		{selector: "app==web", want: "[{app = [web]}]", matches: true},
+		{selector: "app = db", want: "[{app = [db]}]", matches: false},
+		{selector: "app!=db", want: "[{app != [db]}]", matches: true},
+		{selector: "canary!=true", want: "[{canary != [true]}]", matches: true},
+		{selector: "tier in (frontend, backend)", want: "[{tier in [frontend backend]}]", matches: true},
+		{selector: "tier notin (frontend,backend)", want: "[{tier notin [frontend backend]}]", matches: false},
+		{selector: "canary notin (true)", want: "[{canary notin [true]}]", matches: true},
+		{selector: "example.com/team", want: "[{example.com/team exists []}]", matches: true},
+		{selector: "!canary", want: "[{canary ! []}]", matches: true},
+		{selector: "! app", want: "[{app ! []}]", matches: false},
+		{selector: "app=web,tier in (frontend),!canary", want: "[{app = [web]} {tier in [frontend]} {canary ! []}]", matches: true},
+		{selector: "app=web,,tier", err: true},
+		{selector: "app=web,", err: true},
+		{selector: "=web", err: true},
+		{selector: "app=web=db", err: true},
+		{selector: "tier in (frontend", err: true},
+		{selector: "tier in frontend", err: true},
+		{selector: "tier in (front end)", err: true},
+		{selector: "!", err: true},
+	}
+
+	for _, test := range tests {
+		got, err := parseLabelSelector(test.selector)
+		if test.err {
+			if err == nil {
+				t.Errorf("parseLabelSelector(%q) = %v; want an error", test.selector, got)
+			}
+			continue
+		}
+		if err != nil || fmt.Sprint(got) != test.want {
+			t.Errorf("parseLabelSelector(%q) = %v, %v; want %s", test.selector, got, err, test.want)
+			continue
+		}
+		if matches := got.matches(labels); matches != test.matches {
+			t.Errorf("%q matches %v = %v; want %v", test.selector, labels, matches, test.matches)
+		}
+	}
+}
+
+func TestParseFieldSelector(t *testing.T) {
+	object := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "web-1", "namespace": "default"},
+		"spec":     map[string]interface{}{"nodeName": "node-1"},
+		"status":   map[string]interface{}{"phase": "Running"},
+	}
+	tests := []struct {
+		selector string
+		want     string
+		matches  bool
+		err      bool
+	}{
+		{selector: "", want: "[]", matches: true},
+		{selector: "metadata.name=web-1", want: "[{metadata.name = [web-1]}]", matches: true},
+		{selector: "metadata.name==web-2", want: "[{metadata.name = [web-2]}]", matches: false},
+		{selector: "status.phase!=Running", want: "[{status.phase != [Running]}]", matches: false},
+		{selector: "spec.nodeName=node-1, metadata.namespace=default", want: "[{spec.nodeName = [node-1]} {metadata.namespace = [default]}]", matches: true},
+		// Unset fields compare as empty strings
+		{selector: "spec.schedulerName=", want: "[{spec.schedulerName = []}]", matches: true},
+		{selector: "spec.schedulerName!=default-scheduler", want: "[{spec.schedulerName != [default-scheduler]}]", matches: true},
+		{selector: "metadata.name", err: true},
+		{selector: "!metadata.name", err: true},
+		{selector: "=web-1", err: true},
+		{selector: "metadata.name=web-1,", err: true},
+		{selector: "metadata.name in (web-1)", err: true},
+	}
+
+	for _, test := range tests {
+		got, err := parseFieldSelector(test.selector)
+		if test.err {
+			if err == nil {
+				t.Errorf("parseFieldSelector(%q) = %v; want an error", test.selector, got)
+			}
+			continue
+		}
+		if err != nil || fmt.Sprint(got) != test.want {
+			t.Errorf("parseFieldSelector(%q) = %v, %v; want %s", test.selector, got, err, test.want)
+			continue
+		}
+		if matches := got.matches(object); matches != test.matches {
+			t.Errorf("%q matches the pod = %v; want %v", test.selector, matches, test.matches)
+		}
+	}
+}
+
+func TestLocalAPIServer(t *testing.T) {
+	bundleRootDir := writeTestBundle(t, map[string]string{
+		"api-resources/pods.yaml": `items:
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: web-1
+    namespace: default
+    labels:
+      app: web
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: web-1
+    namespace: staging
+    labels:
+      app: web
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: db-1
+    namespace: default
+    labels:
+      app: db
+`,
+		"api-resources/deployments.apps.yaml": `items:
+- apiVersion: apps/v1
+  kind: Deployment
+  metadata:
+    name: web
+    namespace: default
+`,
+		"api-resources/nodes.yaml": `items:
+- apiVersion: v1
+  kind: Node
+  metadata:
+    name: node-1
+  status:
+    nodeInfo:
+      kubeletVersion: v1.18.6+k3s1
+`,
+	})
+	defer os.RemoveAll(bundleRootDir)
+
+	server := httptest.NewServer(newLocalAPIServer(loadBundleResources(bundleRootDir, filepath.Join(bundleRootDir, "api-resources"))))
+	defer server.Close()
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+		// want is the kind of the response, and the namespace/name of the object or each item of a list
+		want string
+	}{
+		{path: "/version", code: http.StatusOK, want: "v1.18.6+k3s1"},
+		{path: "/api/v1/pods", code: http.StatusOK, want: "PodList [default/web-1 staging/web-1 default/db-1]"},
+		{path: "/api/v1/pods?labelSelector=app%3Dweb", code: http.StatusOK, want: "PodList [default/web-1 staging/web-1]"},
+		{path: "/api/v1/pods?fieldSelector=metadata.namespace%3Dstaging", code: http.StatusOK, want: "PodList [staging/web-1]"},
+		{path: "/api/v1/namespaces/default/pods", code: http.StatusOK, want: "PodList [default/web-1 default/db-1]"},
+		{path: "/api/v1/namespaces/default/pods?labelSelector=app+notin+(web)", code: http.StatusOK, want: "PodList [default/db-1]"},
+		{path: "/api/v1/namespaces/kube-system/pods", code: http.StatusOK, want: "PodList []"},
+		{path: "/api/v1/namespaces/staging/pods/web-1", code: http.StatusOK, want: "Pod staging/web-1"},
+		{path: "/api/v1/nodes/node-1", code: http.StatusOK, want: "Node /node-1"},
+		{path: "/apis/apps/v1/namespaces/default/deployments/web", code: http.StatusOK, want: "Deployment default/web"},
+		// Namespaces are served from the ones objects live in when the bundle has none
+		{path: "/api/v1/namespaces", code: http.StatusOK, want: "NamespaceList [/default /staging]"},
+		{path: "/api/v1/namespaces/staging", code: http.StatusOK, want: "Namespace /staging"},
+		{path: "/api/v1/namespaces/default/pods/web-2", code: http.StatusNotFound, want: "Status"},
+		{path: "/api/v1/namespaces/kube-system/pods/web-1", code: http.StatusNotFound, want: "Status"},
+		{path: "/api/v1/secrets", code: http.StatusNotFound, want: "Status"},
+		{path: "/apis/batch/v1/jobs", code: http.StatusNotFound, want: "Status"},
+		{path: "/api/v1/namespaces/default/pods/web-1/log", code: http.StatusNotFound, want: "Status"},
+		{path: "/api/v1/pods?labelSelector=app+in+(web", code: http.StatusBadRequest, want: "Status"},
+		{path: "/api/v1/pods?fieldSelector=metadata.name", code: http.StatusBadRequest, want: "Status"},
+		{method: http.MethodDelete, path: "/api/v1/namespaces/default/pods/web-1", code: http.StatusMethodNotAllowed, want: "Status"},
+	}
+
+	for _, test := range tests {
+		method := test.method
+		if method == "" {
+			method = http.MethodGet
+		}
+		req, err := http.NewRequest(method, server.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Kind       string
+			GitVersion string
+			Metadata   objectMeta
+			Items      []struct{ Metadata objectMeta }
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			t.Errorf("%s %s: %v", method, test.path, err)
+			continue
+		}
+
+		got := body.Kind
+		switch {
+		case body.GitVersion != "":
+			got = body.GitVersion
+		case body.Items != nil:
+			var names []string
+			for _, item := range body.Items {
+				names = append(names, item.Metadata.String())
+			}
+			got = fmt.Sprintf("%s %v", body.Kind, names)
+		case body.Metadata.Name != "":
+			got += " " + body.Metadata.String()
+		}
+		if resp.StatusCode != test.code || got != test.want {
+			t.Errorf("%s %s = %d %s; want %d %s", method, test.path, resp.StatusCode, got, test.code, test.want)
+		}
+	}
+}
+
+// objectMeta : The parts of an object's metadata TestLocalAPIServer compares
+type objectMeta struct {
+	Name      string
+	Namespace string
+}
+
+func (m objectMeta) String() string {
+	return m.Namespace + "/" + m.Name
+}
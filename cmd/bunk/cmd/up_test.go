@@ -0,0 +1,152 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestUpResumesAfterLastPhase(t *testing.T) {
+	bundleRootDir := writeTestBundle(t, map[string]string{
+		"api-resources/pods.yaml":  "items:\n- apiVersion: v1\n  kind: Pod\n  metadata:\n    name: web\n    namespace: default\n",
+		"api-resources/nodes.yaml": "items:\n- apiVersion: v1\n  kind: Node\n  metadata:\n    name: node-1\n  status:\n    nodeInfo:\n      kubeletVersion: v1.18.6\n",
+	})
+	defer os.RemoveAll(bundleRootDir)
+	resourceDir := filepath.Join(bundleRootDir, ".kbk")
+
+	defer os.Setenv("BUNK_BUNDLE_DIR", os.Getenv("BUNK_BUNDLE_DIR"))
+	os.Setenv("BUNK_BUNDLE_DIR", bundleRootDir)
+	defer viper.Set("ticketsDir", nil)
+	viper.Set("ticketsDir", bundleRootDir)
+
+	// The cluster is never running, so that bunk up starts it
+	dir, restore := fakeCommands(t, map[string]string{
+		"k3d":    `[ "$1" = version ] && echo "k3d version v5.4.6"; true`,
+		"docker": "echo false",
+	})
+	defer restore()
+	resetK3dVersion()
+	defer resetK3dVersion()
+	name := bundleClusterName(bundleRootDir)
+
+	tests := []struct {
+		description string
+		phase       string
+		options     upOptions
+		// prepare changes the bundle's .kbk dir before bunk up runs
+		prepare func() error
+		want    []string
+	}{
+		{
+			description: "a new bundle",
+			want:        []string{"cluster create " + name},
+		},
+		{
+			description: "a stopped cluster",
+			phase:       phaseClusterStarted,
+			want:        []string{"cluster start " + name + " --wait"},
+		},
+		{
+			// k3s may have written to the database while the cluster was created
+			description: "an interrupted cluster create",
+			phase:       phaseResourcesLoaded,
+			want:        []string{"cluster delete " + name, "cluster create " + name},
+		},
+		{
+			description: "an interrupted load",
+			phase:       phaseResourcesRead,
+			prepare: func() error {
+				return os.Remove(filepath.Join(resourceDir, "db", "state.db"))
+			},
+			want: []string{"cluster create " + name},
+		},
+		{
+			description: "--recreate",
+			phase:       phaseClusterStarted,
+			options:     upOptions{Recreate: true},
+			want:        []string{"cluster delete " + name, "cluster create " + name},
+		},
+	}
+
+	for _, test := range tests {
+		var token string
+		if test.phase != "" {
+			state, err := readBundleState(resourceDir)
+			if err != nil || state == nil {
+				t.Fatalf("%s: no state recorded: %v", test.description, err)
+			}
+			state.Phase = test.phase
+			if err := writeBundleState(resourceDir, state); err != nil {
+				t.Fatal(err)
+			}
+			if token, err = readReadOnlyToken(resourceDir); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if test.prepare != nil {
+			if err := test.prepare(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		os.Remove(filepath.Join(dir, "k3d.log"))
+
+		up(test.options)
+
+		// Each run of k3d after detecting its version starts with the wanted arguments
+		var runs []string
+		for _, run := range fakeCommandArgs(t, dir, "k3d") {
+			if run != "version" {
+				runs = append(runs, run)
+			}
+		}
+		ran := len(runs) == len(test.want)
+		for i := 0; ran && i < len(runs); i++ {
+			ran = strings.HasPrefix(runs[i], test.want[i])
+		}
+		if !ran {
+			t.Errorf("bunk up of %s ran k3d %q; want %q", test.description, runs, test.want)
+		}
+
+		state, err := readBundleState(resourceDir)
+		if err != nil || state == nil || state.ClusterName != name || state.Phase != phaseClusterStarted {
+			t.Fatalf("bunk up of %s recorded %+v, %v; want cluster %s started", test.description, state, err, name)
+		}
+		if fmt.Sprint(state.Files) != "[{api-resources/nodes.yaml minions 1 0 } {api-resources/pods.yaml pods 1 0 }]" {
+			t.Errorf("bunk up of %s recorded files %v", test.description, state.Files)
+		}
+		counts, err := countKineRows(filepath.Join(resourceDir, "db", "state.db"), []string{"minions", "pods"})
+		if err != nil || counts["pods"] != 1 || counts["minions"] != 1 {
+			t.Errorf("bunk up of %s loaded %v, %v; want the node and the pod", test.description, counts, err)
+		}
+
+		// Resuming keeps the token handed out already; recreating replaces it
+		newToken, err := readReadOnlyToken(resourceDir)
+		if err != nil || (token != "" && (newToken == token) == test.options.Recreate) {
+			t.Errorf("bunk up of %s changed the read-only token from %q to %q, %v", test.description, token, newToken, err)
+		}
+	}
+
+	if content, err := ioutil.ReadFile(bundleRegistryPath()); err != nil || !strings.Contains(string(content), bundleRootDir) {
+		t.Errorf("bundle registry = %s, %v; want %s recorded", content, err, bundleRootDir)
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the bundle's files against the manifest written when it was extracted",
+	Long: `Check the files of the bundle in the current directory against the manifest bunk extract
+wrote to .bunk/manifest.json, reporting files that are missing, truncated, modified or were
+added since. Exits with 1 if any are found.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		verify()
+	},
+}
+
+// VerifyProblem : A file of a bundle that does not match its manifest
+type VerifyProblem struct {
+	Path    string
+	Problem string
+}
+
+// verifyManifestFile checks a single file listed in the manifest, returning what is wrong with
+// it or "" if nothing is
+func verifyManifestFile(bundleRootDir string, file ManifestFile) (string, error) {
+	path := filepath.Join(bundleRootDir, filepath.FromSlash(file.Path))
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return "missing", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if file.Link != "" {
+		if info.Mode()&os.ModeSymlink == 0 {
+			return "no longer a symlink", nil
+		}
+		link, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if link != file.Link {
+			return fmt.Sprintf("symlink to %s instead of %s", link, file.Link), nil
+		}
+		return "", nil
+	}
+
+	if !info.Mode().IsRegular() {
+		return "no longer a regular file", nil
+	}
+	if info.Size() < file.Size {
+		return fmt.Sprintf("truncated to %d of %d bytes", info.Size(), file.Size), nil
+	}
+	if info.Size() != file.Size {
+		return fmt.Sprintf("size changed from %d to %d bytes", file.Size, info.Size()), nil
+	}
+	sum, err := hashFile(path)
+	if err != nil {
+		return "", err
+	}
+	if sum != file.SHA256 {
+		return "modified", nil
+	}
+	return "", nil
+}
+
+// verifyBundle checks every file of the bundle against its manifest, and looks for files that
+// are not in it
+func verifyBundle(bundleRootDir string, manifest *BundleManifest) ([]VerifyProblem, error) {
+	var problems []VerifyProblem
+	listed := map[string]bool{}
+	for _, file := range manifest.Files {
+		listed[file.Path] = true
+		problem, err := verifyManifestFile(bundleRootDir, file)
+		if err != nil {
+			return nil, err
+		}
+		if problem != "" {
+			problems = append(problems, VerifyProblem{Path: file.Path, Problem: problem})
+		}
+	}
+
+	err := filepath.Walk(bundleRootDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(bundleRootDir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			if isBundleMetadataDir(rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !listed[rel] {
+			problems = append(problems, VerifyProblem{Path: rel, Problem: "not in the manifest"})
+		}
+		return nil
+	})
+	return problems, err
+}
+
+func verify() {
+	bundleRootDir := getBundleRootDir()
+
+	manifest, err := readBundleManifest(bundleRootDir)
+	if err != nil {
+		log.Fatalf("Failed to read manifest of bundle %s: %v\n", bundleRootDir, err)
+	}
+	if manifest == nil {
+		log.Fatalf("Bundle %s has no manifest; extract it with `bunk extract` to record one\n", bundleRootDir)
+	}
+
+	problems, err := verifyBundle(bundleRootDir, manifest)
+	if err != nil {
+		log.Fatalf("Failed to verify bundle %s: %v\n", bundleRootDir, err)
+	}
+	if len(problems) == 0 {
+		color.New(color.FgGreen).Printf("All %d files match the manifest\n", len(manifest.Files))
+		return
+	}
+
+	color.New(color.FgRed).Printf("%d of the bundle's files do not match the manifest:\n", len(problems))
+	table := newPlainTable([]string{"File", "Problem"})
+	for _, problem := range problems {
+		table.Append([]string{problem.Path, problem.Problem})
+	}
+	table.Render()
+	os.Exit(1)
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+}
@@ -0,0 +1,104 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestVerifyBundle(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the bundle has symlinks")
+	}
+	bundleRootDir, recorded := writeTestManifestBundle(t)
+	defer os.RemoveAll(bundleRootDir)
+	// As extracted
+	if err := ioutil.WriteFile(filepath.Join(bundleRootDir, "README"), []byte("BUNDLE\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := buildBundleManifest(bundleRootDir, "bundle.tar.gz", recorded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if problems, err := verifyBundle(bundleRootDir, manifest); err != nil || len(problems) != 0 {
+		t.Fatalf("verifyBundle of the bundle as extracted = %v, %v; want no problems", problems, err)
+	}
+
+	path := func(rel string) string { return filepath.Join(bundleRootDir, filepath.FromSlash(rel)) }
+	for _, change := range []func() error{
+		func() error { return os.Remove(path("kube/api-resources/nodes.yaml")) },
+		func() error { return ioutil.WriteFile(path("kube/api-resources/pods.yaml"), []byte("items:"), 0644) },
+		func() error {
+			return ioutil.WriteFile(path("kube/pods_logs/default_web_proxy.log"), []byte("appended\n"), 0644)
+		},
+		// The same size, but not the same content
+		func() error { return ioutil.WriteFile(path("README"), []byte("bundle\n"), 0644) },
+		func() error {
+			if err := os.Remove(path("node1/logs/kubelet.log")); err != nil {
+				return err
+			}
+			return os.Mkdir(path("node1/logs/kubelet.log"), 0755)
+		},
+		func() error {
+			if err := os.Remove(path("kube/api-resources/pods.current.yaml")); err != nil {
+				return err
+			}
+			return os.Symlink("nodes.yaml", path("kube/api-resources/pods.current.yaml"))
+		},
+		func() error {
+			return ioutil.WriteFile(path("kube/pods_logs/default_db_db.log"), []byte("added\n"), 0644)
+		},
+		// bunk's own files are not part of the bundle
+		func() error { return ioutil.WriteFile(path(".kbk/kubernetesResources.sql"), []byte("--\n"), 0644) },
+	} {
+		if err := change(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	problems, err := verifyBundle(bundleRootDir, manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []VerifyProblem{
+		{"README", "modified"},
+		{"kube/api-resources/nodes.yaml", "missing"},
+		{"kube/api-resources/pods.current.yaml", "symlink to nodes.yaml instead of pods.yaml"},
+		{"kube/api-resources/pods.yaml", "truncated to 6 of 10 bytes"},
+		{"kube/pods_logs/default_web_proxy.log", "size changed from 0 to 9 bytes"},
+		{"node1/logs/kubelet.log", "no longer a regular file"},
+		{"kube/pods_logs/default_db_db.log", "not in the manifest"},
+	}
+	if fmt.Sprint(problems) != fmt.Sprint(want) {
+		t.Errorf("verifyBundle =\n%v\nwant\n%v", problems, want)
+	}
+
+	if err := os.Remove(path("kube/api-resources/pods.current.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path("kube/api-resources/pods.current.yaml"), []byte("items: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if problem, err := verifyManifestFile(bundleRootDir, ManifestFile{Path: "kube/api-resources/pods.current.yaml", Link: "pods.yaml"}); err != nil || problem != "no longer a symlink" {
+		t.Errorf("verifyManifestFile of a symlink replaced by a file = %q, %v", problem, err)
+	}
+}
@@ -3,18 +3,15 @@ module github.com/some-things/bunk
 go 1.14
 
 require (
-	github.com/dsnet/compress v0.0.1 // indirect
 	github.com/fatih/color v1.9.0
-	github.com/golang/snappy v0.0.1 // indirect
+	github.com/klauspost/compress v1.11.4
+	github.com/mattn/go-isatty v0.0.11
 	github.com/mattn/go-sqlite3 v1.14.3
-	github.com/mholt/archiver v3.1.1+incompatible
+	github.com/mholt/archiver/v3 v3.5.1
 	github.com/mitchellh/go-homedir v1.1.0
-	github.com/nwaples/rardecode v1.1.0 // indirect
+	github.com/nwaples/rardecode v1.1.0
 	github.com/olekukonko/tablewriter v0.0.4
-	github.com/pierrec/lz4 v2.5.2+incompatible // indirect
 	github.com/spf13/cobra v1.0.0
 	github.com/spf13/viper v1.7.1
-	github.com/ulikunitz/xz v0.5.8 // indirect
-	github.com/xi2/xz v0.0.0-20171230120015-48954b6210f8 // indirect
 	sigs.k8s.io/yaml v1.2.0
 )